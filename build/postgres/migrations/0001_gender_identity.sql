@@ -0,0 +1,48 @@
+-- Moves existing databases from the two value sex enum to gender identities.
+-- Safe to run more than once and on databases created from the current
+-- init.sql. The legacy sex column and type are kept for older clients.
+
+begin;
+
+alter table dealls_bumble.users add column if not exists gender varchar(30);
+alter table dealls_bumble.users add column if not exists show_gender bool NOT NULL DEFAULT true;
+
+-- map legacy values with the default userv1.GenderMapping. The script runs
+-- in one transaction, so the users table stays locked until it commits.
+update dealls_bumble.users
+set gender = case sex when 'female' then 'woman' when 'male' then 'man' end
+where gender is null and sex is not null;
+
+-- no identity can be guessed for users without either, they need one before
+-- the column becomes required
+do $$
+declare
+    missing int;
+begin
+    select count(*) into missing from dealls_bumble.users where gender is null;
+    if missing > 0 then
+        raise exception '% users have neither sex nor gender, set their gender then migrate again', missing;
+    end if;
+end $$;
+
+alter table dealls_bumble.users alter column gender set not null;
+alter table dealls_bumble.users alter column sex drop not null;
+
+drop index if exists dealls_bumble.users_sex;
+create index if not exists users_gender on dealls_bumble.users using hash (gender);
+
+-- interested_in now holds gender groups instead of sex values
+do $$
+begin
+    if exists (
+        select 1 from information_schema.columns
+        where table_schema = 'dealls_bumble' and table_name = 'user_preferences'
+            and column_name = 'interested_in' and udt_name = '_sex'
+    ) then
+        alter table dealls_bumble.user_preferences
+            alter column interested_in type VARCHAR[]
+            using array_replace(array_replace(interested_in::varchar[], 'female', 'women'), 'male', 'men');
+    end if;
+end $$;
+
+commit;
@@ -0,0 +1,4 @@
+-- Adds the admin flag set by the user create-admin command. Safe to run more
+-- than once and on databases created from the current init.sql.
+
+alter table dealls_bumble.users add column if not exists is_admin bool NOT NULL DEFAULT false;
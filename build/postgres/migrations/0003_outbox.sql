@@ -0,0 +1,17 @@
+-- Adds the outbox of domain events relayed to in-process subscribers. Safe to
+-- run more than once and on databases created from the current init.sql.
+
+create table if not exists dealls_bumble.outbox
+(
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR NOT NULL,
+    aggregate VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error VARCHAR,
+    dead_at TIMESTAMP
+);
+
+create index if not exists outbox_pending on dealls_bumble.outbox (aggregate, id) where dead_at is null;
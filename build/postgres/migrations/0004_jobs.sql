@@ -0,0 +1,29 @@
+-- Adds the background job queue and its cron schedules. Safe to run more
+-- than once and on databases created from the current init.sql.
+
+create table if not exists dealls_bumble.jobs
+(
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    unique_key VARCHAR,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    last_error VARCHAR,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+create index if not exists jobs_due on dealls_bumble.jobs (run_at, id) where status in ('pending', 'running');
+create unique index if not exists jobs_unique_key on dealls_bumble.jobs (unique_key)
+    where unique_key is not null and status in ('pending', 'running');
+
+create table if not exists dealls_bumble.job_schedules
+(
+    name VARCHAR PRIMARY KEY,
+    spec VARCHAR NOT NULL,
+    next_run_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,31 @@
+-- Adds the webhook subscriptions and their delivery log. Safe to run more
+-- than once and on databases created from the current init.sql.
+
+create table if not exists dealls_bumble.webhook_subscriptions
+(
+    id BIGSERIAL PRIMARY KEY,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    event_types JSONB NOT NULL DEFAULT '[]',
+    enabled BOOL NOT NULL DEFAULT true,
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    disabled_at TIMESTAMP
+);
+
+create table if not exists dealls_bumble.webhook_deliveries
+(
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES dealls_bumble.webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL,
+    event_type VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_status_code INT,
+    last_error VARCHAR,
+    created_at TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP
+);
+
+create unique index if not exists webhook_deliveries_event on dealls_bumble.webhook_deliveries (subscription_id, event_id);
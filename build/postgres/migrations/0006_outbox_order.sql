@@ -0,0 +1,12 @@
+-- Records the transaction publishing each outbox event, the relay dispatches
+-- in its order. Safe to run more than once and on databases created from the
+-- current init.sql.
+
+begin;
+
+alter table dealls_bumble.outbox add column if not exists tx_id xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+drop index if exists dealls_bumble.outbox_pending;
+create index if not exists outbox_pending on dealls_bumble.outbox (aggregate, tx_id, id) where dead_at is null;
+
+commit;
@@ -0,0 +1,71 @@
+-- Adds the likes, locations, travel mode and match preferences to databases
+-- created before them. Safe to run more than once and on databases created
+-- from the current init.sql, so databases which applied it as 0000_matching
+-- run it again harmlessly.
+
+begin;
+
+-- distance filters without PostGIS
+create extension if not exists cube;
+create extension if not exists earthdistance;
+
+-- passes are kept next to likes, a user is swiped once
+alter table dealls_bumble.user_matches add column if not exists liked bool NOT NULL DEFAULT true;
+
+-- keeps the match, else the first swipe, of users swiped more than once
+delete from dealls_bumble.user_matches
+where id in (
+    select id from (
+        select id, row_number() over (partition by user_id, match_id order by matched desc, id) as rank
+        from dealls_bumble.user_matches
+    ) swipes
+    where rank > 1
+);
+
+create unique index if not exists user_matches_user_id_match_id on dealls_bumble.user_matches (user_id, match_id);
+create index if not exists user_matches_pending_likes on dealls_bumble.user_matches (match_id)
+    where liked and not matched and not is_deleted;
+
+-- locations and travel mode
+alter table dealls_bumble.users
+    add column if not exists latitude DOUBLE PRECISION check (latitude between -90 and 90),
+    add column if not exists longitude DOUBLE PRECISION check (longitude between -180 and 180),
+    add column if not exists location_updated_at TIMESTAMP,
+    add column if not exists travel_latitude DOUBLE PRECISION check (travel_latitude between -90 and 90),
+    add column if not exists travel_longitude DOUBLE PRECISION check (travel_longitude between -180 and 180),
+    add column if not exists travel_expires_at TIMESTAMP;
+
+create index if not exists users_location on dealls_bumble.users using gist (ll_to_earth(latitude, longitude));
+create index if not exists users_travel_location on dealls_bumble.users using gist (ll_to_earth(travel_latitude, travel_longitude))
+    where travel_expires_at is not null;
+
+-- user_location_history keeps real locations only, travel locations are never stored
+create table if not exists dealls_bumble.user_location_history
+(
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES dealls_bumble.users (id) ON DELETE CASCADE,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT current_timestamp
+);
+
+create index if not exists user_location_history_user_id on dealls_bumble.user_location_history (user_id, created_at);
+
+-- user_preferences, interested_in already holds gender groups, which
+-- 0001_gender_identity converted older tables to
+create table if not exists dealls_bumble.user_preferences
+(
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL UNIQUE REFERENCES dealls_bumble.users (id) ON DELETE CASCADE,
+    -- gender groups, see userv1.GenderMapping
+    interested_in VARCHAR[],
+    min_age INT check (min_age >= 18),
+    max_age INT check (max_age >= min_age),
+    max_distance_km INT check (max_distance_km > 0),
+    -- preferences enforced in both directions, see userv1.Preferences
+    dealbreakers VARCHAR[],
+    created_at TIMESTAMP DEFAULT current_timestamp,
+    updated_at TIMESTAMP DEFAULT current_timestamp
+);
+
+commit;
@@ -1,5 +1,9 @@
 CREATE SCHEMA dealls_bumble;
 
+-- distance filters without PostGIS
+create extension if not exists cube;
+create extension if not exists earthdistance;
+
 -- users
 -- drop type if exists sex;
 create type sex AS ENUM('female', 'male');
@@ -13,12 +17,22 @@ create table if not exists dealls_bumble.users
     email_verified bool NOT NULL DEFAULT false,
     username varchar(30) UNIQUE NOT NULL,
     hashed_password BYTEA NOT NULL,
-    sex sex NOT NULL,
+    gender varchar(30) NOT NULL,
+    show_gender bool NOT NULL DEFAULT true,
+    -- legacy, only set for genders with a legacy equivalent
+    sex sex,
     -- age  INT NOT NULL check (age >= 18),
     birthdate TIMESTAMP NOT NULL,
     verified bool NOT NULL DEFAULT false,
     max_swipes INT DEFAULT 10,
     premium_package_id BIGINT,
+    latitude DOUBLE PRECISION check (latitude between -90 and 90),
+    longitude DOUBLE PRECISION check (longitude between -180 and 180),
+    location_updated_at TIMESTAMP,
+    travel_latitude DOUBLE PRECISION check (travel_latitude between -90 and 90),
+    travel_longitude DOUBLE PRECISION check (travel_longitude between -180 and 180),
+    travel_expires_at TIMESTAMP,
+    is_admin bool NOT NULL DEFAULT false,
     created_at TIMESTAMP DEFAULT current_timestamp,
     is_deleted bool NOT NULL DEFAULT false
 );
@@ -27,7 +41,44 @@ create index if not exists users_uid on dealls_bumble.users using hash (uid);
 create index if not exists users_name on dealls_bumble.users using hash (name);
 create index if not exists users_email on dealls_bumble.users using hash (email);
 create index if not exists users_username on dealls_bumble.users using hash (username);
-create index if not exists users_sex on dealls_bumble.users using hash (sex);
+create index if not exists users_gender on dealls_bumble.users using hash (gender);
+create index if not exists users_location on dealls_bumble.users using gist (ll_to_earth(latitude, longitude));
+create index if not exists users_travel_location on dealls_bumble.users using gist (ll_to_earth(travel_latitude, travel_longitude))
+    where travel_expires_at is not null;
+
+-- user_location_history keeps real locations only, travel locations are never stored
+create table if not exists dealls_bumble.user_location_history
+(
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT current_timestamp
+);
+
+alter table dealls_bumble.user_location_history
+	add constraint fk_user_id foreign key (user_id) references dealls_bumble.users(id) on delete cascade;
+
+create index if not exists user_location_history_user_id on dealls_bumble.user_location_history (user_id, created_at);
+
+-- user_preferences
+create table if not exists dealls_bumble.user_preferences
+(
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL UNIQUE,
+    -- gender groups, see userv1.GenderMapping
+    interested_in VARCHAR[],
+    min_age INT check (min_age >= 18),
+    max_age INT check (max_age >= min_age),
+    max_distance_km INT check (max_distance_km > 0),
+    -- preferences enforced in both directions, see userv1.Preferences
+    dealbreakers VARCHAR[],
+    created_at TIMESTAMP DEFAULT current_timestamp,
+    updated_at TIMESTAMP DEFAULT current_timestamp
+);
+
+alter table dealls_bumble.user_preferences
+	add constraint fk_user_id foreign key (user_id) references dealls_bumble.users(id) on delete cascade;
 
 -- user_images
 create table if not exists dealls_bumble.user_images
@@ -48,6 +99,7 @@ create table if not exists dealls_bumble.user_matches (
     id SERIAL PRIMARY KEY,
     user_id BIGINT NOT NULL,
     match_id BIGINT NOT NULL,
+    liked bool NOT NULL default true,
     matched bool NOT NULL default false,
     created_at TIMESTAMP DEFAULT current_timestamp,
     is_deleted bool NOT NULL DEFAULT false
@@ -58,6 +110,10 @@ alter table dealls_bumble.user_matches
 alter table dealls_bumble.user_matches
 	add constraint fk_match_id foreign key (match_id) references dealls_bumble.users(id) on delete cascade;
 
+create unique index if not exists user_matches_user_id_match_id on dealls_bumble.user_matches (user_id, match_id);
+create index if not exists user_matches_pending_likes on dealls_bumble.user_matches (match_id)
+    where liked and not matched and not is_deleted;
+
 -- premium packages
 
 create table if not exists dealls_bumble.premium_packages
@@ -84,3 +140,81 @@ create table if not exists dealls_bumble.perks
 );
 
 create index if not exists perks_code_idx on dealls_bumble.perks using hash (perks_code);
+
+-- outbox, domain events written with the change they report, see events.Relay
+create table if not exists dealls_bumble.outbox
+(
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR NOT NULL,
+    aggregate VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error VARCHAR,
+    dead_at TIMESTAMP,
+    -- the publishing transaction, the relay dispatches in its order
+    tx_id xid8 NOT NULL DEFAULT pg_current_xact_id()
+);
+
+create index if not exists outbox_pending on dealls_bumble.outbox (aggregate, tx_id, id) where dead_at is null;
+
+-- jobs, background work claimed by the workers of jobs.Queue
+create table if not exists dealls_bumble.jobs
+(
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    unique_key VARCHAR,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    last_error VARCHAR,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+create index if not exists jobs_due on dealls_bumble.jobs (run_at, id) where status in ('pending', 'running');
+create unique index if not exists jobs_unique_key on dealls_bumble.jobs (unique_key)
+    where unique_key is not null and status in ('pending', 'running');
+
+-- job_schedules, the next run of each cron schedule
+create table if not exists dealls_bumble.job_schedules
+(
+    name VARCHAR PRIMARY KEY,
+    spec VARCHAR NOT NULL,
+    next_run_at TIMESTAMP NOT NULL
+);
+
+-- webhook_subscriptions, partner endpoints notified of domain events
+create table if not exists dealls_bumble.webhook_subscriptions
+(
+    id BIGSERIAL PRIMARY KEY,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    event_types JSONB NOT NULL DEFAULT '[]',
+    enabled BOOL NOT NULL DEFAULT true,
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    disabled_at TIMESTAMP
+);
+
+-- webhook_deliveries, the delivery log of each subscription
+create table if not exists dealls_bumble.webhook_deliveries
+(
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES dealls_bumble.webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL,
+    event_type VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_status_code INT,
+    last_error VARCHAR,
+    created_at TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP
+);
+
+create unique index if not exists webhook_deliveries_event on dealls_bumble.webhook_deliveries (subscription_id, event_id);
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewCommand returns the root command. Every subcommand loads the config from
+// the flags shared by all of them and sets up the logger before running.
+func NewCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "dealls-bumble",
+		Short:         "Dating service API and its maintenance commands",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return setup(cmd.Flags())
+		},
+	}
+	// completions would need the config to load
+	root.CompletionOptions.DisableDefaultCmd = true
+	root.PersistentFlags().AddFlagSet(config.NewFlagSet(root.Name()))
+
+	root.AddCommand(
+		newServeCommand(),
+		newMigrateCommand(),
+		newSeedCommand(),
+		newUserCommand(),
+		newPremiumCommand(),
+		newWebhookCommand(),
+		newTokenCommand(),
+		newConfigCommand(),
+	)
+
+	return root
+}
+
+// setup loads the config into config.GetConfig and sets up the global logger
+func setup(flags *pflag.FlagSet) error {
+	envConfig, err := config.Load(flags)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	// built from scratch, so running setup again does not stack fields
+	log.Logger = zerolog.New(os.Stderr).With().Timestamp().Caller().Logger()
+	zerolog.TimeFieldFormat = time.RFC3339
+	// zerolog.Ctx falls back to the global logger outside of requests
+	zerolog.DefaultContextLogger = &log.Logger
+
+	if envConfig.App.LogPretty {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, FormatTimestamp: func(i interface{}) string { return time.Now().Format(time.RFC3339) }})
+	}
+
+	// the log level of the config is the default of the runtime settings,
+	// which can change it while running
+	base := settings.Defaults()
+	if envConfig.App.LogLevel != "" {
+		base.LogLevel = envConfig.App.LogLevel
+	}
+	err = settings.Default().SetBase(base)
+	if err != nil {
+		return fmt.Errorf("error loading config: app.log_level: %w", err)
+	}
+	settings.Default().Subscribe(func(s settings.Settings) {
+		level, _ := settings.ParseLogLevel(s.LogLevel)
+		zerolog.SetGlobalLevel(level)
+	})
+
+	return nil
+}
+
+// userError returns err with the English text of its message IDs, services
+// leave translating to the caller
+func userError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return errors.New(i18n.Default().Localizer(i18n.DefaultLang).Error(err))
+}
+
+func newServeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the API until SIGTERM",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Serve()
+		},
+	}
+}
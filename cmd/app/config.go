@@ -0,0 +1,44 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/spf13/cobra"
+)
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	check := &cobra.Command{
+		Use:   "check",
+		Short: "Validate the config and the files it names without starting anything",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the config itself was validated while loading
+			cfg := config.GetConfig()
+			err := loadFiles(cfg)
+			if err != nil {
+				return err
+			}
+
+			if cfg.App.SettingsFile != "" {
+				// a copy of the store, checking does not apply anything
+				err = settings.NewStore(settings.Default().Get()).LoadFile(cfg.App.SettingsFile)
+				if err != nil {
+					return fmt.Errorf("error loading settings: %w", err)
+				}
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "config is valid")
+			return nil
+		},
+	}
+	cmd.AddCommand(check)
+
+	return cmd
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/config/postgres"
+	"github.com/farolinar/dealls-bumble/internal/common/migrate"
+	"github.com/spf13/cobra"
+)
+
+func newMigrateCommand() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply the database migrations not applied yet",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := connectDB(cmd.Context(), config.GetConfig())
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %w", err)
+			}
+			defer db.Close()
+
+			// migrations take as long as they take, waiting for the lock
+			// included
+			ctx := postgres.WithoutQueryTimeout(cmd.Context())
+			applied, err := migrate.Up(ctx, db.SQL, os.DirFS(dir))
+			for _, version := range applied {
+				fmt.Fprintf(cmd.OutOrStdout(), "applied %s\n", version)
+			}
+			if err != nil {
+				return err
+			}
+			if len(applied) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "database is up to date")
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "build/postgres/migrations", "directory of the .sql migrations")
+
+	return cmd
+}
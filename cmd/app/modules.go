@@ -0,0 +1,18 @@
+package app
+
+import (
+	"github.com/farolinar/dealls-bumble/internal/container"
+	matchv1 "github.com/farolinar/dealls-bumble/services/v1/match"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	webhookv1 "github.com/farolinar/dealls-bumble/services/v1/webhook"
+)
+
+// Modules are the domains served by the app, a new service only needs to be
+// added here
+func Modules() []container.Module {
+	return []container.Module{
+		userv1.Module{},
+		matchv1.Module{},
+		webhookv1.Module{},
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	"github.com/spf13/cobra"
+)
+
+func newPremiumCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "premium",
+		Short: "Manage the premium packages of users",
+	}
+	cmd.AddCommand(newPremiumGrantCommand())
+
+	return cmd
+}
+
+func newPremiumGrantCommand() *cobra.Command {
+	var userUID string
+	var packageID int64
+
+	cmd := &cobra.Command{
+		Use:   "grant",
+		Short: "Give a user a premium package, recorded as a purchase",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.GetConfig()
+			err := loadFiles(cfg)
+			if err != nil {
+				return err
+			}
+
+			db, err := connectDB(context.Background(), cfg)
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %w", err)
+			}
+			defer db.Close()
+
+			service := premiumv1.NewService(txn.NewTxManager(db.SQL), events.NewOutbox(db.SQL, clock.System()),
+				premiumv1.NewRepository(db.SQL))
+			title, err := service.GrantPackage(cmd.Context(), userUID, packageID)
+			if err != nil {
+				return userError(err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "granted %s to %s\n", title, userUID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&userUID, "uid", "", "UID of the user")
+	cmd.Flags().Int64Var(&packageID, "package", 0, "ID of the premium package")
+	_ = cmd.MarkFlagRequired("uid")
+	_ = cmd.MarkFlagRequired("package")
+
+	return cmd
+}
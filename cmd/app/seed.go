@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/config/postgres"
+	"github.com/farolinar/dealls-bumble/internal/common/blob"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/seed"
+	matchv1 "github.com/farolinar/dealls-bumble/services/v1/match"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/spf13/cobra"
+)
+
+func newSeedCommand() *cobra.Command {
+	opts := seed.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Fill the database with fake users, preferences, photos and swipes for local development",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.GetConfig()
+			db, err := connectDB(cmd.Context(), cfg)
+			if err != nil {
+				return fmt.Errorf("error connecting to database: %w", err)
+			}
+			defer db.Close()
+
+			opts.BCryptSalt = cfg.App.BCryptSalt
+			opts.Now = clock.System().Now()
+			seeder := seed.Seeder{
+				Tx:      txn.NewTxManager(db.SQL),
+				Users:   userv1.NewRepository(db.SQL),
+				Matches: matchv1.NewRepository(db.SQL),
+			}
+			// photos go to the directory serve reads, without one they would
+			// be lost with the command
+			if cfg.App.BlobDir != "" {
+				seeder.Blobs, err = blob.Dir(cfg.App.BlobDir)
+				if err != nil {
+					return fmt.Errorf("error opening blob directory: %w", err)
+				}
+			} else {
+				fmt.Fprintln(cmd.ErrOrStderr(), "app.blob_dir is not set, creating no photos")
+			}
+			// bulk loads outlast the default query timeout
+			result, err := seeder.Run(postgres.WithoutQueryTimeout(cmd.Context()), opts)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "created %d users, %d photos and %d swipes making %d matches, their password is %s\n",
+				result.Users, result.Photos, result.Swipes, result.Matches, seed.Password)
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&opts.Users, "users", 100, "number of users to create")
+	cmd.Flags().Int64Var(&opts.Seed, "seed", 1, "seed of the generated data, the same seed creates the same users")
+	cmd.Flags().IntVar(&opts.SwipesPerUser, "swipes", 20, "number of other users each user swipes on")
+
+	return cmd
+}
@@ -2,95 +2,144 @@ package app
 
 import (
 	"context"
-	"errors"
+	"database/sql"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/farolinar/dealls-bumble/cmd/readiness"
 	"github.com/farolinar/dealls-bumble/config"
 	"github.com/farolinar/dealls-bumble/config/postgres"
-	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/container"
 	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
-	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 )
 
-func Initialize(cfg config.AppConfig) *mux.Router {
-
-	postgresDB, _ := postgres.NewDBPostgreOptionBuilder(cfg).WithHost(cfg.Postgres.Host).
-		WithPort(cfg.Postgres.Port).WithUsername(cfg.Postgres.Username).
-		WithDBName(cfg.Postgres.DbName).Build()
+// Initialize loads the startup files and builds the App on db with the
+// default modules, extra options override the default dependencies
+func Initialize(cfg config.AppConfig, db *sql.DB, opts ...container.Option) (*container.App, error) {
+	err := loadFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := postgresDB.NewPostgreDatabase()
+	opts = append([]container.Option{
+		container.WithDB(db),
+		container.WithModules(Modules()...),
+	}, opts...)
 
+	a, err := container.New(cfg, opts...)
 	if err != nil {
-		log.Fatal().Msgf("Error connecting to database, will exit | %s", err.Error())
+		return nil, err
+	}
+
+	if cfg.App.SettingsFile != "" {
+		a.AddWorker(settings.NewWatcher(a.Settings, cfg.App.SettingsFile))
 	}
 
-	r := mux.NewRouter()
-	r.Use(middleware.Logging)
-	r.Use(middleware.PanicRecoverer)
-	v1 := r.PathPrefix("/v1").Subrouter()
+	return a, nil
+}
 
-	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "text")
-		w.WriteHeader(http.StatusOK)
-		_, err := io.WriteString(w, "Service ready")
+// loadFiles loads the optional files named by the config
+func loadFiles(cfg config.AppConfig) error {
+	if cfg.App.GenderMappingFile != "" {
+		err := userv1.LoadGenderMapping(cfg.App.GenderMappingFile)
 		if err != nil {
-			log.Fatal().Msgf("Error checking service: %s", err.Error())
+			return fmt.Errorf("error loading gender mapping: %w", err)
 		}
-	})
+	}
 
-	healthCheck := r.PathPrefix("/health-check").Subrouter()
-	healthCheck.HandleFunc("/db", readiness.DBReadinessHandler)
+	if cfg.App.LocalesDir != "" {
+		err := i18n.Default().LoadDir(cfg.App.LocalesDir)
+		if err != nil {
+			return fmt.Errorf("error loading locales: %w", err)
+		}
+	}
+	log.Info().Strs("languages", i18n.Default().Languages()).Msg("Locales loaded")
 
-	// initialize user domain
-	userRepository := userv1.NewRepository(db)
-	userService := userv1.NewService(cfg, userRepository)
-	userHandler := userv1.NewHandler(cfg, userService)
+	return nil
+}
 
-	ur := v1.PathPrefix("/user").Subrouter()
-	ur.HandleFunc("/register", userHandler.CreateUser).Methods(http.MethodPost)
-	ur.HandleFunc("/login", userHandler.Login).Methods(http.MethodPost)
+// connectDB connects the primary only, the commands besides serve do not
+// read from replicas
+func connectDB(ctx context.Context, cfg config.AppConfig) (*postgres.DB, error) {
+	cfg.Postgres.Replicas = nil
+	return postgres.Open(ctx, cfg.Postgres)
+}
 
-	return r
+// replicaSet registers the metrics of the replica pools of db and returns
+// the set reading from them
+func replicaSet(db *postgres.DB, cfg config.AppConfig) *replica.Set {
+	replicas := make([]replica.Replica, len(db.Replicas))
+	for i, r := range db.Replicas {
+		name := fmt.Sprintf("replica_%d", i+1)
+		replicas[i] = replica.Replica{Name: name, DB: r.SQL}
+
+		err := metrics.RegisterPool(r.Pool, cfg.Postgres.DbName+"_"+name)
+		if err != nil {
+			log.Error().Msgf("Error registering database metrics of %s | %s", name, err.Error())
+		}
+	}
+	return replica.NewSet(db.SQL, replicas)
 }
 
-func Serve() {
+// Serve runs the app until SIGTERM. It returns an error when the app could
+// not start, after shutting down what did, so the process exits non-zero.
+func Serve() error {
 	cfg := config.GetConfig()
 
-	r := Initialize(cfg)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.App.Name, cfg.App.TraceExporter)
+	if err != nil {
+		return fmt.Errorf("error setting up tracing: %w", err)
+	}
 
-	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.App.Port),
-		Handler: r,
+	db, err := postgres.Open(context.Background(), cfg.Postgres)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
 	}
+	defer db.Close()
 
-	go func() {
-		log.Info().Msg(fmt.Sprintf("HTTP server listening on %s", httpServer.Addr))
-		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			log.Error().Msg(fmt.Sprintf("HTTP server error: %v", err))
-		}
-		log.Info().Msg("Stopped serving new connections.")
-	}()
+	err = metrics.RegisterPool(db.Pool, cfg.Postgres.DbName)
+	if err != nil {
+		log.Error().Msgf("Error registering database metrics | %s", err.Error())
+	}
+
+	a, err := Initialize(cfg, db.SQL, container.WithReplicas(replicaSet(db, cfg)))
+	if err != nil {
+		return fmt.Errorf("error initializing app: %w", err)
+	}
 
 	// Listen for the termination signal
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-	// Block until termination signal received
-	<-stop
-	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
+	startErr := a.Start(context.Background())
+	if startErr != nil {
+		log.Error().Msgf("Error starting app | %s", startErr.Error())
+	} else {
+		// Block until termination signal received
+		<-stop
+	}
+
+	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), cfg.App.ShutdownDelay+10*time.Second)
 	defer shutdownRelease()
 
-	log.Info().Msg(fmt.Sprintf("Shutting down HTTP server listening on %s", httpServer.Addr))
-	if err := httpServer.Shutdown(shutdownCtx); err != nil {
-		log.Error().Msg(fmt.Sprintf("HTTP server shutdown error: %v", err))
+	if err := a.Stop(shutdownCtx); err != nil {
+		log.Error().Msg(fmt.Sprintf("App shutdown error: %v", err))
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error().Msg(fmt.Sprintf("Tracing shutdown error: %v", err))
 	}
 	log.Info().Msg("Shutdown complete.")
+
+	if startErr != nil {
+		return fmt.Errorf("error starting app: %w", startErr)
+	}
+	return nil
 }
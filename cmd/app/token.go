@@ -0,0 +1,37 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/auth"
+	"github.com/spf13/cobra"
+)
+
+func newTokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage access tokens",
+	}
+
+	var userUID string
+	issue := &cobra.Command{
+		Use:   "issue",
+		Short: "Print an access token of a user, for debugging",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := auth.CreateAccessToken(config.GetConfig(), userUID)
+			if err != nil {
+				return fmt.Errorf("error creating access token: %w", err)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), token)
+			return nil
+		},
+	}
+	issue.Flags().StringVar(&userUID, "uid", "", "UID of the user")
+	_ = issue.MarkFlagRequired("uid")
+	cmd.AddCommand(issue)
+
+	return cmd
+}
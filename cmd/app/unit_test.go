@@ -0,0 +1,73 @@
+package app_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	cmdapp "github.com/farolinar/dealls-bumble/cmd/app"
+	"github.com/farolinar/dealls-bumble/internal/common/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_Unit_Commands(t *testing.T) {
+	t.Setenv("APP_SECRET", "secret")
+	t.Setenv("APP_JWT_SECRET", "jwt_secret")
+	t.Setenv("POSTGRES_PASSWORD", "password")
+
+	run := func(args ...string) (string, error) {
+		cmd := cmdapp.NewCommand()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		cmd.SetIn(strings.NewReader(""))
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	t.Run("Token issue signs the uid", func(t *testing.T) {
+		out, err := run("token", "issue", "--uid", "uid123")
+		assert.NoError(t, err)
+		subject, err := jwt.VerifyAndGetSubject("secret", strings.TrimSpace(out))
+		assert.NoError(t, err)
+		assert.Equal(t, "uid123", subject)
+	})
+
+	t.Run("Config check reports invalid settings", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "settings.yaml")
+		assert.NoError(t, os.WriteFile(path, []byte("swipe_daily_limit: -1\n"), 0o600))
+
+		out, err := run("config", "check")
+		assert.NoError(t, err)
+		assert.Equal(t, "config is valid\n", out)
+
+		_, err = run("config", "check", "--app.settings_file", path)
+		assert.ErrorContains(t, err, "swipe_daily_limit")
+
+		_, err = run("config", "check", "--app.port", "70000")
+		assert.ErrorContains(t, err, "app.port must be at most 65535")
+	})
+
+	t.Run("Serve fails instead of exiting", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+
+		_, err = run("serve", "--postgres.host", "127.0.0.1", "--postgres.port", port, "--postgres.timeout", "1s")
+		assert.ErrorContains(t, err, "error connecting to database")
+	})
+
+	t.Run("Create admin validates before connecting", func(t *testing.T) {
+		_, err := run("user", "create-admin", "--name", "Admin", "--password", "weak")
+		assert.ErrorContains(t, err, "username: cannot be blank")
+	})
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/spf13/cobra"
+)
+
+func newUserCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Manage users",
+	}
+	cmd.AddCommand(newCreateAdminCommand(), newResetPasswordCommand())
+
+	return cmd
+}
+
+// withUserService runs fn with a user service on a new database connection
+func withUserService(fn func(service userv1.Service) error) error {
+	cfg := config.GetConfig()
+	err := loadFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := connectDB(context.Background(), cfg)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	clk := clock.System()
+	return fn(userv1.NewService(cfg, clk, settings.Default(), uid.Default(), txn.NewTxManager(db.SQL), events.NewOutbox(db.SQL, clk),
+		userv1.NewRepository(db.SQL), premiumv1.NewRepository(db.SQL)))
+}
+
+func newCreateAdminCommand() *cobra.Command {
+	var payload userv1.UserCreatePayload
+
+	cmd := &cobra.Command{
+		Use:   "create-admin",
+		Short: "Create an admin user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if payload.Password == "" {
+				payload.Password, err = readPassword(cmd)
+				if err != nil {
+					return
+				}
+			}
+
+			payload = payload.NewLayoutDateOnly()
+			err = payload.Validate()
+			if err != nil {
+				return userError(err)
+			}
+
+			return withUserService(func(service userv1.Service) error {
+				user, err := service.CreateAdmin(cmd.Context(), payload)
+				if err != nil {
+					return userError(err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "created admin %s with UID %s\n", user.Username, user.UID)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&payload.Name, "name", "", "name of the admin")
+	cmd.Flags().StringVar(&payload.Email, "email", "", "email of the admin")
+	cmd.Flags().StringVar(&payload.Username, "username", "", "username of the admin")
+	cmd.Flags().StringVar(&payload.Password, "password", "", "password of the admin, read from standard input when not set")
+	cmd.Flags().StringVar((*string)(&payload.Gender), "gender", "", "gender identity of the admin")
+	cmd.Flags().StringVar(&payload.Birthdate, "birthdate", "", "birthdate of the admin, YYYY-MM-DD")
+
+	return cmd
+}
+
+func newResetPasswordCommand() *cobra.Command {
+	var payload userv1.UserResetPasswordPayload
+
+	cmd := &cobra.Command{
+		Use:   "reset-password",
+		Short: "Replace the password of a user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if payload.Password == "" {
+				payload.Password, err = readPassword(cmd)
+				if err != nil {
+					return
+				}
+			}
+
+			err = payload.Validate()
+			if err != nil {
+				return userError(err)
+			}
+
+			return withUserService(func(service userv1.Service) error {
+				err := service.ResetPassword(cmd.Context(), payload)
+				if err != nil {
+					return userError(err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "password of %s reset\n", payload.Username)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&payload.Username, "username", "", "username of the user")
+	cmd.Flags().StringVar(&payload.Password, "password", "", "new password, read from standard input when not set")
+
+	return cmd
+}
+
+// readPassword reads the first line of standard input, which keeps passwords
+// out of the shell history
+func readPassword(cmd *cobra.Command) (string, error) {
+	fmt.Fprint(cmd.ErrOrStderr(), "Password: ")
+	line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("error reading password: %w", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
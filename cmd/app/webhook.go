@@ -0,0 +1,221 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/jobs"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	webhookv1 "github.com/farolinar/dealls-bumble/services/v1/webhook"
+	"github.com/spf13/cobra"
+)
+
+func newWebhookCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "webhook",
+		Short: "Manage the webhook subscriptions of partner systems",
+	}
+	cmd.AddCommand(
+		newWebhookAddCommand(),
+		newWebhookListCommand(),
+		newWebhookEnableCommand(true),
+		newWebhookEnableCommand(false),
+		newWebhookDeliveriesCommand(),
+		newWebhookReplayCommand(),
+	)
+
+	return cmd
+}
+
+// withWebhookService runs fn with a webhook service on a new database
+// connection, the deliveries it enqueues are sent by the served app
+func withWebhookService(fn func(service webhookv1.Service) error) error {
+	cfg := config.GetConfig()
+	err := loadFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := connectDB(context.Background(), cfg)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	clk := clock.System()
+	tx := txn.NewTxManager(db.SQL)
+	return fn(webhookv1.NewService(clk, uid.Default(), tx, jobs.NewQueue(db.SQL, tx, clk), webhookv1.NewRepository(db.SQL)))
+}
+
+// parseID parses the ID argument of a command
+func parseID(arg string) (int64, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q", arg)
+	}
+	return id, nil
+}
+
+func newWebhookAddCommand() *cobra.Command {
+	var payload webhookv1.SubscriptionPayload
+
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Subscribe a URL to events and print its signing secret",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := payload.Validate()
+			if err != nil {
+				return userError(err)
+			}
+
+			return withWebhookService(func(service webhookv1.Service) error {
+				sub, err := service.Subscribe(cmd.Context(), payload)
+				if err != nil {
+					return userError(err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "created subscription %d, signing secret %s\n", sub.ID, sub.Secret)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&payload.URL, "url", "", "http or https URL receiving the events")
+	cmd.Flags().StringSliceVar(&payload.EventTypes, "events", nil, "comma separated event types to send, every type when not set")
+	_ = cmd.MarkFlagRequired("url")
+
+	return cmd
+}
+
+func newWebhookListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the subscriptions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withWebhookService(func(service webhookv1.Service) error {
+				subs, err := service.ListSubscriptions(cmd.Context())
+				if err != nil {
+					return userError(err)
+				}
+
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tURL\tEVENTS\tENABLED\tFAILURES")
+				for _, sub := range subs {
+					eventTypes := "*"
+					if len(sub.EventTypes) > 0 {
+						eventTypes = strings.Join(sub.EventTypes, ",")
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%d\n", sub.ID, sub.URL, eventTypes, sub.Enabled, sub.ConsecutiveFailures)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+// newWebhookEnableCommand returns the enable command, or the disable command
+// when enabled is false
+func newWebhookEnableCommand(enabled bool) *cobra.Command {
+	use, short, done := "enable", "Enable a subscription and clear its failures", "enabled"
+	if !enabled {
+		use, short, done = "disable", "Stop sending events to a subscription", "disabled"
+	}
+
+	return &cobra.Command{
+		Use:   use + " ID",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+
+			return withWebhookService(func(service webhookv1.Service) error {
+				err := service.SetEnabled(cmd.Context(), id, enabled)
+				if err != nil {
+					return userError(err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "subscription %d %s\n", id, done)
+				return nil
+			})
+		},
+	}
+}
+
+func newWebhookDeliveriesCommand() *cobra.Command {
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "deliveries SUBSCRIPTION_ID",
+		Short: "List the latest deliveries of a subscription",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			if limit < 1 {
+				return fmt.Errorf("limit must be at least 1")
+			}
+
+			return withWebhookService(func(service webhookv1.Service) error {
+				deliveries, err := service.ListDeliveries(cmd.Context(), id, limit)
+				if err != nil {
+					return userError(err)
+				}
+
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tEVENT\tTYPE\tSTATUS\tATTEMPTS\tLAST STATUS\tLAST ERROR\tCREATED")
+				for _, d := range deliveries {
+					lastStatus, lastError := "-", "-"
+					if d.LastStatusCode != nil {
+						lastStatus = strconv.Itoa(*d.LastStatusCode)
+					}
+					if d.LastError != nil {
+						lastError = *d.LastError
+					}
+					fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n", d.ID, d.EventID, d.EventType, d.Status, d.Attempts,
+						lastStatus, lastError, d.CreatedAt.Format(time.RFC3339))
+				}
+				return w.Flush()
+			})
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 20, "how many deliveries to list")
+
+	return cmd
+}
+
+func newWebhookReplayCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "replay DELIVERY_ID",
+		Short: "Send a delivery again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+
+			return withWebhookService(func(service webhookv1.Service) error {
+				err := service.Replay(cmd.Context(), id)
+				if err != nil {
+					return userError(err)
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "delivery %d queued\n", id)
+				return nil
+			})
+		},
+	}
+}
@@ -0,0 +1,34 @@
+// Command errdoc generates the error catalog document from the errors the
+// services register with servicebase.NewAppError.
+//
+//	go run ./cmd/errdoc -o docs/errors.md
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	_ "github.com/farolinar/dealls-bumble/services/v1/match"
+	_ "github.com/farolinar/dealls-bumble/services/v1/premium"
+	_ "github.com/farolinar/dealls-bumble/services/v1/user"
+	_ "github.com/farolinar/dealls-bumble/services/v1/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	output := flag.String("o", "docs/errors.md", "output file")
+	flag.Parse()
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error creating output file")
+	}
+	defer f.Close()
+
+	err = servicebase.WriteErrorCatalog(f, i18n.Default())
+	if err != nil {
+		log.Fatal().Err(err).Msg("error writing error catalog")
+	}
+}
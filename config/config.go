@@ -1,83 +1,18 @@
 package config
 
 import (
-	"database/sql"
-	"fmt"
-	"log"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/joho/godotenv"
-
-	"github.com/go-playground/validator/v10"
-	"github.com/iamolegga/enviper"
 	_ "github.com/jackc/pgx/v5/stdlib"
-
-	"github.com/spf13/viper"
-	"gopkg.in/gorp.v2"
 )
 
-// Provider the config provider
-type Provider interface {
-	ConfigFileUsed() string
-	Get(key string) interface{}
-	GetBool(key string) bool
-	GetDuration(key string) time.Duration
-	GetFloat64(key string) float64
-	GetInt(key string) int
-	GetInt64(key string) int64
-	GetSizeInBytes(key string) uint
-	GetString(key string) string
-	GetStringMap(key string) map[string]interface{}
-	GetStringMapString(key string) map[string]string
-	GetStringMapStringSlice(key string) map[string][]string
-	GetStringSlice(key string) []string
-	GetTime(key string) time.Time
-	InConfig(key string) bool
-	IsSet(key string) bool
-}
-
 var appConfig AppConfig
 
+// GetConfig returns the config of the last successful Load
 func GetConfig() AppConfig {
 	return appConfig
 }
 
-func LoadEnvConfig() (conf AppConfig, err error) {
-	v := viper.New()
-
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AllowEmptyEnv(true)
-	v.AutomaticEnv()
-
-	dirPath, err := os.Getwd()
-	if err != nil {
-		panic(fmt.Errorf("error get working dir: %s", err))
-	}
-
-	err = godotenv.Load(fmt.Sprintf("%s/.env", dirPath))
-	if err != nil {
-		return
-	}
-
-	e := enviper.New(v)
-	err = e.Unmarshal(&conf)
-	if err != nil {
-		return
-	}
-
-	validate := validator.New()
-	err = validate.Struct(conf)
-	if err != nil {
-		return
-	}
-
-	appConfig = conf
-
-	return
-}
-
 type AppConfig struct {
 	App      App      `mapstructure:"app" validate:"required"`
 	Postgres Postgres `mapstructure:"postgres" validate:"required"`
@@ -86,48 +21,53 @@ type AppConfig struct {
 type App struct {
 	Secret          string `mapstructure:"secret" validate:"required"`
 	Host            string `mapstructure:"host" validate:"required"`
-	Port            int    `mapstructure:"port" validate:"required"`
+	Port            int    `mapstructure:"port" validate:"required,min=1,max=65535"`
 	Name            string `mapstructure:"name" validate:"required"`
-	LogPretty       bool   `mapstructure:"log_pretty" validate:"required"`
+	LogPretty       bool   `mapstructure:"log_pretty"`
 	LogLevel        string `mapstructure:"log_level" validate:"required"`
 	BCryptSalt      int    `mapstructure:"bcrypt_salt" validate:"required"`
 	JWTSecret       string `mapstructure:"jwt_secret" validate:"required"`
 	JWTHourDuration int    `mapstructure:"jwt_hour_duration" validate:"required"`
+	// GenderMappingFile optionally replaces the default gender mapping
+	GenderMappingFile string `mapstructure:"gender_mapping_file"`
+	// LocalesDir optionally adds or overrides catalogs with <lang>.json files
+	LocalesDir string `mapstructure:"locales_dir"`
+	// BlobDir optionally keeps blobs such as photos as files in a directory,
+	// shared by serve and seed, instead of in memory
+	BlobDir string `mapstructure:"blob_dir"`
+	// MetricsPort optionally serves /metrics on its own port instead of the
+	// API port
+	MetricsPort int `mapstructure:"metrics_port" validate:"min=0,max=65535"`
+	// TraceExporter is otlp, stdout or none, the default
+	TraceExporter string `mapstructure:"trace_exporter" validate:"omitempty,oneof=otlp stdout none"`
+	// ShutdownDelay is how long readiness fails before the server stops
+	// accepting connections, giving load balancers time to notice
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+	// SettingsFile optionally names a YAML file of runtime settings, reloaded
+	// whenever it changes
+	SettingsFile string `mapstructure:"settings_file"`
+	// TrustedProxies are the IPs or CIDRs of the proxies in front of the
+	// service, whose X-Forwarded-For is believed, comma separated in the
+	// environment and flags
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
+// Postgres configures the connection pool, see postgres.ParseConfig
 type Postgres struct {
 	Host            string        `mapstructure:"host" validate:"required"`
-	Port            int           `mapstructure:"port" validate:"required"`
+	Port            int           `mapstructure:"port" validate:"required,min=1,max=65535"`
 	Username        string        `mapstructure:"username" validate:"required"`
 	Password        string        `mapstructure:"password" validate:"required"`
 	DbName          string        `mapstructure:"name" validate:"required"`
 	Params          string        `mapstructure:"params" validate:"required"`
-	ConnPoolSize    int           `mapstructure:"conn_pool_size" validate:"required"`
-	ConnIdleMax     int           `mapstructure:"conn_idle_max" validate:"required"`
+	ConnPoolSize    int           `mapstructure:"conn_pool_size" validate:"required,min=1"`
+	ConnIdleMax     int           `mapstructure:"conn_idle_max" validate:"min=0"`
 	ConnLifetimeMax time.Duration `mapstructure:"conn_lifetime_max" validate:"required"`
 	Timeout         time.Duration `mapstructure:"timeout" validate:"required"`
-}
-
-type DbConnection struct{}
-
-var gorpDb *gorp.DbMap
-
-func (d *DbConnection) InitDbConnectionPool(db Postgres) {
-	DB, err := sql.Open("pgx",
-		fmt.Sprintf("host=%s "+
-			"port=%d "+
-			"user=%s "+
-			"password=%s "+
-			"dbname=%s "+
-			"%s", db.Host, db.Port, db.Username, db.Password, db.DbName, db.Params))
-
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	gorpDb = &gorp.DbMap{Db: DB, Dialect: gorp.PostgresDialect{}}
-}
-
-func (d *DbConnection) GetDbConnectionPool() *gorp.DbMap {
-	return gorpDb
+	// StatementCacheCapacity is the number of prepared statements cached by
+	// each connection, 0 disables the cache
+	StatementCacheCapacity int `mapstructure:"statement_cache_capacity" validate:"min=0"`
+	// Replicas are the connection strings of read replicas, comma separated
+	// in the environment and flags. Their pools are sized like the primary.
+	Replicas []string `mapstructure:"replicas"`
 }
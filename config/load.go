@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/joho/godotenv"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// FlagConfigFile names the optional YAML config file, CONFIG_FILE in the
+// environment
+const FlagConfigFile = "config"
+
+// Defaults are the values of the keys not set by any source. Secrets have no
+// default.
+var Defaults = map[string]interface{}{
+	"app.host":                          "0.0.0.0",
+	"app.port":                          8080,
+	"app.name":                          "dealls_bumble",
+	"app.log_pretty":                    false,
+	"app.log_level":                     "INFO",
+	"app.bcrypt_salt":                   12,
+	"app.jwt_hour_duration":             2,
+	"app.trace_exporter":                "none",
+	"app.trusted_proxies":               []string{},
+	"postgres.host":                     "localhost",
+	"postgres.port":                     5432,
+	"postgres.name":                     "dealls_bumble",
+	"postgres.username":                 "dealls_bumble",
+	"postgres.params":                   "sslmode=disable",
+	"postgres.conn_pool_size":           10,
+	"postgres.conn_idle_max":            10,
+	"postgres.conn_lifetime_max":        "30m",
+	"postgres.timeout":                  "10s",
+	"postgres.statement_cache_capacity": 512,
+	"postgres.replicas":                 []string{},
+}
+
+// Keys returns every config key, like app.port, in declaration order
+func Keys() []string {
+	return keysOf(reflect.TypeOf(AppConfig{}), "")
+}
+
+func keysOf(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == t.PkgPath() {
+			keys = append(keys, keysOf(field.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// EnvName returns the environment variable of key, APP_PORT for app.port
+func EnvName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// NewFlagSet returns the flags overriding the config, --app.port for app.port,
+// and --config naming the YAML file
+func NewFlagSet(name string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.String(FlagConfigFile, "", "YAML config file, also read from CONFIG_FILE")
+	for _, key := range Keys() {
+		flags.String(key, "", fmt.Sprintf("overrides %s, also read from %s", key, EnvName(key)))
+	}
+	return flags
+}
+
+// Load reads the config from layered sources, each overriding the previous
+// one: Defaults, the YAML file, a .env file in the working directory, the
+// environment and flags parsed by a NewFlagSet, which may be nil. Every key
+// can be read from a file named by <ENV>_FILE instead, meant for secrets.
+func Load(flags *pflag.FlagSet) (conf AppConfig, err error) {
+	v := viper.New()
+	v.AllowEmptyEnv(true)
+
+	for _, key := range Keys() {
+		v.SetDefault(key, Defaults[key])
+	}
+
+	configFile := os.Getenv("CONFIG_FILE")
+	if flags != nil && flags.Changed(FlagConfigFile) {
+		configFile, _ = flags.GetString(FlagConfigFile)
+	}
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		v.SetConfigType("yaml")
+		err = v.ReadInConfig()
+		if err != nil {
+			return conf, fmt.Errorf("error reading config file %s: %w", configFile, err)
+		}
+	}
+
+	// variables already in the environment win over the .env file
+	err = godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return conf, fmt.Errorf("error reading .env: %w", err)
+	}
+
+	for _, key := range Keys() {
+		err = bindEnv(v, key)
+		if err != nil {
+			return
+		}
+	}
+
+	if flags != nil {
+		for _, key := range Keys() {
+			if flags.Changed(key) {
+				value, _ := flags.GetString(key)
+				v.Set(key, value)
+			}
+		}
+	}
+
+	err = v.Unmarshal(&conf)
+	if err != nil {
+		return conf, fmt.Errorf("error decoding config: %w", err)
+	}
+
+	err = Validate(conf)
+	if err != nil {
+		return
+	}
+
+	appConfig = conf
+	return
+}
+
+func bindEnv(v *viper.Viper, key string) error {
+	env := EnvName(key)
+	file, fromFile := os.LookupEnv(env + "_FILE")
+	if !fromFile {
+		return v.BindEnv(key, env)
+	}
+
+	if _, ok := os.LookupEnv(env); ok {
+		return fmt.Errorf("set only one of %s and %s_FILE", env, env)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s_FILE: %w", env, err)
+	}
+	v.Set(key, strings.TrimRight(string(data), "\r\n"))
+
+	return nil
+}
+
+// ValidationError lists every invalid key of the config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks conf against the validate tags, naming each offending key
+// with where it can be set
+func Validate(conf AppConfig) error {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return field.Tag.Get("mapstructure")
+	})
+
+	err := validate.Struct(conf)
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	problems := make([]string, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		// the namespace starts with the root struct, AppConfig.app.port
+		_, key, _ := strings.Cut(fieldErr.Namespace(), ".")
+		problems[i] = fmt.Sprintf("%s %s: set %s (or %s_FILE), --%s or %s in the config file",
+			key, describe(fieldErr), EnvName(key), EnvName(key), key, key)
+	}
+
+	return &ValidationError{Problems: problems}
+}
+
+func describe(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		return "must be at most " + fieldErr.Param()
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fieldErr.Param(), " ", ", ")
+	default:
+		return fmt.Sprintf("is invalid (%s)", fieldErr.Tag())
+	}
+}
@@ -2,148 +2,196 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
-	"database/sql"
-
 	"github.com/farolinar/dealls-bumble/config"
-	_ "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-var dbConn *sql.DB
-
-func GetDBConnection() (db *sql.DB) {
-	return dbConn
-}
-
-// DBPostgreOption options for postgre connection
-type DBPostgreOption struct {
-	Host            string
-	Port            int
-	Username        string
-	Password        string
-	DBName          string
-	MaxPoolSize     int
-	MaxIdleConns    int
-	ConnMaxLifetime time.Duration
-	Timeout         time.Duration
+// idleTimeout is how long connections above conn_idle_max stay open while
+// idle
+const idleTimeout = time.Minute
+
+// DB is the connection pool of the service. Repositories go through SQL,
+// which borrows its connections from Pool, so both share the same limits.
+// Replicas are the pools of the read replicas, in config order.
+type DB struct {
+	Pool     *pgxpool.Pool
+	SQL      *sql.DB
+	Replicas []*DB
 }
 
-// NewPostgreDatabase return gorp dbmap object with postgre options param
-func (m DBPostgreOption) NewPostgreDatabaseWithDSN(dsn string) (db *sql.DB, err error) {
-	db, err = sql.Open("pgx", dsn)
+// ParseConfig returns the pool config of cfg:
+//   - params are space separated key=value connection parameters, such as
+//     sslmode=disable application_name=api
+//   - conn_pool_size caps the connections, conn_idle_max of them are kept
+//     open when idle and the others are closed after a minute
+//   - timeout bounds connecting and is the deadline of queries whose
+//     context has none
+//   - statement_cache_capacity prepared statements are cached on each
+//     connection, 0 disables the cache
+func ParseConfig(cfg config.Postgres) (*pgxpool.Config, error) {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s %s",
+		quote(cfg.Host), cfg.Port, quote(cfg.Username), quote(cfg.Password), quote(cfg.DbName), cfg.Params)
+	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		return
+		// the parse error repeats the connection string, password included
+		return nil, fmt.Errorf("invalid postgres params %q", cfg.Params)
 	}
 
-	db.SetConnMaxLifetime(m.ConnMaxLifetime)
-	db.SetMaxIdleConns(m.MaxIdleConns)
-	db.SetMaxOpenConns(m.MaxPoolSize)
+	configure(poolConfig, cfg)
+	return poolConfig, nil
+}
 
-	err = db.Ping()
+// ParseReplicaConfig returns the pool config of the replica at index i of
+// cfg, set up like the primary
+func ParseReplicaConfig(cfg config.Postgres, i int) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.Replicas[i])
 	if err != nil {
-		return
+		return nil, fmt.Errorf("invalid postgres replica %d", i+1)
 	}
 
-	dbConn = db
+	configure(poolConfig, cfg)
+	return poolConfig, nil
+}
 
-	return
+func configure(poolConfig *pgxpool.Config, cfg config.Postgres) {
+	poolConfig.MaxConns = int32(cfg.ConnPoolSize)
+	poolConfig.MinConns = int32(min(cfg.ConnIdleMax, cfg.ConnPoolSize))
+	poolConfig.MaxConnIdleTime = idleTimeout
+	poolConfig.MaxConnLifetime = cfg.ConnLifetimeMax
+
+	connConfig := poolConfig.ConnConfig
+	connConfig.ConnectTimeout = cfg.Timeout
+	connConfig.Tracer = QueryTimeout(cfg.Timeout)
+	connConfig.StatementCacheCapacity = cfg.StatementCacheCapacity
+	connConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if cfg.StatementCacheCapacity == 0 {
+		connConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	}
 }
 
-// NewPostgreDatabase return gorp dbmap object with postgre options param
-func (m DBPostgreOption) NewPostgreDatabase() (db *sql.DB, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), m.Timeout)
-	defer cancel()
+// quote quotes a value of a keyword/value connection string
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
 
-	db, err = sql.Open("pgx", fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable", m.Host, m.Port, m.Username, m.DBName, m.Password))
+// Open connects the pool of cfg and checks the database answers within the
+// timeout of cfg. Replicas are not checked, a replica down at start is only
+// read from once it answers, see replica.Set.
+func Open(ctx context.Context, cfg config.Postgres) (*DB, error) {
+	poolConfig, err := ParseConfig(cfg)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
 	}
 
-	db.SetConnMaxLifetime(m.ConnMaxLifetime)
-	db.SetMaxIdleConns(m.MaxIdleConns)
-	db.SetMaxOpenConns(m.MaxPoolSize)
-
-	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	err = pool.Ping(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// err = db.Ping()
-	// if err != nil {
-	// 	return
-	// }
-
-	dbConn = db
+	db := &DB{Pool: pool, SQL: stdlib.OpenDBFromPool(pool)}
+	for i := range cfg.Replicas {
+		poolConfig, err := ParseReplicaConfig(cfg, i)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open postgres replica %d: %w", i+1, err)
+		}
+		db.Replicas = append(db.Replicas, &DB{Pool: pool, SQL: stdlib.OpenDBFromPool(pool)})
+	}
 
-	return
+	return db, nil
 }
 
-// DBPostgreOption builder pattern code
-type DBPostgreOptionBuilder struct {
-	dBPostgreOption *DBPostgreOption
+// Stats returns the current statistics of the pool
+func (d *DB) Stats() *pgxpool.Stat {
+	return d.Pool.Stat()
 }
 
-func NewDBPostgreOptionBuilder(cfg config.AppConfig) *DBPostgreOptionBuilder {
-	postgresqlPort := cfg.Postgres.Port
-	dBPostgreOption := &DBPostgreOption{
-		Host:            cfg.Postgres.Host,
-		Port:            postgresqlPort,
-		Username:        cfg.Postgres.Username,
-		Password:        cfg.Postgres.Password,
-		MaxPoolSize:     cfg.Postgres.ConnPoolSize,
-		MaxIdleConns:    cfg.Postgres.ConnIdleMax,
-		ConnMaxLifetime: cfg.Postgres.ConnLifetimeMax,
-		Timeout:         cfg.Postgres.Timeout,
+// Close closes SQL then waits for the connections of the pool to be
+// returned and closes them, then closes the replicas the same way
+func (d *DB) Close() {
+	d.SQL.Close()
+	d.Pool.Close()
+	for _, replica := range d.Replicas {
+		replica.Close()
 	}
-	b := &DBPostgreOptionBuilder{dBPostgreOption: dBPostgreOption}
-	return b
 }
 
-func (b *DBPostgreOptionBuilder) WithHost(host string) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.Host = host
-	return b
+type (
+	cancelKey    struct{}
+	unboundedKey struct{}
+)
+
+// WithoutQueryTimeout returns a context whose queries only end with ctx, for
+// long running work such as migrations and bulk loads
+func WithoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unboundedKey{}, true)
 }
 
-func (b *DBPostgreOptionBuilder) WithPort(port int) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.Port = port
-	return b
+// QueryTimeout returns a tracer giving queries and copies without a
+// deadline one of timeout
+func QueryTimeout(timeout time.Duration) interface {
+	pgx.QueryTracer
+	pgx.CopyFromTracer
+} {
+	return queryTimeout{timeout: timeout}
 }
 
-func (b *DBPostgreOptionBuilder) WithUsername(username string) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.Username = username
-	return b
+type queryTimeout struct {
+	timeout time.Duration
 }
 
-func (b *DBPostgreOptionBuilder) WithPassword(password string) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.Password = password
-	return b
+func (t queryTimeout) start(ctx context.Context) context.Context {
+	_, hasDeadline := ctx.Deadline()
+	if hasDeadline || t.timeout <= 0 || ctx.Value(unboundedKey{}) != nil {
+		// hides the cancel of an enclosing query from the end of this one
+		return context.WithValue(ctx, cancelKey{}, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	return context.WithValue(ctx, cancelKey{}, cancel)
 }
 
-func (b *DBPostgreOptionBuilder) WithDBName(dBName string) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.DBName = dBName
-	return b
+func (t queryTimeout) end(ctx context.Context) {
+	if cancel, ok := ctx.Value(cancelKey{}).(context.CancelFunc); ok && cancel != nil {
+		cancel()
+	}
 }
 
-func (b *DBPostgreOptionBuilder) WithMaxPoolSize(maxPoolSize int) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.MaxPoolSize = maxPoolSize
-	return b
+func (t queryTimeout) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx)
 }
 
-func (b *DBPostgreOptionBuilder) WithMaxIdleConns(maxIdleConns int) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.MaxIdleConns = maxIdleConns
-	return b
+func (t queryTimeout) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	t.end(ctx)
 }
 
-func (b *DBPostgreOptionBuilder) WithConnMaxLifetime(connMaxLifetime time.Duration) *DBPostgreOptionBuilder {
-	b.dBPostgreOption.ConnMaxLifetime = connMaxLifetime
-	return b
+func (t queryTimeout) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceCopyFromStartData) context.Context {
+	return t.start(ctx)
 }
 
-func (b *DBPostgreOptionBuilder) Build() (*DBPostgreOption, error) {
-	return b.dBPostgreOption, nil
+func (t queryTimeout) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceCopyFromEndData) {
+	t.end(ctx)
 }
@@ -0,0 +1,193 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps binary objects such as profile pictures by key
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get returns ErrNotFound for unknown keys, the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, key string) error
+	// Check reports whether the store is usable, see health.Checker
+	Check(ctx context.Context) error
+}
+
+type object struct {
+	data        []byte
+	contentType string
+}
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]object
+}
+
+// Memory returns a store keeping objects in memory, used in tests and when no
+// directory is configured, until the S3 integration lands
+func Memory() Store {
+	return &memoryStore{objects: map[string]object{}}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[key] = object{data: data, contentType: contentType}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.contentType, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+	return nil
+}
+
+// Check never fails, the memory is always there
+func (s *memoryStore) Check(ctx context.Context) error {
+	return nil
+}
+
+type dirStore struct {
+	dir string
+}
+
+// Dir returns a store keeping objects as files under dir, created if
+// missing, so they outlive the process and are shared by the commands
+// configured with the same directory. Objects are under objects/<key> and
+// their content types under types/<key>.
+func Dir(dir string) (Store, error) {
+	for _, sub := range []string{"objects", "types"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+// paths returns the files of the object and of the content type of key, keys
+// must stay within the store
+func (s *dirStore) paths(key string) (object, contentType string, err error) {
+	if !filepath.IsLocal(key) {
+		return "", "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, "objects", key), filepath.Join(s.dir, "types", key), nil
+}
+
+func (s *dirStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	objectPath, typePath, err := s.paths(key)
+	if err != nil {
+		return err
+	}
+
+	// the content type first, an object is only visible once complete
+	err = writeFile(typePath, strings.NewReader(contentType))
+	if err != nil {
+		return err
+	}
+	return writeFile(objectPath, r)
+}
+
+func (s *dirStore) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	objectPath, typePath, err := s.paths(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := os.Open(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	contentType, err := os.ReadFile(typePath)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+
+	return f, string(contentType), nil
+}
+
+func (s *dirStore) Delete(ctx context.Context, key string) error {
+	objectPath, typePath, err := s.paths(key)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{objectPath, typePath} {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check fails when no file can be written in the directory
+func (s *dirStore) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(filepath.Join(s.dir, "objects"), ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeFile writes r to a temporary file renamed to path, so readers never
+// see a partial file
+func writeFile(path string, r io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
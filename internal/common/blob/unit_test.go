@@ -0,0 +1,52 @@
+package blob_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/farolinar/dealls-bumble/internal/common/blob"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlob_Unit_Dir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := blob.Dir(dir)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	assert.NoError(t, store.Check(ctx))
+	assert.NoError(t, store.Put(ctx, "users/abc/photos/1.png", strings.NewReader("png"), "image/png"))
+
+	t.Run("Objects outlive the store", func(t *testing.T) {
+		reopened, err := blob.Dir(dir)
+		if err != nil {
+			t.Fatalf("error opening store: %v", err)
+		}
+
+		r, contentType, err := reopened.Get(ctx, "users/abc/photos/1.png")
+		if assert.NoError(t, err) {
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, "png", string(data))
+			assert.Equal(t, "image/png", contentType)
+		}
+	})
+
+	t.Run("Keys stay in the directory", func(t *testing.T) {
+		assert.Error(t, store.Put(ctx, "../outside", strings.NewReader("x"), "text/plain"))
+		_, _, err := store.Get(ctx, "/etc/passwd")
+		assert.Error(t, err)
+	})
+
+	t.Run("Deleted objects are not found", func(t *testing.T) {
+		assert.NoError(t, store.Delete(ctx, "users/abc/photos/1.png"))
+		assert.NoError(t, store.Delete(ctx, "users/abc/photos/1.png"))
+		_, _, err := store.Get(ctx, "users/abc/photos/1.png")
+		assert.ErrorIs(t, err, blob.ErrNotFound)
+	})
+}
@@ -0,0 +1,33 @@
+package clock
+
+import "time"
+
+// Clock tells the time, services take one instead of calling time.Now so tests
+// can fix it
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// System returns the clock of the machine
+func System() Clock {
+	return systemClock{}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// Fixed returns a clock always telling now, meant for tests
+func Fixed(now time.Time) Clock {
+	return fixedClock{now: now}
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Envelope is an event read back from the outbox
+type Envelope struct {
+	ID         int64
+	Type       string
+	Aggregate  string
+	Payload    json.RawMessage
+	OccurredAt time.Time
+	// Attempt counts the deliveries of the event, 1 on the first
+	Attempt int
+}
+
+// Decode unmarshals the payload into v, a pointer to the event of the type
+func (e Envelope) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Handler reacts to an event. Delivery is at least once, so a handler may see
+// an event again after it or another subscriber of the type failed, and must
+// be idempotent.
+type Handler func(ctx context.Context, e Envelope) error
+
+type subscriber struct {
+	name   string
+	handle Handler
+}
+
+// Bus dispatches events to the in-process subscribers of their type
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string][]subscriber
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[string][]subscriber{}}
+}
+
+// Subscribe calls handler with the events of eventType, name identifies the
+// subscriber in errors and logs
+func (b *Bus) Subscribe(eventType, name string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[eventType] = append(b.subscribers[eventType], subscriber{name: name, handle: handler})
+}
+
+// On subscribes handler to the events of type T, decoded from their payload
+func On[T Event](b *Bus, name string, handler func(ctx context.Context, event T) error) {
+	var zero T
+	b.Subscribe(zero.EventType(), name, func(ctx context.Context, e Envelope) error {
+		var event T
+		err := e.Decode(&event)
+		if err != nil {
+			return fmt.Errorf("error decoding %s: %w", e.Type, err)
+		}
+		return handler(ctx, event)
+	})
+}
+
+// Dispatch calls every subscriber of the type of e, in subscription order,
+// and returns their errors. A panicking subscriber fails without stopping
+// the others.
+func (b *Bus) Dispatch(ctx context.Context, e Envelope) error {
+	b.mu.RLock()
+	subscribers := b.subscribers[e.Type]
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, s := range subscribers {
+		err := s.call(ctx, e)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s subscriber) call(ctx context.Context, e Envelope) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.handle(ctx, e)
+}
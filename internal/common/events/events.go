@@ -0,0 +1,85 @@
+package events
+
+// Event is a domain event, written to the outbox by the service making the
+// change and dispatched to the subscribers of its type by the Relay
+type Event interface {
+	// EventType names the event, subscribers listen to it
+	EventType() string
+	// Aggregate keys the events delivered in the order they were published,
+	// such as user:<uid>
+	Aggregate() string
+}
+
+// Types lists the type of every event, for subscribers of all of them
+func Types() []string {
+	return []string{
+		UserRegistered{}.EventType(),
+		EmailVerified{}.EventType(),
+		Swiped{}.EventType(),
+		Matched{}.EventType(),
+		MessageSent{}.EventType(),
+		PackagePurchased{}.EventType(),
+	}
+}
+
+func userAggregate(uid string) string {
+	return "user:" + uid
+}
+
+// UserRegistered follows the registration of a user
+type UserRegistered struct {
+	UserUID  string `json:"user_uid"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+func (UserRegistered) EventType() string   { return "UserRegistered" }
+func (e UserRegistered) Aggregate() string { return userAggregate(e.UserUID) }
+
+// EmailVerified follows a user proving they own their email
+type EmailVerified struct {
+	UserUID string `json:"user_uid"`
+	Email   string `json:"email"`
+}
+
+func (EmailVerified) EventType() string   { return "EmailVerified" }
+func (e EmailVerified) Aggregate() string { return userAggregate(e.UserUID) }
+
+// Swiped follows a user liking or passing on another
+type Swiped struct {
+	UserUID   string `json:"user_uid"`
+	TargetUID string `json:"target_uid"`
+	Liked     bool   `json:"liked"`
+}
+
+func (Swiped) EventType() string   { return "Swiped" }
+func (e Swiped) Aggregate() string { return userAggregate(e.UserUID) }
+
+// Matched follows a like returning a like of the target, UserUID liked last
+type Matched struct {
+	UserUID  string `json:"user_uid"`
+	MatchUID string `json:"match_uid"`
+}
+
+func (Matched) EventType() string   { return "Matched" }
+func (e Matched) Aggregate() string { return userAggregate(e.UserUID) }
+
+// MessageSent follows a user sending a message to a match
+type MessageSent struct {
+	SenderUID    string `json:"sender_uid"`
+	RecipientUID string `json:"recipient_uid"`
+	MessageID    string `json:"message_id"`
+}
+
+func (MessageSent) EventType() string   { return "MessageSent" }
+func (e MessageSent) Aggregate() string { return userAggregate(e.SenderUID) }
+
+// PackagePurchased follows a user buying a premium package
+type PackagePurchased struct {
+	UserUID   string `json:"user_uid"`
+	PackageID int64  `json:"package_id"`
+	Package   string `json:"package"`
+}
+
+func (PackagePurchased) EventType() string   { return "PackagePurchased" }
+func (e PackagePurchased) Aggregate() string { return userAggregate(e.UserUID) }
@@ -0,0 +1,90 @@
+package events_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	dbtest "github.com/farolinar/dealls-bumble/internal/common/db/test"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	_ "github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// integration testing for the order of the relay when transactions publishing
+// events of the same aggregate commit out of order
+func TestEvents_Integration_RelayOrder(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	tx := txn.NewTxManager(db)
+	outbox := events.NewOutbox(db, clock.System())
+	bus := events.NewBus()
+	var got []string
+	events.On(bus, "recorder", func(ctx context.Context, e events.Swiped) error {
+		// no transaction of the relay is open while subscribers run
+		var open int
+		err := db.QueryRowContext(ctx, `
+            SELECT count(*) FROM pg_stat_activity
+            WHERE datname = current_database() AND state LIKE 'idle in transaction%';
+        `).Scan(&open)
+		assert.NoError(t, err)
+		assert.Zero(t, open)
+
+		got = append(got, e.TargetUID)
+		return nil
+	})
+	relay := events.NewRelay(db, bus, clock.System())
+
+	// the first transaction publishes, then waits while a second one
+	// publishes and commits
+	published, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- tx.WithinTx(ctx, func(ctx context.Context) error {
+			err := outbox.Publish(ctx, events.Swiped{UserUID: "a", TargetUID: "first", Liked: true})
+			close(published)
+			<-release
+			return err
+		})
+	}()
+	<-published
+
+	err = tx.WithinTx(ctx, func(ctx context.Context) error {
+		return outbox.Publish(ctx, events.Swiped{UserUID: "a", TargetUID: "second", Liked: true})
+	})
+	assert.NoError(t, err)
+
+	// the second event waits for the first transaction, which may still
+	// publish before it
+	read, err := relay.Drain(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, read)
+	assert.Empty(t, got)
+
+	close(release)
+	assert.NoError(t, <-done)
+	read, err = relay.Drain(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, read)
+	assert.Equal(t, []string{"first", "second"}, got)
+}
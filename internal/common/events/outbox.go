@@ -0,0 +1,307 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoTransaction is returned by Publish outside WithinTx, an event must
+// commit along with the change it reports
+var ErrNoTransaction = errors.New("events must be published within a transaction")
+
+// Outbox writes events to dealls_bumble.outbox, read by the Relay
+type Outbox struct {
+	db    *sql.DB
+	clock clock.Clock
+}
+
+func NewOutbox(db *sql.DB, clk clock.Clock) *Outbox {
+	return &Outbox{db: db, clock: clk}
+}
+
+// Publish writes events in the transaction of ctx, they are dispatched once
+// it commits and dropped if it rolls back
+func (o *Outbox) Publish(ctx context.Context, events ...Event) (err error) {
+	if !txn.InTx(ctx) {
+		return ErrNoTransaction
+	}
+	ctx, span := tracing.StartQuery(ctx, "outbox.Publish")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        INSERT INTO dealls_bumble.outbox (event_type, aggregate, payload, occurred_at, next_attempt_at)
+        VALUES ($1, $2, $3, $4, $4);
+    `
+	now := o.clock.Now()
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %w", event.EventType(), err)
+		}
+
+		_, err = txn.From(ctx, o.db).ExecContext(ctx, q, event.EventType(), event.Aggregate(), string(payload), now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return
+}
+
+// lockID keys the advisory lock of the relay dispatching, so a single
+// instance dispatches at a time and events of an aggregate stay in order
+const lockID = 74_310_002
+
+// Relay dispatches the events of the outbox to the subscribers of a Bus.
+// Events are ordered by the transaction publishing them, in the order
+// transactions first wrote, then in publish order within a transaction. An
+// event is only read once every transaction that started writing before its
+// own finished, so no event can show up behind one already dispatched. A
+// long running transaction delays the relay until it ends.
+//
+// An event is deleted once every subscriber handled it. A failed event is
+// retried with a doubling backoff, holding back the later events of its
+// aggregate, and dead-lettered after the last attempt: it stays in the outbox
+// with dead_at set and no longer holds anything back.
+type Relay struct {
+	db    *sql.DB
+	bus   *Bus
+	clock clock.Clock
+
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type RelayOption func(*Relay)
+
+// WithPollInterval sets how often the outbox is read, every second by default
+func WithPollInterval(d time.Duration) RelayOption {
+	return func(r *Relay) { r.interval = d }
+}
+
+// WithBatchSize sets how many events are read at once, 100 by default
+func WithBatchSize(n int) RelayOption {
+	return func(r *Relay) { r.batchSize = n }
+}
+
+// WithMaxAttempts sets how many times an event is delivered before it is
+// dead-lettered, 10 by default
+func WithMaxAttempts(n int) RelayOption {
+	return func(r *Relay) { r.maxAttempts = n }
+}
+
+// WithRetryBackoff sets the wait before the first retry, 1 second by default,
+// doubled on each retry up to an hour
+func WithRetryBackoff(d time.Duration) RelayOption {
+	return func(r *Relay) { r.backoff = d }
+}
+
+func NewRelay(db *sql.DB, bus *Bus, clk clock.Clock, opts ...RelayOption) *Relay {
+	r := &Relay{
+		db:          db,
+		bus:         bus,
+		clock:       clk,
+		interval:    time.Second,
+		batchSize:   100,
+		maxAttempts: 10,
+		backoff:     time.Second,
+		maxBackoff:  time.Hour,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Relay) Name() string {
+	return "outbox_relay"
+}
+
+// Start relays the outbox in the background until Stop
+func (r *Relay) Start(ctx context.Context) error {
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+	go r.run()
+	return nil
+}
+
+func (r *Relay) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := r.Drain(context.Background())
+			if err != nil {
+				log.Error().Msgf("Error relaying outbox | %s", err.Error())
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Stop waits for the batch being relayed
+func (r *Relay) Stop(ctx context.Context) error {
+	if r.stop == nil {
+		return nil
+	}
+
+	close(r.stop)
+	r.wg.Wait()
+	return nil
+}
+
+// Drain relays batches until the outbox has no event due, and returns how
+// many events were read
+func (r *Relay) Drain(ctx context.Context) (read int, err error) {
+	for {
+		n, err := r.RelayBatch(ctx)
+		read += n
+		if err != nil || n < r.batchSize {
+			return read, err
+		}
+	}
+}
+
+// RelayBatch dispatches a batch of due events and returns how many were
+// read, none when another instance is relaying. No transaction is open while
+// the subscribers run, the outcome of each event is written on its own.
+func (r *Relay) RelayBatch(ctx context.Context) (read int, err error) {
+	// the lock belongs to the session, every statement runs on this connection
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var leader bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1);`, lockID).Scan(&leader)
+	if err != nil || !leader {
+		return
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1);`, lockID)
+
+	now := r.clock.Now()
+	batch, err := r.due(ctx, conn, now)
+	if err != nil {
+		return
+	}
+
+	// an aggregate whose event failed gets none of its later events
+	blocked := map[string]bool{}
+	for _, e := range batch {
+		if blocked[e.Aggregate] {
+			continue
+		}
+
+		dispatchErr := r.bus.Dispatch(ctx, e)
+		if dispatchErr == nil {
+			_, err = conn.ExecContext(ctx, `DELETE FROM dealls_bumble.outbox WHERE id = $1;`, e.ID)
+			if err != nil {
+				return
+			}
+			metrics.EventsRelayed.WithLabelValues(e.Type, metrics.OutcomeDispatched).Inc()
+			continue
+		}
+
+		blocked[e.Aggregate] = true
+		err = r.fail(ctx, conn, e, dispatchErr, now)
+		if err != nil {
+			return
+		}
+	}
+
+	return len(batch), nil
+}
+
+// due reads the events whose turn came, leaving out the events of an
+// aggregate behind an event waiting for its retry. Events of transactions at
+// or past the oldest one still running are left for later, a transaction
+// that started writing earlier may still publish events ordered before them.
+func (r *Relay) due(ctx context.Context, conn *sql.Conn, now time.Time) (batch []Envelope, err error) {
+	q := `
+        SELECT o.id, o.event_type, o.aggregate, o.payload, o.occurred_at, o.attempts
+        FROM dealls_bumble.outbox o
+        WHERE o.dead_at IS NULL AND o.next_attempt_at <= $1
+            AND o.tx_id < pg_snapshot_xmin(pg_current_snapshot())
+            AND NOT EXISTS (
+                SELECT 1 FROM dealls_bumble.outbox w
+                WHERE w.aggregate = o.aggregate AND (w.tx_id, w.id) < (o.tx_id, o.id)
+                    AND w.dead_at IS NULL AND w.next_attempt_at > $1
+            )
+        ORDER BY o.tx_id, o.id
+        LIMIT $2;
+    `
+	rows, err := conn.QueryContext(ctx, q, now, r.batchSize)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Envelope
+		var payload []byte
+		err = rows.Scan(&e.ID, &e.Type, &e.Aggregate, &payload, &e.OccurredAt, &e.Attempt)
+		if err != nil {
+			return
+		}
+		e.Payload = payload
+		e.Attempt++
+		batch = append(batch, e)
+	}
+
+	return batch, rows.Err()
+}
+
+// fail schedules the retry of e, or dead-letters it after its last attempt
+func (r *Relay) fail(ctx context.Context, conn *sql.Conn, e Envelope, dispatchErr error, now time.Time) (err error) {
+	if e.Attempt >= r.maxAttempts {
+		_, err = conn.ExecContext(ctx, `
+            UPDATE dealls_bumble.outbox SET attempts = $2, last_error = $3, dead_at = $4
+            WHERE id = $1;
+        `, e.ID, e.Attempt, dispatchErr.Error(), now)
+		if err != nil {
+			return
+		}
+		metrics.EventsRelayed.WithLabelValues(e.Type, metrics.OutcomeDeadLettered).Inc()
+		log.Error().Int64("event_id", e.ID).Str("event_type", e.Type).Int("attempts", e.Attempt).
+			Msgf("Event dead-lettered | %s", dispatchErr.Error())
+		return
+	}
+
+	backoff := r.backoff << (e.Attempt - 1)
+	if backoff > r.maxBackoff || backoff < r.backoff {
+		backoff = r.maxBackoff
+	}
+	_, err = conn.ExecContext(ctx, `
+        UPDATE dealls_bumble.outbox SET attempts = $2, last_error = $3, next_attempt_at = $4
+        WHERE id = $1;
+    `, e.ID, e.Attempt, dispatchErr.Error(), now.Add(backoff))
+	if err != nil {
+		return
+	}
+	metrics.EventsRelayed.WithLabelValues(e.Type, metrics.OutcomeRetried).Inc()
+	log.Warn().Int64("event_id", e.ID).Str("event_type", e.Type).Int("attempt", e.Attempt).
+		Msgf("Event failed, retrying in %s | %s", backoff, dispatchErr.Error())
+	return
+}
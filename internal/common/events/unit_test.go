@@ -0,0 +1,143 @@
+package events_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents_Unit_Outbox(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newDB := func(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		return db, mocking
+	}
+	columns := []string{"id", "event_type", "aggregate", "payload", "occurred_at", "attempts"}
+	swiped := func(id int64, user, target string, attempts int) []driver.Value {
+		return []driver.Value{id, "Swiped", "user:" + user, fmt.Sprintf(`{"user_uid":%q,"target_uid":%q,"liked":true}`, user, target), now, attempts}
+	}
+
+	t.Run("The bus decodes events and isolates failing subscribers", func(t *testing.T) {
+		bus := events.NewBus()
+		var got []events.Swiped
+		events.On(bus, "recorder", func(ctx context.Context, e events.Swiped) error {
+			got = append(got, e)
+			return nil
+		})
+		bus.Subscribe("Swiped", "panicking", func(ctx context.Context, e events.Envelope) error {
+			panic("boom")
+		})
+		bus.Subscribe("Matched", "unrelated", func(ctx context.Context, e events.Envelope) error {
+			t.Error("called for another type")
+			return nil
+		})
+
+		err := bus.Dispatch(ctx, events.Envelope{Type: "Swiped", Payload: []byte(`{"user_uid":"a","target_uid":"b","liked":true}`)})
+		assert.EqualError(t, err, "panicking: panic: boom")
+		assert.Equal(t, []events.Swiped{{UserUID: "a", TargetUID: "b", Liked: true}}, got)
+		assert.NoError(t, bus.Dispatch(ctx, events.Envelope{Type: "EmailVerified"}))
+	})
+
+	t.Run("Publishing needs a transaction", func(t *testing.T) {
+		db, mocking := newDB(t)
+		outbox := events.NewOutbox(db, clock.Fixed(now))
+		assert.ErrorIs(t, outbox.Publish(ctx, events.Matched{UserUID: "a", MatchUID: "b"}), events.ErrNoTransaction)
+
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO dealls_bumble.outbox").
+			WithArgs("Matched", "user:a", `{"user_uid":"a","match_uid":"b"}`, now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectCommit()
+		err := txn.NewTxManager(db).WithinTx(ctx, func(ctx context.Context) error {
+			return outbox.Publish(ctx, events.Matched{UserUID: "a", MatchUID: "b"})
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("A failed event holds back its aggregate only", func(t *testing.T) {
+		db, mocking := newDB(t)
+		bus := events.NewBus()
+		var delivered []string
+		events.On(bus, "notifier", func(ctx context.Context, e events.Swiped) error {
+			if e.TargetUID == "fails" {
+				return errors.New("notifier down")
+			}
+			delivered = append(delivered, e.UserUID+"->"+e.TargetUID)
+			return nil
+		})
+		relay := events.NewRelay(db, bus, clock.Fixed(now), events.WithRetryBackoff(time.Minute))
+
+		// no transaction is open while the subscribers run
+		mocking.ExpectQuery("SELECT pg_try_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		// only events of finished transactions, in the order of their transaction
+		mocking.ExpectQuery(`FROM dealls_bumble.outbox o[\s\S]+o.tx_id < pg_snapshot_xmin\(pg_current_snapshot\(\)\)[\s\S]+ORDER BY o.tx_id, o.id`).
+			WithArgs(now, 100).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(swiped(1, "a", "fails", 1)...).
+				AddRow(swiped(2, "b", "c", 0)...).
+				AddRow(swiped(3, "a", "d", 0)...))
+		// the second attempt waits twice the backoff
+		mocking.ExpectExec("UPDATE dealls_bumble.outbox SET attempts = \\$2, last_error = \\$3, next_attempt_at = \\$4").
+			WithArgs(int64(1), 2, "notifier: notifier down", now.Add(2*time.Minute)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("DELETE FROM dealls_bumble.outbox").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		read, err := relay.RelayBatch(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, read)
+		assert.Equal(t, []string{"b->c"}, delivered)
+	})
+
+	t.Run("The last attempt dead-letters the event", func(t *testing.T) {
+		db, mocking := newDB(t)
+		bus := events.NewBus()
+		bus.Subscribe("Swiped", "notifier", func(ctx context.Context, e events.Envelope) error {
+			return errors.New("notifier down")
+		})
+		relay := events.NewRelay(db, bus, clock.Fixed(now), events.WithMaxAttempts(3))
+
+		mocking.ExpectQuery("SELECT pg_try_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mocking.ExpectQuery("FROM dealls_bumble.outbox o").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(swiped(1, "a", "b", 2)...))
+		mocking.ExpectExec("UPDATE dealls_bumble.outbox SET attempts = \\$2, last_error = \\$3, dead_at = \\$4").
+			WithArgs(int64(1), 3, "notifier: notifier down", now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		before := testutil.ToFloat64(metrics.EventsRelayed.WithLabelValues("Swiped", metrics.OutcomeDeadLettered))
+		_, err := relay.RelayBatch(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, before+1, testutil.ToFloat64(metrics.EventsRelayed.WithLabelValues("Swiped", metrics.OutcomeDeadLettered)))
+	})
+
+	t.Run("Only the instance holding the lock relays", func(t *testing.T) {
+		db, mocking := newDB(t)
+		relay := events.NewRelay(db, events.NewBus(), clock.Fixed(now))
+
+		mocking.ExpectQuery("SELECT pg_try_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+		read, err := relay.Drain(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, read)
+	})
+}
@@ -0,0 +1,50 @@
+package geo
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+const (
+	MinLatitude  = -90.0
+	MaxLatitude  = 90.0
+	MinLongitude = -180.0
+	MaxLongitude = 180.0
+
+	// FuzzMeters is the maximum jitter added to a distance before rounding
+	FuzzMeters = 500.0
+
+	// EarthRadiusMeters matches the radius used by the postgres
+	// earthdistance extension, so distances agree with the feed queries
+	EarthRadiusMeters = 6378168.0
+)
+
+// DistanceMeters returns the great-circle distance between two points
+func DistanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+
+	return 2 * EarthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// ApproximateKm hides the exact distance between two users. The distance is
+// shifted by a jitter derived from seed and then rounded up to whole
+// kilometers. The same seed always gives the same jitter, so repeating the
+// request does not let the caller average the noise away.
+func ApproximateKm(meters float64, seed string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(seed))
+	jitter := (float64(h.Sum32()%2001)/1000 - 1) * FuzzMeters
+
+	km := int(math.Ceil((meters + jitter) / 1000))
+	if km < 1 {
+		km = 1
+	}
+
+	return km
+}
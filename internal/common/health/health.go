@@ -0,0 +1,201 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/response"
+	"github.com/rs/zerolog"
+)
+
+// DefaultTimeout bounds checks registered without a timeout
+var DefaultTimeout = 2 * time.Second
+
+// statuses
+var (
+	StatusPass = "pass"
+	StatusFail = "fail"
+)
+
+// Checker reports whether a dependency is usable, a nil error means healthy
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// PingChecker checks a dependency able to ping itself, like *sql.DB
+type PingChecker interface {
+	PingContext(ctx context.Context) error
+}
+
+// Ping returns a checker pinging p
+func Ping(p PingChecker) Checker {
+	return CheckerFunc(p.PingContext)
+}
+
+type check struct {
+	name    string
+	checker Checker
+	timeout time.Duration
+}
+
+// Registry holds the named checks of the dependencies the service needs to
+// serve traffic and the lifecycle state the probes report
+type Registry struct {
+	mu     sync.RWMutex
+	checks []check
+
+	started      atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check run by the readiness and startup probes, each run is
+// cancelled after timeout
+func (reg *Registry) Register(name string, checker Checker, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	// copied so a Run in progress keeps its own slice
+	checks := append(slices.Clone(reg.checks), check{name: name, checker: checker, timeout: timeout})
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	reg.checks = checks
+}
+
+// MarkStarted is called once the service is initialized and listening
+func (reg *Registry) MarkStarted() {
+	reg.started.Store(true)
+}
+
+// MarkShuttingDown fails readiness so load balancers stop sending requests
+// before the server drains its connections
+func (reg *Registry) MarkShuttingDown() {
+	reg.shuttingDown.Store(true)
+}
+
+// Report is the response body of the probes
+type Report struct {
+	Status string        `json:"status"`
+	Reason string        `json:"reason,omitempty"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Run runs every check concurrently and aggregates their results, the report
+// fails when any check fails
+func (reg *Registry) Run(ctx context.Context) Report {
+	reg.mu.RLock()
+	checks := reg.checks
+	reg.mu.RUnlock()
+
+	report := Report{Status: StatusPass, Checks: make([]CheckResult, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusPass {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+func (c check) run(ctx context.Context) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.checker.Check(ctx)
+	// a checker ignoring its context still fails once the timeout passed
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Name:      c.name,
+		Status:    StatusPass,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Live reports the process is up. It checks no dependency, so an outage of the
+// database does not get every instance restarted.
+func (reg *Registry) Live(w http.ResponseWriter, r *http.Request) {
+	reg.write(w, r, Report{Status: StatusPass})
+}
+
+// Ready reports whether the service should receive traffic, it fails while
+// shutting down or when any check fails
+func (reg *Registry) Ready(w http.ResponseWriter, r *http.Request) {
+	if reg.shuttingDown.Load() {
+		reg.write(w, r, Report{Status: StatusFail, Reason: "shutting down"})
+		return
+	}
+
+	reg.write(w, r, reg.Run(r.Context()))
+}
+
+// Startup reports whether the service finished initializing, it fails until
+// MarkStarted is called and while any check fails
+func (reg *Registry) Startup(w http.ResponseWriter, r *http.Request) {
+	if !reg.started.Load() {
+		reg.write(w, r, Report{Status: StatusFail, Reason: "starting"})
+		return
+	}
+
+	reg.write(w, r, reg.Run(r.Context()))
+}
+
+func (reg *Registry) write(w http.ResponseWriter, r *http.Request, report Report) {
+	status := http.StatusOK
+	if report.Status != StatusPass {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	err := response.JSON(w, status, report)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding health report: %v", err)
+	}
+}
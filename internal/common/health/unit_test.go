@@ -0,0 +1,76 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth_Unit_Probes(t *testing.T) {
+	probe := func(handler http.HandlerFunc) (int, health.Report) {
+		requestRecorder := httptest.NewRecorder()
+		handler(requestRecorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		var report health.Report
+		assert.NoError(t, json.NewDecoder(requestRecorder.Body).Decode(&report))
+		assert.Equal(t, "application/json", requestRecorder.Header().Get("Content-Type"))
+		return requestRecorder.Code, report
+	}
+
+	healthy := health.CheckerFunc(func(ctx context.Context) error { return nil })
+	slow := health.CheckerFunc(func(ctx context.Context) error {
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	probes := health.NewRegistry()
+	probes.Register("postgres", healthy, time.Second)
+
+	status, report := probe(probes.Startup)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "starting", report.Reason)
+
+	probes.MarkStarted()
+	status, report = probe(probes.Startup)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, health.StatusPass, report.Status)
+
+	status, report = probe(probes.Ready)
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, report.Checks, 1) {
+		assert.Equal(t, "postgres", report.Checks[0].Name)
+		assert.Equal(t, health.StatusPass, report.Checks[0].Status)
+	}
+
+	probes.Register("mailer", slow, 10*time.Millisecond)
+	status, report = probe(probes.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusFail, report.Status)
+	if assert.Len(t, report.Checks, 2) {
+		assert.Equal(t, "mailer", report.Checks[0].Name)
+		assert.Equal(t, health.StatusFail, report.Checks[0].Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+		assert.Less(t, report.Checks[0].LatencyMs, float64(500))
+		assert.Equal(t, health.StatusPass, report.Checks[1].Status)
+	}
+
+	probes.MarkShuttingDown()
+	status, report = probe(probes.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "shutting down", report.Reason)
+
+	// liveness ignores dependencies and shutdown
+	status, report = probe(probes.Live)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, report.Checks)
+}
@@ -0,0 +1,313 @@
+package i18n
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"golang.org/x/text/language"
+)
+
+var (
+	LangEN      = "en"
+	LangID      = "id"
+	DefaultLang = LangEN
+)
+
+// Catalog maps message IDs to their text in a single language
+type Catalog map[string]string
+
+// Bundle holds the catalogs of every registered language, keyed by their
+// canonical BCP 47 tag
+type Bundle struct {
+	mu       sync.RWMutex
+	fallback string
+	catalogs map[string]Catalog
+	// matcher is rebuilt lazily after a language is registered
+	matcher   language.Matcher
+	supported []string
+}
+
+func NewBundle(fallback string) *Bundle {
+	return &Bundle{
+		fallback: fallback,
+		catalogs: map[string]Catalog{},
+	}
+}
+
+var defaultBundle = NewBundle(DefaultLang)
+
+// Default returns the bundle the service packages register their catalogs in
+func Default() *Bundle {
+	return defaultBundle
+}
+
+// AddMessages merges messages into the catalog of lang, a BCP 47 tag
+func (b *Bundle) AddMessages(lang string, messages Catalog) error {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return fmt.Errorf("invalid language %q: %w", lang, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	catalog, ok := b.catalogs[tag.String()]
+	if !ok {
+		catalog = Catalog{}
+		b.catalogs[tag.String()] = catalog
+		b.matcher = nil
+	}
+	for id, message := range messages {
+		catalog[id] = message
+	}
+
+	return nil
+}
+
+// LoadDir loads the catalogs in a directory of the filesystem, used for
+// languages registered at startup
+func (b *Bundle) LoadDir(dir string) error {
+	return b.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS loads every <lang>.json file in dir of fsys, the file name is used as
+// the language tag
+func (b *Bundle) LoadFS(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		var messages Catalog
+		err = json.Unmarshal(data, &messages)
+		if err != nil {
+			return fmt.Errorf("error decoding catalog %s: %w", file, err)
+		}
+
+		err = b.AddMessages(strings.TrimSuffix(path.Base(file), ".json"), messages)
+		if err != nil {
+			return fmt.Errorf("error loading catalog %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// MustLoadFS loads catalogs into the default bundle, meant to be called from
+// package init functions with embedded files
+func MustLoadFS(fsys fs.FS, dir string) {
+	err := defaultBundle.LoadFS(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Languages returns the registered languages, sorted
+func (b *Bundle) Languages() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	langs := make([]string, 0, len(b.catalogs))
+	for lang := range b.catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	return langs
+}
+
+// Missing returns the message IDs of the fallback catalog that lang does not
+// translate, sorted
+func (b *Bundle) Missing(lang string) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var missing []string
+	for id := range b.catalogs[b.fallback] {
+		if _, ok := b.catalogs[lang][id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+func (b *Bundle) lookup(chain []string, id string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, lang := range chain {
+		message, ok := b.catalogs[lang][id]
+		if ok {
+			return message, true
+		}
+	}
+	return "", false
+}
+
+// Localizer returns a localizer for lang. Messages missing from lang are
+// looked up in its parent languages and then in the fallback, so pt-BR falls
+// back to pt and then en.
+func (b *Bundle) Localizer(lang string) *Localizer {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		tag = language.Make(b.fallback)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var chain []string
+	for ; !tag.IsRoot(); tag = tag.Parent() {
+		if _, ok := b.catalogs[tag.String()]; ok {
+			chain = append(chain, tag.String())
+		}
+	}
+	if len(chain) == 0 || chain[len(chain)-1] != b.fallback {
+		chain = append(chain, b.fallback)
+	}
+
+	return &Localizer{chain: chain, bundle: b}
+}
+
+// Negotiate returns the localizer best matching an Accept-Language header,
+// honoring q-values. Headers matching no registered language get the
+// fallback.
+func (b *Bundle) Negotiate(acceptLanguage string) *Localizer {
+	matcher, supported := b.languageMatcher()
+	_, index := language.MatchStrings(matcher, acceptLanguage)
+
+	return b.Localizer(supported[index])
+}
+
+func (b *Bundle) languageMatcher() (language.Matcher, []string) {
+	b.mu.RLock()
+	matcher, supported := b.matcher, b.supported
+	b.mu.RUnlock()
+	if matcher != nil {
+		return matcher, supported
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the first supported tag is what the matcher returns on no match
+	supported = []string{b.fallback}
+	for lang := range b.catalogs {
+		if lang != b.fallback {
+			supported = append(supported, lang)
+		}
+	}
+	sort.Strings(supported[1:])
+
+	tags := make([]language.Tag, len(supported))
+	for i, lang := range supported {
+		tags[i] = language.Make(lang)
+	}
+	b.matcher = language.NewMatcher(tags)
+	b.supported = supported
+
+	return b.matcher, b.supported
+}
+
+// Localizer resolves message IDs for a single language, it is safe to share
+// across goroutines
+type Localizer struct {
+	// chain is the language followed by its fallbacks
+	chain  []string
+	bundle *Bundle
+}
+
+// Lang returns the negotiated language, used for the Content-Language header
+func (l *Localizer) Lang() string {
+	return l.chain[0]
+}
+
+// T returns the message for id, or id itself when no catalog has it
+func (l *Localizer) T(id string) string {
+	message, ok := l.bundle.lookup(l.chain, id)
+	if !ok {
+		return id
+	}
+
+	return message
+}
+
+// Error returns the localized text of err. Errors implementing MessageError
+// and validation errors are translated, anything else keeps its own text.
+func (l *Localizer) Error(err error) string {
+	return l.localize(err).Error()
+}
+
+func (l *Localizer) localize(err error) error {
+	var messageErr MessageError
+	if errors.As(err, &messageErr) {
+		return errors.New(l.T(messageErr.MessageID()))
+	}
+
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		localized := validation.Errors{}
+		for field, fieldErr := range fieldErrs {
+			localized[field] = l.localize(fieldErr)
+		}
+		return localized
+	}
+
+	var ruleErr validation.Error
+	if errors.As(err, &ruleErr) {
+		message, ok := l.bundle.lookup(l.chain, ruleErr.Code())
+		if ok {
+			return ruleErr.SetMessage(message)
+		}
+	}
+
+	return err
+}
+
+// MessageError is an error identified by a message ID, its text is resolved
+// with the Localizer of the request
+type MessageError interface {
+	error
+	MessageID() string
+}
+
+// DefaultText returns the message of id in the default language, used for the
+// Error method of MessageError implementations
+func DefaultText(id string) string {
+	return defaultBundle.Localizer(DefaultLang).T(id)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the localizer
+func NewContext(ctx context.Context, l *Localizer) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the localizer of the request, falling back to the
+// default language of the default bundle
+func FromContext(ctx context.Context) *Localizer {
+	l, ok := ctx.Value(contextKey{}).(*Localizer)
+	if !ok || l == nil {
+		return defaultBundle.Localizer(DefaultLang)
+	}
+
+	return l
+}
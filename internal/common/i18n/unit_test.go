@@ -0,0 +1,81 @@
+package i18n_test
+
+import (
+	"testing"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestI18n_Unit_NegotiateLanguage(t *testing.T) {
+	bundle := i18n.NewBundle(i18n.LangEN)
+	assert.NoError(t, bundle.AddMessages("en", i18n.Catalog{"greeting": "Hello", "farewell": "Goodbye"}))
+	assert.NoError(t, bundle.AddMessages("id", i18n.Catalog{"greeting": "Halo", "farewell": "Sampai jumpa"}))
+	assert.NoError(t, bundle.AddMessages("pt", i18n.Catalog{"greeting": "Olá", "farewell": "Tchau"}))
+	assert.NoError(t, bundle.AddMessages("pt-BR", i18n.Catalog{"greeting": "Oi"}))
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		lang           string
+		greeting       string
+		farewell       string
+	}{
+		{
+			name:     "Empty header uses the fallback",
+			lang:     "en",
+			greeting: "Hello",
+			farewell: "Goodbye",
+		},
+		{
+			name:           "Region subtag matches the language",
+			acceptLanguage: "id-ID",
+			lang:           "id",
+			greeting:       "Halo",
+			farewell:       "Sampai jumpa",
+		},
+		{
+			name:           "Highest q-value wins",
+			acceptLanguage: "en;q=0.5,id;q=0.9",
+			lang:           "id",
+			greeting:       "Halo",
+			farewell:       "Sampai jumpa",
+		},
+		{
+			name:           "Unsupported languages are skipped",
+			acceptLanguage: "fr-FR,fr;q=0.9,id;q=0.8,en;q=0.7",
+			lang:           "id",
+			greeting:       "Halo",
+			farewell:       "Sampai jumpa",
+		},
+		{
+			name:           "Regional catalog falls back to its parent",
+			acceptLanguage: "pt-BR",
+			lang:           "pt-BR",
+			greeting:       "Oi",
+			farewell:       "Tchau",
+		},
+		{
+			name:           "No supported language uses the fallback",
+			acceptLanguage: "ja, fr;q=0.5",
+			lang:           "en",
+			greeting:       "Hello",
+			farewell:       "Goodbye",
+		},
+		{
+			name:           "Malformed header uses the fallback",
+			acceptLanguage: ";;q=abc",
+			lang:           "en",
+			greeting:       "Hello",
+			farewell:       "Goodbye",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			localizer := bundle.Negotiate(tt.acceptLanguage)
+			assert.Equal(t, tt.lang, localizer.Lang())
+			assert.Equal(t, tt.greeting, localizer.T("greeting"))
+			assert.Equal(t, tt.farewell, localizer.T("farewell"))
+		})
+	}
+}
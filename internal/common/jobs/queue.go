@@ -0,0 +1,562 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/rs/zerolog/log"
+)
+
+// Args are the arguments of a job, stored as JSON. Kind names the handler
+// running the job.
+type Args interface {
+	Kind() string
+}
+
+// Job is a job claimed by a worker
+type Job struct {
+	ID      int64
+	Kind    string
+	Payload json.RawMessage
+	// Attempt counts the runs of the job, 1 on the first
+	Attempt     int
+	MaxAttempts int
+}
+
+// Handler runs a job. A job may run again after its handler failed or its
+// worker died, so handlers must be idempotent.
+type Handler func(ctx context.Context, job Job) error
+
+// ErrLeaseLost is returned when the outcome of a job could not be recorded
+// because its visibility timeout passed and another worker claimed it, or it
+// was marked failed, in the meantime
+var ErrLeaseLost = errors.New("job lease lost")
+
+// Handle registers handler for the jobs of kind T, decoded from their payload
+func Handle[T Args](q *Queue, handler func(ctx context.Context, args T) error) {
+	var zero T
+	q.Register(zero.Kind(), func(ctx context.Context, job Job) error {
+		var args T
+		err := json.Unmarshal(job.Payload, &args)
+		if err != nil {
+			return fmt.Errorf("error decoding %s: %w", job.Kind, err)
+		}
+		return handler(ctx, args)
+	})
+}
+
+type schedule struct {
+	name     string
+	schedule Schedule
+	args     Args
+}
+
+// Queue runs jobs stored in dealls_bumble.jobs. Workers of every instance
+// claim due jobs with FOR UPDATE SKIP LOCKED, so a job runs on one worker at
+// a time. A claimed job is invisible to other workers for the visibility
+// timeout, after which it is claimed again, in case its worker died. A failed
+// job is retried with a doubling backoff and marked failed after its last
+// attempt, as is a job whose worker died on its last attempt. Succeeded jobs
+// are deleted.
+type Queue struct {
+	db    *sql.DB
+	tx    *txn.TxManager
+	clock clock.Clock
+
+	concurrency int
+	interval    time.Duration
+	visibility  time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	mu        sync.RWMutex
+	handlers  map[string]Handler
+	schedules []schedule
+
+	stop   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	running atomic.Bool
+	// beats are when each worker last looked for a job, in unix nanoseconds
+	beats []atomic.Int64
+}
+
+type Option func(*Queue)
+
+// WithConcurrency sets how many jobs run at once on this instance, 4 by
+// default
+func WithConcurrency(n int) Option {
+	return func(q *Queue) { q.concurrency = n }
+}
+
+// WithPollInterval sets how often an idle worker looks for due jobs, every
+// second by default
+func WithPollInterval(d time.Duration) Option {
+	return func(q *Queue) { q.interval = d }
+}
+
+// WithVisibilityTimeout sets how long a claimed job is hidden from other
+// workers, 5 minutes by default. It is also the deadline of the handler.
+func WithVisibilityTimeout(d time.Duration) Option {
+	return func(q *Queue) { q.visibility = d }
+}
+
+// WithMaxAttempts sets how many times a job runs unless enqueued with
+// MaxAttempts, 5 by default
+func WithMaxAttempts(n int) Option {
+	return func(q *Queue) { q.maxAttempts = n }
+}
+
+// WithRetryBackoff sets the wait before the first retry, 10 seconds by
+// default, doubled on each retry up to an hour
+func WithRetryBackoff(d time.Duration) Option {
+	return func(q *Queue) { q.backoff = d }
+}
+
+func NewQueue(db *sql.DB, tx *txn.TxManager, clk clock.Clock, opts ...Option) *Queue {
+	q := &Queue{
+		db:          db,
+		tx:          tx,
+		clock:       clk,
+		concurrency: 4,
+		interval:    time.Second,
+		visibility:  5 * time.Minute,
+		maxAttempts: 5,
+		backoff:     10 * time.Second,
+		maxBackoff:  time.Hour,
+		handlers:    map[string]Handler{},
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Register sets the handler of the jobs of kind, replacing any other
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Schedule enqueues args on the cron schedule spec, see ParseSchedule. Every
+// instance may declare the same schedule, each time is enqueued once. name
+// identifies the schedule, changing its spec takes effect on the next start.
+func (q *Queue) Schedule(name, spec string, args Args) error {
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	if s.Next(q.clock.Now()).IsZero() {
+		return fmt.Errorf("schedule %q never runs", spec)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.schedules = append(q.schedules, schedule{name: name, schedule: s, args: args})
+	return nil
+}
+
+type enqueueOptions struct {
+	runAt       time.Time
+	uniqueKey   *string
+	maxAttempts int
+}
+
+type EnqueueOption func(*enqueueOptions)
+
+// RunAt delays the job until t
+func RunAt(t time.Time) EnqueueOption {
+	return func(o *enqueueOptions) { o.runAt = t }
+}
+
+// UniqueKey skips enqueueing the job while another job with key is pending
+// or running
+func UniqueKey(key string) EnqueueOption {
+	return func(o *enqueueOptions) { o.uniqueKey = &key }
+}
+
+// MaxAttempts sets how many times the job runs before it is marked failed
+func MaxAttempts(n int) EnqueueOption {
+	return func(o *enqueueOptions) { o.maxAttempts = n }
+}
+
+// Enqueue stores a job running args, in the transaction of ctx if any so the
+// job only exists if the transaction commits. It reports false when a job
+// with the same unique key is already pending or running.
+func (q *Queue) Enqueue(ctx context.Context, args Args, opts ...EnqueueOption) (enqueued bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "jobs.Enqueue")
+	defer tracing.EndQuery(span, &err)
+
+	now := q.clock.Now()
+	o := enqueueOptions{runAt: now, maxAttempts: q.maxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	payload, err := json.Marshal(args)
+	if err != nil {
+		return false, fmt.Errorf("error encoding %s: %w", args.Kind(), err)
+	}
+
+	query := `
+        INSERT INTO dealls_bumble.jobs (kind, payload, unique_key, max_attempts, run_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND status IN ('pending', 'running') DO NOTHING;
+    `
+	res, err := txn.From(ctx, q.db).ExecContext(ctx, query, args.Kind(), string(payload), o.uniqueKey, o.maxAttempts, o.runAt, now)
+	if err != nil {
+		return
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+func (q *Queue) Name() string {
+	return "jobs"
+}
+
+// Start registers the schedules, then runs the workers and the scheduler in
+// the background until Stop
+func (q *Queue) Start(ctx context.Context) error {
+	err := q.declareSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	q.stop = make(chan struct{})
+	var jobCtx context.Context
+	jobCtx, q.cancel = context.WithCancel(context.Background())
+	q.beats = make([]atomic.Int64, q.concurrency)
+	for i := 0; i < q.concurrency; i++ {
+		q.beats[i].Store(time.Now().UnixNano())
+		q.wg.Add(1)
+		go q.work(jobCtx, i)
+	}
+	q.running.Store(true)
+	q.wg.Add(1)
+	go q.runScheduler()
+
+	return nil
+}
+
+// Stop stops claiming jobs and waits for the running ones. Jobs still running
+// when ctx ends are canceled and retried like failed jobs, or by another
+// worker once their visibility timeout passes if their outcome could not be
+// recorded.
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.stop == nil {
+		return nil
+	}
+	q.running.Store(false)
+	close(q.stop)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return fmt.Errorf("jobs canceled before finishing: %w", ctx.Err())
+	}
+}
+
+// Check fails while the workers are not running or when one of them is
+// stalled: it did not look for a job within the visibility timeout and two
+// poll intervals, so it is stuck in a job ignoring its deadline or in a query
+func (q *Queue) Check(ctx context.Context) error {
+	if !q.running.Load() {
+		return errors.New("job workers are not running")
+	}
+
+	stalled := time.Now().Add(-q.visibility - 2*q.interval).UnixNano()
+	for i := range q.beats {
+		beat := q.beats[i].Load()
+		if beat < stalled {
+			return fmt.Errorf("job worker %d stalled since %s", i, time.Unix(0, beat).UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func (q *Queue) work(ctx context.Context, worker int) {
+	defer q.wg.Done()
+
+	for {
+		q.beats[worker].Store(time.Now().UnixNano())
+		ran, err := q.RunNext(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Msgf("Error running job | %s", err.Error())
+		}
+		if ran {
+			// more jobs may be due, unless stopping
+			select {
+			case <-q.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-time.After(q.interval):
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// RunNext claims a due job and runs it, and reports whether there was one
+func (q *Queue) RunNext(ctx context.Context) (ran bool, err error) {
+	job, ok, err := q.claim(ctx)
+	if err != nil || !ok {
+		return
+	}
+
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+
+	start := time.Now()
+	var runErr error
+	if ok {
+		runErr = q.run(ctx, handler, job)
+	} else {
+		runErr = fmt.Errorf("no handler for %s", job.Kind)
+	}
+	metrics.JobDuration.WithLabelValues(job.Kind).Observe(time.Since(start).Seconds())
+
+	// the outcome is recorded even when stopping canceled the job
+	return true, q.finish(context.WithoutCancel(ctx), job, runErr)
+}
+
+func (q *Queue) run(ctx context.Context, handler Handler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// the job could run elsewhere once hidden no more
+	ctx, cancel := context.WithTimeout(ctx, q.visibility)
+	defer cancel()
+	return handler(ctx, job)
+}
+
+// claim marks the next due job running, also taking back jobs with attempts
+// left whose worker did not finish them within the visibility timeout
+func (q *Queue) claim(ctx context.Context) (job Job, ok bool, err error) {
+	now := q.clock.Now()
+	row := q.db.QueryRowContext(ctx, `
+        UPDATE dealls_bumble.jobs
+        SET status = 'running', attempts = attempts + 1, locked_until = $2
+        WHERE id = (
+            SELECT id FROM dealls_bumble.jobs
+            WHERE (status = 'pending' AND run_at <= $1) OR (status = 'running' AND locked_until <= $1 AND attempts < max_attempts)
+            ORDER BY run_at, id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, kind, payload, attempts, max_attempts;
+    `, now, now.Add(q.visibility))
+
+	var payload []byte
+	err = row.Scan(&job.ID, &job.Kind, &payload, &job.Attempt, &job.MaxAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, false, nil
+	}
+	if err != nil {
+		return
+	}
+	job.Payload = payload
+	return job, true, nil
+}
+
+// finish deletes a succeeded job, schedules the retry of a failed one or
+// marks it failed after its last attempt. The job must still be running the
+// attempt of job, otherwise another worker claimed it and ErrLeaseLost is
+// returned.
+func (q *Queue) finish(ctx context.Context, job Job, runErr error) (err error) {
+	now := q.clock.Now()
+	if runErr == nil {
+		err = q.record(ctx, job, `
+            DELETE FROM dealls_bumble.jobs
+            WHERE id = $1 AND status = 'running' AND attempts = $2;
+        `)
+		if err == nil {
+			metrics.Jobs.WithLabelValues(job.Kind, metrics.OutcomeSucceeded).Inc()
+		}
+		return
+	}
+
+	if job.Attempt >= job.MaxAttempts {
+		err = q.record(ctx, job, `
+            UPDATE dealls_bumble.jobs SET status = 'failed', locked_until = NULL, last_error = $3, finished_at = $4
+            WHERE id = $1 AND status = 'running' AND attempts = $2;
+        `, runErr.Error(), now)
+		if err != nil {
+			return
+		}
+		metrics.Jobs.WithLabelValues(job.Kind, metrics.OutcomeFailed).Inc()
+		log.Error().Int64("job_id", job.ID).Str("job_kind", job.Kind).Int("attempts", job.Attempt).
+			Msgf("Job failed | %s", runErr.Error())
+		return
+	}
+
+	backoff := q.backoff << (job.Attempt - 1)
+	if backoff > q.maxBackoff || backoff < q.backoff {
+		backoff = q.maxBackoff
+	}
+	err = q.record(ctx, job, `
+        UPDATE dealls_bumble.jobs SET status = 'pending', locked_until = NULL, last_error = $3, run_at = $4
+        WHERE id = $1 AND status = 'running' AND attempts = $2;
+    `, runErr.Error(), now.Add(backoff))
+	if err != nil {
+		return
+	}
+	metrics.Jobs.WithLabelValues(job.Kind, metrics.OutcomeRetried).Inc()
+	log.Warn().Int64("job_id", job.ID).Str("job_kind", job.Kind).Int("attempt", job.Attempt).
+		Msgf("Job failed, retrying in %s | %s", backoff, runErr.Error())
+	return
+}
+
+// record runs query, given the job id and attempt then args, and returns
+// ErrLeaseLost when it matched no job
+func (q *Queue) record(ctx context.Context, job Job, query string, args ...any) error {
+	res, err := q.db.ExecContext(ctx, query, append([]any{job.ID, job.Attempt}, args...)...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s %d attempt %d", ErrLeaseLost, job.Kind, job.ID, job.Attempt)
+	}
+	return nil
+}
+
+// FailExpired marks failed the jobs whose worker did not finish their last
+// attempt within the visibility timeout, which claim no longer takes back
+func (q *Queue) FailExpired(ctx context.Context) error {
+	rows, err := q.db.QueryContext(ctx, `
+        UPDATE dealls_bumble.jobs
+        SET status = 'failed', locked_until = NULL, last_error = 'visibility timeout passed on the last attempt', finished_at = $1
+        WHERE status = 'running' AND locked_until <= $1 AND attempts >= max_attempts
+        RETURNING id, kind, attempts;
+    `, q.clock.Now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job Job
+		err = rows.Scan(&job.ID, &job.Kind, &job.Attempt)
+		if err != nil {
+			return err
+		}
+		metrics.Jobs.WithLabelValues(job.Kind, metrics.OutcomeFailed).Inc()
+		log.Error().Int64("job_id", job.ID).Str("job_kind", job.Kind).Int("attempts", job.Attempt).
+			Msg("Job failed | visibility timeout passed on the last attempt")
+	}
+	return rows.Err()
+}
+
+// declareSchedules stores the schedules missing from
+// dealls_bumble.job_schedules and restarts those whose spec changed
+func (q *Queue) declareSchedules(ctx context.Context) error {
+	q.mu.RLock()
+	schedules := q.schedules
+	q.mu.RUnlock()
+
+	now := q.clock.Now()
+	for _, s := range schedules {
+		_, err := q.db.ExecContext(ctx, `
+            INSERT INTO dealls_bumble.job_schedules (name, spec, next_run_at)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (name) DO UPDATE SET spec = EXCLUDED.spec, next_run_at = EXCLUDED.next_run_at
+            WHERE job_schedules.spec <> EXCLUDED.spec;
+        `, s.name, s.schedule.String(), s.schedule.Next(now))
+		if err != nil {
+			return fmt.Errorf("error declaring schedule %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+func (q *Queue) runScheduler() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := q.EnqueueScheduled(context.Background())
+			if err != nil {
+				log.Error().Msgf("Error enqueueing scheduled jobs | %s", err.Error())
+			}
+			err = q.FailExpired(context.Background())
+			if err != nil {
+				log.Error().Msgf("Error failing expired jobs | %s", err.Error())
+			}
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// EnqueueScheduled enqueues the scheduled jobs whose time came. Advancing
+// the next run of a schedule and enqueueing its job commit together, so
+// instances racing for a time enqueue it once. Times missed while no
+// instance ran are enqueued once.
+func (q *Queue) EnqueueScheduled(ctx context.Context) error {
+	q.mu.RLock()
+	schedules := q.schedules
+	q.mu.RUnlock()
+
+	now := q.clock.Now()
+	var errs []error
+	for _, s := range schedules {
+		err := q.tx.WithinTx(ctx, func(ctx context.Context) error {
+			res, err := txn.From(ctx, q.db).ExecContext(ctx, `
+                UPDATE dealls_bumble.job_schedules SET next_run_at = $2
+                WHERE name = $1 AND next_run_at <= $3;
+            `, s.name, s.schedule.Next(now), now)
+			if err != nil {
+				return err
+			}
+			if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+				return err
+			}
+
+			_, err = q.Enqueue(ctx, s.args, UniqueKey("schedule:"+s.name))
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,260 @@
+package jobs_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/jobs"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type testJob struct {
+	UserUID string `json:"user_uid"`
+}
+
+func (testJob) Kind() string { return "test_job" }
+
+func TestJobs_Unit_Queue(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 3, 10, 7, 30, 0, time.UTC) // a Friday
+	newQueue := func(t *testing.T, opts ...jobs.Option) (sqlmock.Sqlmock, *jobs.Queue) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		return mocking, jobs.NewQueue(db, txn.NewTxManager(db), clock.Fixed(now), opts...)
+	}
+	claimed := func(mocking sqlmock.Sqlmock, attempt, maxAttempts int) {
+		// jobs out of attempts are not claimed again
+		mocking.ExpectQuery(`UPDATE dealls_bumble.jobs\s+SET status = 'running'[\s\S]+locked_until <= \$1 AND attempts < max_attempts`).
+			WithArgs(now, now.Add(5*time.Minute)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "payload", "attempts", "max_attempts"}).
+				AddRow(7, "test_job", `{"user_uid":"abc"}`, attempt, maxAttempts))
+	}
+
+	t.Run("Cron schedules", func(t *testing.T) {
+		tests := []struct {
+			spec string
+			next time.Time
+		}{
+			{"*/15 * * * *", time.Date(2024, 5, 3, 10, 15, 0, 0, time.UTC)},
+			{"5/20 * * * *", time.Date(2024, 5, 3, 10, 25, 0, 0, time.UTC)},
+			{"0 3 * * 1-5", time.Date(2024, 5, 6, 3, 0, 0, 0, time.UTC)},
+			{"30 9,18 * * *", time.Date(2024, 5, 3, 18, 30, 0, 0, time.UTC)},
+			{"@daily", time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC)},
+			{"0 0 * * 7", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+			// either day field matches when both are set
+			{"0 0 13 * 1", time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+			{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		}
+		for _, tt := range tests {
+			schedule, err := jobs.ParseSchedule(tt.spec)
+			if assert.NoError(t, err, tt.spec) {
+				assert.Equal(t, tt.next, schedule.Next(now), tt.spec)
+			}
+		}
+
+		for _, spec := range []string{"* * * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+			_, err := jobs.ParseSchedule(spec)
+			assert.Error(t, err, spec)
+		}
+
+		_, queue := newQueue(t)
+		assert.EqualError(t, queue.Schedule("never", "0 0 30 2 *", testJob{}), `schedule "0 0 30 2 *" never runs`)
+	})
+
+	t.Run("A unique key skips duplicates", func(t *testing.T) {
+		mocking, queue := newQueue(t)
+		mocking.ExpectExec("INSERT INTO dealls_bumble.jobs").
+			WithArgs("test_job", `{"user_uid":"abc"}`, "welcome:abc", 5, now.Add(time.Hour), now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectExec("INSERT INTO dealls_bumble.jobs").
+			WithArgs("test_job", `{"user_uid":"abc"}`, "welcome:abc", 5, now, now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		enqueued, err := queue.Enqueue(ctx, testJob{UserUID: "abc"}, jobs.UniqueKey("welcome:abc"), jobs.RunAt(now.Add(time.Hour)))
+		assert.NoError(t, err)
+		assert.True(t, enqueued)
+		enqueued, err = queue.Enqueue(ctx, testJob{UserUID: "abc"}, jobs.UniqueKey("welcome:abc"))
+		assert.NoError(t, err)
+		assert.False(t, enqueued)
+	})
+
+	t.Run("Handlers get their typed arguments and succeeded jobs are deleted", func(t *testing.T) {
+		mocking, queue := newQueue(t)
+		var got []testJob
+		jobs.Handle(queue, func(ctx context.Context, args testJob) error {
+			got = append(got, args)
+			return nil
+		})
+		claimed(mocking, 1, 5)
+		mocking.ExpectExec("DELETE FROM dealls_bumble.jobs").WithArgs(int64(7), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectQuery(`UPDATE dealls_bumble.jobs\s+SET status = 'running'`).WillReturnError(sql.ErrNoRows)
+
+		ran, err := queue.RunNext(ctx)
+		assert.NoError(t, err)
+		assert.True(t, ran)
+		assert.Equal(t, []testJob{{UserUID: "abc"}}, got)
+
+		ran, err = queue.RunNext(ctx)
+		assert.NoError(t, err)
+		assert.False(t, ran)
+	})
+
+	t.Run("Failed jobs are retried with backoff then marked failed", func(t *testing.T) {
+		mocking, queue := newQueue(t, jobs.WithRetryBackoff(time.Minute))
+		jobs.Handle(queue, func(ctx context.Context, args testJob) error {
+			return errors.New("mail server down")
+		})
+		claimed(mocking, 3, 5)
+		mocking.ExpectExec(`UPDATE dealls_bumble.jobs SET status = 'pending'`).
+			WithArgs(int64(7), 3, "mail server down", now.Add(4*time.Minute)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		claimed(mocking, 5, 5)
+		mocking.ExpectExec(`UPDATE dealls_bumble.jobs SET status = 'failed'`).
+			WithArgs(int64(7), 5, "mail server down", now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		for i := 0; i < 2; i++ {
+			ran, err := queue.RunNext(ctx)
+			assert.NoError(t, err)
+			assert.True(t, ran)
+		}
+	})
+
+	t.Run("A worker outlived by its lease records nothing", func(t *testing.T) {
+		mocking, queue := newQueue(t)
+		jobs.Handle(queue, func(ctx context.Context, args testJob) error {
+			return nil
+		})
+		claimed(mocking, 2, 5)
+		// another worker claimed the third attempt
+		mocking.ExpectExec(`DELETE FROM dealls_bumble.jobs\s+WHERE id = \$1 AND status = 'running' AND attempts = \$2`).
+			WithArgs(int64(7), 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		succeeded := testutil.ToFloat64(metrics.Jobs.WithLabelValues("test_job", metrics.OutcomeSucceeded))
+
+		ran, err := queue.RunNext(ctx)
+		assert.ErrorIs(t, err, jobs.ErrLeaseLost)
+		assert.True(t, ran)
+		assert.Equal(t, succeeded, testutil.ToFloat64(metrics.Jobs.WithLabelValues("test_job", metrics.OutcomeSucceeded)))
+	})
+
+	t.Run("Jobs expiring on their last attempt are marked failed", func(t *testing.T) {
+		mocking, queue := newQueue(t)
+		mocking.ExpectQuery(`SET status = 'failed'[\s\S]+WHERE status = 'running' AND locked_until <= \$1 AND attempts >= max_attempts`).
+			WithArgs(now).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "attempts"}).AddRow(7, "test_job", 5))
+		failed := testutil.ToFloat64(metrics.Jobs.WithLabelValues("test_job", metrics.OutcomeFailed))
+
+		assert.NoError(t, queue.FailExpired(ctx))
+		assert.Equal(t, failed+1, testutil.ToFloat64(metrics.Jobs.WithLabelValues("test_job", metrics.OutcomeFailed)))
+	})
+
+	t.Run("Due schedules are enqueued once", func(t *testing.T) {
+		mocking, queue := newQueue(t)
+		assert.NoError(t, queue.Schedule("quota_reset", "@daily", testJob{}))
+		assert.NoError(t, queue.Schedule("purge", "0 3 * * *", testJob{UserUID: "all"}))
+
+		mocking.ExpectBegin()
+		mocking.ExpectExec("UPDATE dealls_bumble.job_schedules").
+			WithArgs("quota_reset", time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC), now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("INSERT INTO dealls_bumble.jobs").
+			WithArgs("test_job", `{"user_uid":""}`, "schedule:quota_reset", 5, now, now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectCommit()
+		// another instance enqueued it already
+		mocking.ExpectBegin()
+		mocking.ExpectExec("UPDATE dealls_bumble.job_schedules").
+			WithArgs("purge", time.Date(2024, 5, 4, 3, 0, 0, 0, time.UTC), now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectCommit()
+
+		assert.NoError(t, queue.EnqueueScheduled(ctx))
+	})
+
+	t.Run("Stop waits for running jobs", func(t *testing.T) {
+		mocking, queue := newQueue(t, jobs.WithConcurrency(1), jobs.WithPollInterval(time.Hour))
+		started, release := make(chan struct{}), make(chan struct{})
+		jobs.Handle(queue, func(ctx context.Context, args testJob) error {
+			close(started)
+			<-release
+			return nil
+		})
+		claimed(mocking, 1, 5)
+		mocking.ExpectExec("DELETE FROM dealls_bumble.jobs").WithArgs(int64(7), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, queue.Start(ctx))
+		<-started
+		stopped := make(chan error)
+		go func() { stopped <- queue.Stop(ctx) }()
+
+		select {
+		case <-stopped:
+			t.Fatal("stopped before the job finished")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		assert.NoError(t, <-stopped)
+	})
+
+	t.Run("Check reports stopped and stalled workers", func(t *testing.T) {
+		mocking, queue := newQueue(t, jobs.WithConcurrency(1), jobs.WithPollInterval(10*time.Millisecond), jobs.WithVisibilityTimeout(10*time.Millisecond))
+		started, release := make(chan struct{}), make(chan struct{})
+		// ignores its deadline
+		jobs.Handle(queue, func(ctx context.Context, args testJob) error {
+			close(started)
+			<-release
+			return nil
+		})
+		mocking.ExpectQuery(`UPDATE dealls_bumble.jobs\s+SET status = 'running'`).
+			WithArgs(now, now.Add(10*time.Millisecond)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "payload", "attempts", "max_attempts"}).
+				AddRow(7, "test_job", `{"user_uid":"abc"}`, 1, 5))
+		mocking.ExpectExec("DELETE FROM dealls_bumble.jobs").WithArgs(int64(7), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.EqualError(t, queue.Check(ctx), "job workers are not running")
+		assert.NoError(t, queue.Start(ctx))
+		<-started
+		assert.NoError(t, queue.Check(ctx))
+		assert.Eventually(t, func() bool { return queue.Check(ctx) != nil }, time.Second, 5*time.Millisecond)
+		assert.ErrorContains(t, queue.Check(ctx), "job worker 0 stalled")
+
+		stopped := make(chan error)
+		go func() { stopped <- queue.Stop(ctx) }()
+		close(release)
+		assert.NoError(t, <-stopped)
+		assert.EqualError(t, queue.Check(ctx), "job workers are not running")
+	})
+
+	t.Run("Stop cancels jobs outliving its context", func(t *testing.T) {
+		mocking, queue := newQueue(t, jobs.WithConcurrency(1), jobs.WithPollInterval(time.Hour), jobs.WithRetryBackoff(time.Minute))
+		started := make(chan struct{})
+		jobs.Handle(queue, func(ctx context.Context, args testJob) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		claimed(mocking, 1, 5)
+		mocking.ExpectExec(`UPDATE dealls_bumble.jobs SET status = 'pending'`).
+			WithArgs(int64(7), 1, context.Canceled.Error(), now.Add(time.Minute)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, queue.Start(ctx))
+		<-started
+		stopCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, queue.Stop(stopCtx), context.DeadlineExceeded)
+	})
+}
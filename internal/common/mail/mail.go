@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+	// Check reports whether emails can be sent, see health.Checker
+	Check(ctx context.Context) error
+}
+
+type logMailer struct{}
+
+// Log returns a mailer logging messages instead of sending them, used until an
+// email provider is configured
+func Log() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	zerolog.Ctx(ctx).Info().
+		Strs("to", msg.To).
+		Str("subject", msg.Subject).
+		Msg("email not sent, no email provider configured")
+	return nil
+}
+
+// Check never fails, logging needs no provider
+func (logMailer) Check(ctx context.Context) error {
+	return nil
+}
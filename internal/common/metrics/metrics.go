@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const Namespace = "dealls_bumble"
+
+// Registry holds every metric of the service, served by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	BcryptHashDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "bcrypt_hash_duration_seconds",
+		Help:      "Time spent hashing passwords with bcrypt.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "registrations_total",
+		Help:      "Users registered.",
+	})
+
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by outcome.",
+	}, []string{"outcome"})
+
+	Swipes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "swipes_total",
+		Help:      "Swipes by direction, like or pass.",
+	}, []string{"direction"})
+
+	Matches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "matches_total",
+		Help:      "Mutual likes.",
+	})
+
+	Purchases = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "purchases_total",
+		Help:      "Premium packages granted to users by package.",
+	}, []string{"package"})
+
+	TxRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "db_transaction_retries_total",
+		Help:      "Transactions run again by the postgres error that aborted them.",
+	}, []string{"reason"})
+
+	ReplicaUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "db_replica_up",
+		Help:      "Whether a read replica passes its checks and is read from.",
+	}, []string{"replica"})
+
+	EventsRelayed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "events_relayed_total",
+		Help:      "Outbox events relayed to their subscribers by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	Jobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "jobs_total",
+		Help:      "Background jobs run by kind and outcome.",
+	}, []string{"kind", "outcome"})
+
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Background job run time by kind.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 15, 60, 300},
+	}, []string{"kind"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by event type and outcome.",
+	}, []string{"type", "outcome"})
+)
+
+// login outcomes
+var (
+	OutcomeSuccess       = "success"
+	OutcomeNotFound      = "not_found"
+	OutcomeWrongPassword = "wrong_password"
+	OutcomeError         = "error"
+)
+
+// relay outcomes
+var (
+	OutcomeDispatched   = "dispatched"
+	OutcomeRetried      = "retried"
+	OutcomeDeadLettered = "dead_lettered"
+)
+
+// job outcomes, failed jobs ran their last attempt
+var (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// swipe directions
+var (
+	DirectionLike = "like"
+	DirectionPass = "pass"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		BcryptHashDuration,
+		Registrations,
+		Logins,
+		Swipes,
+		Matches,
+		Purchases,
+		TxRetries,
+		ReplicaUp,
+		EventsRelayed,
+		Jobs,
+		JobDuration,
+		WebhookDeliveries,
+	)
+}
+
+// RegisterPool exposes the stats of pool as db_pool_* metrics labeled with
+// name, which tells apart the pools of the primary and of the replicas
+func RegisterPool(pool *pgxpool.Pool, name string) error {
+	labels := prometheus.Labels{"db_name": name}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(Namespace+"_db_pool_"+name, help, nil, labels)
+	}
+
+	return Registry.Register(&poolCollector{
+		pool:              pool,
+		acquiredConns:     desc("acquired_conns", "Connections in use."),
+		idleConns:         desc("idle_conns", "Idle connections."),
+		constructingConns: desc("constructing_conns", "Connections being opened."),
+		totalConns:        desc("total_conns", "Open connections."),
+		maxConns:          desc("max_conns", "Maximum connections."),
+		acquires:          desc("acquires_total", "Connections acquired."),
+		emptyAcquires:     desc("empty_acquires_total", "Acquires that waited for a connection."),
+		canceledAcquires:  desc("canceled_acquires_total", "Acquires canceled while waiting."),
+		acquireSeconds:    desc("acquire_seconds_total", "Time spent acquiring connections."),
+		newConns:          desc("new_conns_total", "Connections opened."),
+		lifetimeDestroys:  desc("lifetime_destroys_total", "Connections closed for reaching conn_lifetime_max."),
+		idleDestroys:      desc("idle_destroys_total", "Connections closed for being idle."),
+	})
+}
+
+// poolCollector reads the stats of a pool on every scrape. Its descriptions
+// carry the name of the pool as a constant label, so each pool registers its
+// own collector.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns, idleConns, constructingConns, totalConns, maxConns *prometheus.Desc
+	acquires, emptyAcquires, canceledAcquires, acquireSeconds         *prometheus.Desc
+	newConns, lifetimeDestroys, idleDestroys                          *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.acquiredConns, c.idleConns, c.constructingConns, c.totalConns, c.maxConns, c.acquires,
+		c.emptyAcquires, c.canceledAcquires, c.acquireSeconds, c.newConns, c.lifetimeDestroys, c.idleDestroys,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(c.acquiredConns, float64(stat.AcquiredConns()))
+	gauge(c.idleConns, float64(stat.IdleConns()))
+	gauge(c.constructingConns, float64(stat.ConstructingConns()))
+	gauge(c.totalConns, float64(stat.TotalConns()))
+	gauge(c.maxConns, float64(stat.MaxConns()))
+	counter(c.acquires, float64(stat.AcquireCount()))
+	counter(c.emptyAcquires, float64(stat.EmptyAcquireCount()))
+	counter(c.canceledAcquires, float64(stat.CanceledAcquireCount()))
+	counter(c.acquireSeconds, stat.AcquireDuration().Seconds())
+	counter(c.newConns, float64(stat.NewConnsCount()))
+	counter(c.lifetimeDestroys, float64(stat.MaxLifetimeDestroyCount()))
+	counter(c.idleDestroys, float64(stat.MaxIdleDestroyCount()))
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
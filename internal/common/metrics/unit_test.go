@@ -0,0 +1,49 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// counters are global and shared with the other tests, so only deltas are
+// checked
+func TestMetrics_Unit_Handler(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(middleware.Logging)
+	router.HandleFunc("/v1/metrics-test/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Handle("/metrics", metrics.Handler())
+
+	requests := metrics.HTTPRequests.WithLabelValues("/v1/metrics-test/{uid}", http.MethodGet, "204")
+	logins := metrics.Logins.WithLabelValues(metrics.OutcomeWrongPassword)
+	requestsBefore := testutil.ToFloat64(requests)
+	loginsBefore := testutil.ToFloat64(logins)
+
+	for _, uid := range []string{"abc", "def"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/metrics-test/"+uid, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	logins.Inc()
+
+	assert.Equal(t, requestsBefore+2, testutil.ToFloat64(requests))
+	assert.Equal(t, loginsBefore+1, testutil.ToFloat64(logins))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	requestRecorder := httptest.NewRecorder()
+	router.ServeHTTP(requestRecorder, req)
+
+	body := requestRecorder.Body.String()
+	assert.Equal(t, http.StatusOK, requestRecorder.Code)
+	assert.Contains(t, body, `dealls_bumble_http_requests_total{method="GET",route="/v1/metrics-test/{uid}",status="204"} 2`)
+	assert.Contains(t, body, `dealls_bumble_http_request_duration_seconds_count{method="GET",route="/v1/metrics-test/{uid}",status="204"} 2`)
+	assert.Contains(t, body, "dealls_bumble_logins_total")
+	assert.NotContains(t, body, "/v1/metrics-test/abc")
+}
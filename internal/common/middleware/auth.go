@@ -38,6 +38,7 @@ func Authorize(cfg config.AppConfig, next func(w http.ResponseWriter, r *http.Re
 		}
 
 		ctx := context.WithValue(r.Context(), ContextAuthKey{}, subject)
+		addLogUserUID(ctx, subject)
 		r = r.WithContext(ctx)
 
 		next(w, r)
@@ -73,8 +74,15 @@ func Authenticate(cfg config.AppConfig, next func(w http.ResponseWriter, r *http
 		}
 
 		ctx := context.WithValue(r.Context(), ContextAuthKey{}, subject)
+		addLogUserUID(ctx, subject)
 		r = r.WithContext(ctx)
 
 		next(w, r)
 	}
 }
+
+// AuthSubject returns the subject set by Authorize or Authenticate
+func AuthSubject(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(ContextAuthKey{}).(string)
+	return subject, ok && subject != ""
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+)
+
+// Localize negotiates the language of the Accept-Language header and stores
+// its Localizer in the request context, handlers resolve their messages with
+// i18n.FromContext
+func Localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		localizer := i18n.Default().Negotiate(r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", localizer.Lang())
+		w.Header().Add("Vary", "Accept-Language")
+
+		r = r.WithContext(i18n.NewContext(r.Context(), localizer))
+		next.ServeHTTP(w, r)
+	})
+}
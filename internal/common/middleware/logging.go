@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
 	servicebase "github.com/farolinar/dealls-bumble/services/base"
-	"github.com/rs/zerolog/log"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
 )
 
 type LogResponseWriter struct {
@@ -26,6 +29,9 @@ func (w *LogResponseWriter) WriteHeader(code int) {
 }
 
 func (w *LogResponseWriter) Write(body []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
 	w.buf.Write(body)
 	return w.ResponseWriter.Write(body)
 }
@@ -33,22 +39,52 @@ func (w *LogResponseWriter) Write(body []byte) (int, error) {
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
-		// requestID := id.GenerateStringID(12)
 		logRespWriter := NewLogResponseWriter(w)
 		next.ServeHTTP(logRespWriter, r)
+		duration := time.Since(startTime)
+		if logRespWriter.statusCode == 0 {
+			logRespWriter.statusCode = http.StatusOK
+		}
+
+		status := strconv.Itoa(logRespWriter.statusCode)
+		route := routeTemplate(r)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method, status).Observe(duration.Seconds())
 
-		log.Debug().
-			Dur("duration", time.Since(startTime)).
+		logger := zerolog.Ctx(r.Context())
+		logger.Debug().
+			Dur("duration", duration).
 			Int("status", logRespWriter.statusCode).
 			Str("uri", r.RequestURI).
 			Str("method", r.Method).
 			Msg("request information")
 		var resp servicebase.ResponseBody
-		err := json.NewDecoder(&logRespWriter.buf).Decode(&resp)
+		var problem servicebase.Problem
+		body := logRespWriter.buf.Bytes()
+		err := json.Unmarshal(body, &resp)
+		if err == nil && resp.Message == "" && json.Unmarshal(body, &problem) == nil {
+			resp.Message = problem.Title
+		}
 		if logRespWriter.statusCode >= 500 && err == nil {
-			log.Error().
+			logger.Error().
 				Str("error", resp.Message).
 				Msg("internal server errror on request")
 		}
 	})
 }
+
+// routeTemplate returns the mux route template of r, keeping metric labels
+// bounded unlike the raw path
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unmatched"
+	}
+
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+
+	return template
+}
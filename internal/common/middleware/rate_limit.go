@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+)
+
+// RateLimit limits the requests of each client IP to the RateLimitPerMinute
+// of the current settings, counted in fixed one minute windows. Probes and
+// metrics scrapes are never limited.
+func RateLimit(store *settings.Store) func(http.Handler) http.Handler {
+	limiter := &rateLimiter{counts: map[string]int{}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := store.Get().RateLimitPerMinute
+			if limit <= 0 || strings.HasPrefix(r.URL.Path, "/health") || r.URL.Path == "/metrics" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter, ok := limiter.allow(clientIP(r), limit, time.Now())
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				servicebase.WriteError(w, r, servicebase.ErrTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+// allow counts a request of ip, returning how long until the next window when
+// the limit is reached
+func (l *rateLimiter) allow(ip string, limit int, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := now.Truncate(time.Minute)
+	if !window.Equal(l.window) {
+		l.window = window
+		l.counts = map[string]int{}
+	}
+
+	if l.counts[ip] >= limit {
+		return window.Add(time.Minute).Sub(now), false
+	}
+	l.counts[ip]++
+
+	return 0, true
+}
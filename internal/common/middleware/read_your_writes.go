@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+)
+
+// ReadYourWrites sends the reads of a request to the primary database once
+// the request wrote, so a response never misses the write it follows
+func ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(replica.WithSession(r.Context())))
+	})
+}
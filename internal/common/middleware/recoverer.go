@@ -5,26 +5,22 @@ import (
 	"net/http"
 	"runtime/debug"
 
-	"github.com/farolinar/dealls-bumble/internal/common/response"
 	servicebase "github.com/farolinar/dealls-bumble/services/base"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 func PanicRecoverer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			r := recover()
-			if r != nil {
-				if r != http.ErrAbortHandler {
-					log.Error().Msg(fmt.Sprintf("Recovered from panic: %s", string(debug.Stack())))
-				}
-				err := response.JSON(w, http.StatusInternalServerError, servicebase.ResponseBody{
-					Message: "Internal server error",
-					Code:    servicebase.Code5XX,
-				})
-				if err != nil {
-					log.Error().Msg(fmt.Sprintf("Error writing response: %v", err))
+			rec := recover()
+			if rec != nil {
+				if rec != http.ErrAbortHandler {
+					zerolog.Ctx(r.Context()).Error().
+						Str("panic", fmt.Sprint(rec)).
+						Str("stack", string(debug.Stack())).
+						Msg("recovered from panic")
 				}
+				servicebase.WriteError(w, r, servicebase.ErrInternal)
 			}
 		}()
 		next.ServeHTTP(w, r)
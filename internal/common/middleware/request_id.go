@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+var (
+	RequestIDLength    = 20
+	MaxRequestIDLength = 128
+)
+
+type ContextRequestIDKey struct{}
+
+// RequestID accepts the X-Request-ID of the client or generates one, echoes it
+// back and stores a sub-logger with the correlation fields in the request
+// context. Code handling the request should log through zerolog.Ctx. When the
+// request is traced the span gets the request ID and the logger the trace and
+// span IDs, so logs and traces can be joined either way.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(HeaderRequestID)
+		if !validRequestID(requestID) {
+			requestID = uid.GenerateStringID(RequestIDLength)
+		}
+		w.Header().Set(HeaderRequestID, requestID)
+
+		logContext := log.Logger.With().
+			Str("request_id", requestID).
+			Str("ip", clientIP(r))
+		if mux.CurrentRoute(r) != nil {
+			logContext = logContext.Str("route", routeTemplate(r))
+		}
+		spanContext := trace.SpanContextFromContext(r.Context())
+		if spanContext.IsValid() {
+			logContext = logContext.
+				Str("trace_id", spanContext.TraceID().String()).
+				Str("span_id", spanContext.SpanID().String())
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request_id", requestID))
+		}
+		logger := logContext.Logger()
+
+		ctx := context.WithValue(r.Context(), ContextRequestIDKey{}, requestID)
+		ctx = logger.WithContext(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID returns the request ID set by RequestID
+func GetRequestID(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(ContextRequestIDKey{}).(string)
+	return requestID, ok
+}
+
+// addLogUserUID adds the authenticated user to the request logger. The logger
+// is updated in place so middleware running before authorization, like
+// Logging, logs the user as well.
+func addLogUserUID(ctx context.Context, userUID string) {
+	// without RequestID the context logger is the shared default logger
+	if _, ok := GetRequestID(ctx); !ok {
+		return
+	}
+
+	zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("user_uid", userUID)
+	})
+}
+
+// validRequestID rejects client IDs that would pollute the logs
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > MaxRequestIDLength {
+		return false
+	}
+
+	for _, char := range requestID {
+		if char < '!' || char > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,239 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/auth"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_Unit_ContentLanguage(t *testing.T) {
+	handler := middleware.Localize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(i18n.FromContext(r.Context()).T(servicebase.MessageSuccess)))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "id;q=0.9,en;q=0.8")
+	requestRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(requestRecorder, req)
+
+	assert.Equal(t, "id", requestRecorder.Header().Get("Content-Language"))
+	assert.Equal(t, "Accept-Language", requestRecorder.Header().Get("Vary"))
+	assert.Equal(t, "Sukses", requestRecorder.Body.String())
+}
+
+func TestMiddleware_Unit_RequestID(t *testing.T) {
+	var logs bytes.Buffer
+	globalLogger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	t.Cleanup(func() { log.Logger = globalLogger })
+
+	cfg := config.AppConfig{App: config.App{Secret: "secret", JWTHourDuration: 1}}
+	token, err := auth.CreateAccessToken(cfg, "user123")
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(middleware.Logging)
+	router.Use(middleware.PanicRecoverer)
+	router.HandleFunc("/v1/user/{uid}", middleware.Authorize(cfg, func(w http.ResponseWriter, r *http.Request) {
+		zerolog.Ctx(r.Context()).Info().Msg("inside handler")
+		if r.URL.Query().Get("panic") != "" {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name      string
+		requestID string
+		generated bool
+		query     string
+	}{
+		{
+			name:      "Client request ID is echoed",
+			requestID: "client-id-1",
+		},
+		{
+			name:      "Missing request ID is generated",
+			generated: true,
+		},
+		{
+			name:      "Invalid request ID is replaced",
+			requestID: "bad id\nwith newline",
+			generated: true,
+		},
+		{
+			name:      "Panic logs carry the correlation fields",
+			requestID: "client-id-2",
+			query:     "?panic=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/v1/user/abc"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+			if tt.requestID != "" {
+				req.Header.Set(middleware.HeaderRequestID, tt.requestID)
+			}
+			requestRecorder := httptest.NewRecorder()
+			router.ServeHTTP(requestRecorder, req)
+
+			requestID := requestRecorder.Header().Get(middleware.HeaderRequestID)
+			if tt.generated {
+				assert.Len(t, requestID, middleware.RequestIDLength)
+			} else {
+				assert.Equal(t, tt.requestID, requestID)
+			}
+
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			assert.NotEmpty(t, lines)
+			for _, line := range lines {
+				var entry map[string]interface{}
+				assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+				assert.Equal(t, requestID, entry["request_id"], line)
+				assert.Equal(t, "/v1/user/{uid}", entry["route"], line)
+				// without trusted proxies the forwarded addresses are ignored
+				assert.Equal(t, "192.0.2.1", entry["ip"], line)
+				assert.Equal(t, "user123", entry["user_uid"], line)
+			}
+			if tt.query != "" {
+				assert.Contains(t, logs.String(), "recovered from panic")
+			}
+		})
+	}
+}
+
+// the limit is read on every request, so a settings update applies at once
+func TestMiddleware_Unit_RateLimit(t *testing.T) {
+	current := settings.Defaults()
+	store := settings.NewStore(current)
+	handler := middleware.RateLimit(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":41000"
+		// a client cannot pass for another one
+		req.Header.Set("X-Forwarded-For", uid.GenerateStringID(8))
+		requestRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(requestRecorder, req)
+		return requestRecorder
+	}
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusNoContent, serve("/v1/match/feed", "10.0.0.1").Code)
+	}
+
+	current.RateLimitPerMinute = 2
+	assert.NoError(t, store.Update(current))
+	assert.Equal(t, http.StatusNoContent, serve("/v1/match/feed", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusNoContent, serve("/v1/match/feed", "10.0.0.1").Code)
+
+	limited := serve("/v1/match/feed", "10.0.0.1")
+	var body servicebase.ResponseBody
+	assert.NoError(t, json.NewDecoder(limited.Body).Decode(&body))
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, servicebase.ErrTooManyRequests.Code, body.Code)
+	assert.NotEmpty(t, limited.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusNoContent, serve("/v1/match/feed", "10.0.0.2").Code)
+	assert.Equal(t, http.StatusNoContent, serve("/health/ready", "10.0.0.1").Code)
+}
+
+func TestMiddleware_Unit_ClientIP(t *testing.T) {
+	trusted, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10"})
+	assert.NoError(t, err)
+	_, err = middleware.ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		{
+			name:   "The remote address without forwarding",
+			remote: "203.0.113.7:41000",
+			ip:     "203.0.113.7",
+		},
+		{
+			name:      "Untrusted remotes cannot forward",
+			remote:    "203.0.113.7:41000",
+			forwarded: []string{"198.51.100.1"},
+			ip:        "203.0.113.7",
+		},
+		{
+			name:      "The rightmost untrusted hop behind trusted proxies",
+			remote:    "10.0.0.2:41000",
+			forwarded: []string{"198.51.100.1, 203.0.113.7, 192.0.2.10"},
+			ip:        "203.0.113.7",
+		},
+		{
+			name:      "Repeated headers are one list",
+			remote:    "192.0.2.10:41000",
+			forwarded: []string{"198.51.100.1", "203.0.113.7, 10.1.2.3"},
+			ip:        "203.0.113.7",
+		},
+		{
+			name:      "Only trusted hops",
+			remote:    "10.0.0.2:41000",
+			forwarded: []string{"10.0.0.3, 10.0.0.4"},
+			ip:        "10.0.0.3",
+		},
+		{
+			name:   "A trusted proxy without forwarding",
+			remote: "10.0.0.2:41000",
+			ip:     "10.0.0.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip string
+			handler := middleware.ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, _ = middleware.GetClientIP(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.ip, ip)
+		})
+	}
+}
+
+func TestMiddleware_Unit_ReadYourWrites(t *testing.T) {
+	var wrote []bool
+	handler := middleware.ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			replica.MarkWrite(r.Context())
+		}
+		wrote = append(wrote, replica.ReadsPrimary(r.Context()))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []bool{true, false}, wrote)
+}
@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// lockID keys the advisory lock held while migrating, so instances started
+// together do not apply the same script twice
+const lockID = 74_310_001
+
+// Migration is a SQL script, its version is the file name without .sql
+type Migration struct {
+	Version string
+	SQL     string
+}
+
+// Load reads the .sql files at the root of fsys, ordered by name
+func Load(fsys fs.FS) (migrations []Migration, err error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", name, err)
+		}
+		migrations = append(migrations, Migration{
+			Version: strings.TrimSuffix(path.Base(name), ".sql"),
+			SQL:     string(data),
+		})
+	}
+
+	return
+}
+
+// Up applies the migrations of fsys not recorded in
+// dealls_bumble.schema_migrations yet, in order, and returns their versions.
+// Scripts run as is, so each one manages its own transaction, and must be
+// safe on databases created from the current init.sql.
+func Up(ctx context.Context, db *sql.DB, fsys fs.FS) (applied []string, err error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return
+	}
+
+	// the lock belongs to the session, every statement runs on this connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, lockID)
+	if err != nil {
+		return nil, fmt.Errorf("error locking migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, lockID)
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS dealls_bumble.schema_migrations (
+            version VARCHAR PRIMARY KEY,
+            applied_at TIMESTAMP NOT NULL DEFAULT current_timestamp
+        );
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+
+	done, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return
+	}
+
+	for _, migration := range migrations {
+		if done[migration.Version] {
+			continue
+		}
+
+		_, err = conn.ExecContext(ctx, migration.SQL)
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %s: %w", migration.Version, err)
+		}
+		_, err = conn.ExecContext(ctx, `INSERT INTO dealls_bumble.schema_migrations (version) VALUES ($1);`, migration.Version)
+		if err != nil {
+			return applied, fmt.Errorf("error recording migration %s: %w", migration.Version, err)
+		}
+		applied = append(applied, migration.Version)
+	}
+
+	return
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (versions map[string]bool, err error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM dealls_bumble.schema_migrations;`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	versions = map[string]bool{}
+	for rows.Next() {
+		var version string
+		err = rows.Scan(&version)
+		if err != nil {
+			return
+		}
+		versions[version] = true
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,39 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate_Unit_Up(t *testing.T) {
+	db, mocking, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock: %v", err)
+	}
+	migrations := fstest.MapFS{
+		"0002_second.sql": {Data: []byte("alter table b;")},
+		"0001_first.sql":  {Data: []byte("alter table a;")},
+		"0003_third.sql":  {Data: []byte("alter table c;")},
+		"README.md":       {Data: []byte("not a migration")},
+	}
+
+	mocking.ExpectExec(`SELECT pg_advisory_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mocking.ExpectExec(`CREATE TABLE IF NOT EXISTS dealls_bumble.schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mocking.ExpectQuery(`SELECT version FROM dealls_bumble.schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("0001_first"))
+	mocking.ExpectExec(`alter table b;`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mocking.ExpectExec(`INSERT INTO dealls_bumble.schema_migrations`).WithArgs("0002_second").WillReturnResult(sqlmock.NewResult(0, 1))
+	mocking.ExpectExec(`alter table c;`).WillReturnError(errors.New("syntax error"))
+	mocking.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := migrate.Up(context.Background(), db, migrations)
+	assert.ErrorContains(t, err, "error applying migration 0003_third: syntax error")
+	assert.Equal(t, []string{"0002_second"}, applied)
+	assert.NoError(t, mocking.ExpectationsWereMet())
+}
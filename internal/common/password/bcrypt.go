@@ -3,11 +3,15 @@ package password
 import (
 	"errors"
 
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/crypto/bcrypt"
 )
 
 func Hash(salt int, plaintextPassword string) (string, error) {
+	timer := prometheus.NewTimer(metrics.BcryptHashDuration)
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), salt)
+	timer.ObserveDuration()
 	if err != nil {
 		return "", err
 	}
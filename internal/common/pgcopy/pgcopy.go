@@ -0,0 +1,126 @@
+package pgcopy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Column is a column of the staging table
+type Column struct {
+	Name string
+	Type string
+}
+
+// Load describes a bulk insert. Rows are copied into a temporary staging
+// table with COPY, then the statements move them into the real tables, which
+// keeps constraints, conflicts and joins on UIDs in SQL.
+type Load struct {
+	// Staging names the temporary table, dropped once the load is done
+	Staging string
+	Columns []Column
+	Rows    [][]any
+	// Statements run in order after the copy, reading from Staging
+	Statements []string
+}
+
+// Run runs the load and returns the rows affected by the first statement.
+// It joins the transaction of ctx when there is one, see txn.WithinTx, and
+// runs in its own transaction otherwise. db must use the pgx driver, COPY is
+// not part of database/sql.
+func Run(ctx context.Context, db *sql.DB, load Load) (affected int64, err error) {
+	if txn.InTx(ctx) {
+		err = txn.Raw(ctx, func(driverConn any) error {
+			conn, err := pgxConn(driverConn)
+			if err != nil {
+				return err
+			}
+
+			affected, err = run(ctx, conn, load)
+			return err
+		})
+		return
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn any) error {
+		conn, err := pgxConn(driverConn)
+		if err != nil {
+			return err
+		}
+
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		affected, err = run(ctx, tx, load)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+
+	return
+}
+
+func pgxConn(driverConn any) (*pgx.Conn, error) {
+	conn, ok := driverConn.(interface{ Conn() *pgx.Conn })
+	if !ok {
+		return nil, errors.New("COPY requires the pgx driver")
+	}
+	return conn.Conn(), nil
+}
+
+// executor is a pgx connection or transaction
+type executor interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// run must be called in a transaction. The staging table is dropped once
+// done so a transaction can run several loads into the same table.
+func run(ctx context.Context, db executor, load Load) (affected int64, err error) {
+	definitions := make([]string, len(load.Columns))
+	names := make([]string, len(load.Columns))
+	for i, column := range load.Columns {
+		definitions[i] = column.Name + " " + column.Type
+		names[i] = column.Name
+	}
+
+	staging := pgx.Identifier{load.Staging}.Sanitize()
+	_, err = db.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (%s) ON COMMIT DROP;", staging, strings.Join(definitions, ", ")))
+	if err != nil {
+		return 0, fmt.Errorf("error creating %s: %w", load.Staging, err)
+	}
+
+	_, err = db.CopyFrom(ctx, pgx.Identifier{load.Staging}, names, pgx.CopyFromRows(load.Rows))
+	if err != nil {
+		return 0, fmt.Errorf("error copying into %s: %w", load.Staging, err)
+	}
+
+	for i, statement := range load.Statements {
+		tag, err := db.Exec(ctx, statement)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			affected = tag.RowsAffected()
+		}
+	}
+
+	_, err = db.Exec(ctx, fmt.Sprintf("DROP TABLE %s;", staging))
+	return
+}
@@ -0,0 +1,219 @@
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Replica is a read-only copy of the primary database
+type Replica struct {
+	Name string
+	DB   *sql.DB
+}
+
+type member struct {
+	Replica
+	healthy atomic.Bool
+}
+
+// Set routes reads to the healthy replicas in turn, and to the primary when
+// none is healthy or the context reads its own writes. Health is checked by
+// pinging the replicas while the Set runs as a worker.
+type Set struct {
+	primary  *sql.DB
+	replicas []*member
+	next     atomic.Uint64
+
+	interval time.Duration
+	timeout  time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type Option func(*Set)
+
+// WithCheckInterval sets how often replicas are pinged, every 5 seconds by
+// default
+func WithCheckInterval(d time.Duration) Option {
+	return func(s *Set) { s.interval = d }
+}
+
+// WithCheckTimeout bounds each ping, 2 seconds by default
+func WithCheckTimeout(d time.Duration) Option {
+	return func(s *Set) { s.timeout = d }
+}
+
+// NewSet returns a set reading from replicas, which start healthy until
+// checked
+func NewSet(primary *sql.DB, replicas []Replica, opts ...Option) *Set {
+	s := &Set{primary: primary, interval: 5 * time.Second, timeout: 2 * time.Second}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for _, replica := range replicas {
+		m := &member{Replica: replica}
+		m.healthy.Store(true)
+		metrics.ReplicaUp.WithLabelValues(replica.Name).Set(1)
+		s.replicas = append(s.replicas, m)
+	}
+
+	return s
+}
+
+// Single returns a set without replicas, every read goes to db
+func Single(db *sql.DB) *Set {
+	return NewSet(db, nil)
+}
+
+// Primary returns the database taking the writes
+func (s *Set) Primary() *sql.DB {
+	return s.primary
+}
+
+// Reader returns the database to read from
+func (s *Set) Reader(ctx context.Context) *sql.DB {
+	if len(s.replicas) == 0 || ReadsPrimary(ctx) {
+		return s.primary
+	}
+
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		m := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if m.healthy.Load() {
+			return m.DB
+		}
+	}
+
+	return s.primary
+}
+
+// Healthy returns the names of the replicas currently read from
+func (s *Set) Healthy() []string {
+	var names []string
+	for _, m := range s.replicas {
+		if m.healthy.Load() {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+func (s *Set) Name() string {
+	return "replicas"
+}
+
+// Start checks the replicas once, then keeps checking them in the background
+func (s *Set) Start(ctx context.Context) error {
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	s.Check(ctx)
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.run()
+
+	return nil
+}
+
+func (s *Set) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Check(context.Background())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Set) Stop(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+
+	close(s.stop)
+	s.wg.Wait()
+	return nil
+}
+
+// Check pings every replica, a replica failing is not read from until it
+// answers again
+func (s *Set) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, m := range s.replicas {
+		wg.Add(1)
+		go func(m *member) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			err := m.DB.PingContext(ctx)
+
+			healthy := err == nil
+			if m.healthy.Swap(healthy) == healthy {
+				return
+			}
+			if healthy {
+				metrics.ReplicaUp.WithLabelValues(m.Name).Set(1)
+				log.Info().Str("replica", m.Name).Msg("Replica is back, reading from it")
+			} else {
+				metrics.ReplicaUp.WithLabelValues(m.Name).Set(0)
+				log.Warn().Str("replica", m.Name).Msg(fmt.Sprintf("Replica failed its check, reading elsewhere | %s", err.Error()))
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+type (
+	primaryKey struct{}
+	sessionKey struct{}
+)
+
+// ReadYourWrites returns a context whose reads go to the primary, for code
+// that must see what it just wrote despite the replication lag
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// session records whether the request carrying it wrote
+type session struct {
+	wrote atomic.Bool
+}
+
+// WithSession returns a context remembering its writes, see MarkWrite. A
+// request gets one so its reads following a write go to the primary.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+// MarkWrite records a write in the session of ctx, if any. Repositories call
+// it on every write.
+func MarkWrite(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		s.wrote.Store(true)
+	}
+}
+
+// ReadsPrimary reports whether reads of ctx must go to the primary
+func ReadsPrimary(ctx context.Context) bool {
+	if ctx.Value(primaryKey{}) != nil {
+		return true
+	}
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	return ok && s.wrote.Load()
+}
@@ -0,0 +1,85 @@
+package replica_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplica_Unit_Set(t *testing.T) {
+	ctx := context.Background()
+	newDB := func(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+		db, mocking, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		return db, mocking
+	}
+	primary, _ := newDB(t)
+	first, firstMock := newDB(t)
+	second, secondMock := newDB(t)
+	set := replica.NewSet(primary, []replica.Replica{{Name: "first", DB: first}, {Name: "second", DB: second}})
+
+	t.Run("Reads go to the replicas in turn", func(t *testing.T) {
+		readers := []*sql.DB{set.Reader(ctx), set.Reader(ctx), set.Reader(ctx), set.Reader(ctx)}
+		assert.ElementsMatch(t, []*sql.DB{first, second, first, second}, readers)
+		assert.NotSame(t, readers[0], readers[1])
+		assert.Same(t, primary, set.Primary())
+	})
+
+	t.Run("A failing replica is skipped until it answers", func(t *testing.T) {
+		firstMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		secondMock.ExpectPing()
+		set.Check(ctx)
+		assert.Equal(t, []string{"second"}, set.Healthy())
+		assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ReplicaUp.WithLabelValues("first")))
+		for i := 0; i < 3; i++ {
+			assert.Same(t, second, set.Reader(ctx))
+		}
+
+		firstMock.ExpectPing()
+		secondMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		set.Check(ctx)
+		assert.Equal(t, []string{"first"}, set.Healthy())
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ReplicaUp.WithLabelValues("first")))
+		assert.Same(t, first, set.Reader(ctx))
+	})
+
+	t.Run("Reads fall back to the primary without healthy replicas", func(t *testing.T) {
+		firstMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		secondMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		set.Check(ctx)
+		assert.Empty(t, set.Healthy())
+		assert.Same(t, primary, set.Reader(ctx))
+		assert.Same(t, primary, replica.Single(primary).Reader(ctx))
+
+		firstMock.ExpectPing()
+		secondMock.ExpectPing()
+		set.Check(ctx)
+		assert.Len(t, set.Healthy(), 2)
+	})
+
+	t.Run("Reads follow writes to the primary", func(t *testing.T) {
+		assert.Same(t, primary, set.Reader(replica.ReadYourWrites(ctx)))
+
+		// a write outside a session changes nothing
+		replica.MarkWrite(ctx)
+		assert.False(t, replica.ReadsPrimary(ctx))
+
+		session := replica.WithSession(ctx)
+		assert.NotSame(t, primary, set.Reader(session))
+		replica.MarkWrite(session)
+		assert.True(t, replica.ReadsPrimary(session))
+		assert.Same(t, primary, set.Reader(session))
+	})
+}
@@ -2,7 +2,10 @@ package response
 
 import (
 	"encoding/json"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // import (
@@ -39,9 +42,50 @@ func JSONWithHeaders(w http.ResponseWriter, status int, data any, headers http.H
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", ContentTypeJSON)
 	w.WriteHeader(status)
 	_, err = w.Write(js)
 
 	return err
 }
+
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeProblemJSON = "application/problem+json"
+)
+
+// ProblemJSON writes data as RFC 7807 problem details
+func ProblemJSON(w http.ResponseWriter, status int, data any) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", ContentTypeProblemJSON)
+	w.WriteHeader(status)
+	_, err = w.Write(js)
+
+	return err
+}
+
+// WantsProblem reports whether the Accept header of r asks for problem
+// details, application/json stays the default otherwise
+func WantsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil || mediaType != ContentTypeProblemJSON {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(params["q"], 64)
+			if params["q"] == "" || (err == nil && q > 0) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
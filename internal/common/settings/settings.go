@@ -0,0 +1,189 @@
+package settings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are the knobs that can change while the service is running. A
+// snapshot is never modified, Store.Update swaps it for a new one.
+type Settings struct {
+	LogLevel string `yaml:"log_level" json:"log_level"`
+	// SwipeDailyLimit is the number of swipes a day without the
+	// unlimited_swipes perk, 0 means unlimited
+	SwipeDailyLimit int `yaml:"swipe_daily_limit" json:"swipe_daily_limit"`
+	// RateLimitPerMinute is the number of requests a client IP can make a
+	// minute, 0 means unlimited
+	RateLimitPerMinute int             `yaml:"rate_limit_per_minute" json:"rate_limit_per_minute"`
+	Password           PasswordPolicy  `yaml:"password" json:"password"`
+	Features           map[string]bool `yaml:"features" json:"features"`
+}
+
+type PasswordPolicy struct {
+	MinLength      int  `yaml:"min_length" json:"min_length"`
+	RequireUpper   bool `yaml:"require_upper" json:"require_upper"`
+	RequireLower   bool `yaml:"require_lower" json:"require_lower"`
+	RequireDigit   bool `yaml:"require_digit" json:"require_digit"`
+	RequireSpecial bool `yaml:"require_special" json:"require_special"`
+}
+
+// Defaults returns the settings used when no settings file is given
+func Defaults() Settings {
+	return Settings{
+		LogLevel: "INFO",
+		Password: PasswordPolicy{
+			MinLength:      8,
+			RequireUpper:   true,
+			RequireLower:   true,
+			RequireSpecial: true,
+		},
+		Features: map[string]bool{
+			"travel_mode": true,
+		},
+	}
+}
+
+func (s Settings) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.LogLevel, validation.Required, validation.By(func(value interface{}) error {
+			_, err := ParseLogLevel(value.(string))
+			return err
+		})),
+		validation.Field(&s.SwipeDailyLimit, validation.Min(0)),
+		validation.Field(&s.RateLimitPerMinute, validation.Min(0)),
+		validation.Field(&s.Password),
+	)
+}
+
+func (p PasswordPolicy) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.MinLength, validation.Required, validation.Min(8), validation.Max(72)),
+	)
+}
+
+// FeatureEnabled reports whether the feature flag name is on, unknown flags
+// are off
+func (s Settings) FeatureEnabled(name string) bool {
+	return s.Features[name]
+}
+
+// ParseLogLevel parses the level names of the config, like DEBUG
+func ParseLogLevel(level string) (zerolog.Level, error) {
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil || parsed == zerolog.NoLevel {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level %q", level)
+	}
+	return parsed, nil
+}
+
+// Store holds the current snapshot. Consumers call Get on every use instead
+// of keeping a copy, so updates apply to the next request.
+type Store struct {
+	current atomic.Pointer[Settings]
+
+	mu          sync.Mutex
+	base        Settings
+	subscribers []func(Settings)
+}
+
+// NewStore returns a store holding base, the settings files are layered on
+func NewStore(base Settings) *Store {
+	s := &Store{base: base}
+	s.current.Store(&base)
+	return s
+}
+
+var defaultStore = NewStore(Defaults())
+
+// Default returns the store of the process, read by code without access to
+// the App such as validation rules
+func Default() *Store {
+	return defaultStore
+}
+
+// Get returns the current snapshot, it must not be modified
+func (s *Store) Get() Settings {
+	return *s.current.Load()
+}
+
+// Update validates next and swaps it in, subscribers are called with it. An
+// invalid snapshot is rejected and the current one kept.
+func (s *Store) Update(next Settings) error {
+	err := next.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.current.Store(&next)
+	for _, subscriber := range s.subscribers {
+		subscriber(next)
+	}
+
+	return nil
+}
+
+// SetBase replaces the settings the files are layered on and swaps them in,
+// used at startup to derive them from the config
+func (s *Store) SetBase(base Settings) error {
+	err := s.Update(base)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.base = base
+	return nil
+}
+
+// Subscribe calls fn with the current settings and after every update
+func (s *Store) Subscribe(fn func(Settings)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscribers = append(s.subscribers, fn)
+	fn(s.Get())
+}
+
+// LoadFile reads a YAML settings file on top of the base settings of the store
+// and swaps it in. Keys missing from the file keep their base value.
+func (s *Store) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return s.load(path, data)
+}
+
+// load decodes data, read from path, like LoadFile
+func (s *Store) load(path string, data []byte) error {
+	s.mu.Lock()
+	next := s.base
+	s.mu.Unlock()
+	// decoding merges into the map, which is shared with the base
+	next.Features = maps.Clone(next.Features)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&next)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error decoding %s: %w", path, err)
+	}
+
+	return s.Update(next)
+}
@@ -0,0 +1,79 @@
+package settings_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings_Unit_Store(t *testing.T) {
+	t.Run("Invalid updates keep the current snapshot", func(t *testing.T) {
+		store := settings.NewStore(settings.Defaults())
+		var seen []int
+		store.Subscribe(func(s settings.Settings) { seen = append(seen, s.SwipeDailyLimit) })
+
+		next := settings.Defaults()
+		next.SwipeDailyLimit = 20
+		assert.NoError(t, store.Update(next))
+
+		invalid := next
+		invalid.LogLevel = "LOUD"
+		assert.Error(t, store.Update(invalid))
+
+		assert.Equal(t, 20, store.Get().SwipeDailyLimit)
+		assert.Equal(t, "INFO", store.Get().LogLevel)
+		assert.Equal(t, []int{0, 20}, seen)
+	})
+
+	t.Run("Watcher reloads the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "settings.yaml")
+		assert.NoError(t, os.WriteFile(path, []byte("swipe_daily_limit: 5\n"), 0o600))
+
+		store := settings.NewStore(settings.Defaults())
+		watcher := settings.NewWatcher(store, path)
+		assert.NoError(t, watcher.Start(context.Background()))
+		defer watcher.Stop(context.Background())
+		assert.Equal(t, 5, store.Get().SwipeDailyLimit)
+
+		assert.NoError(t, os.WriteFile(path, []byte("swipe_daily_limit: 7\nfeatures:\n  travel_mode: false\n"), 0o600))
+		assert.True(t, store.Get().FeatureEnabled("travel_mode"))
+		assert.Eventually(t, func() bool { return store.Get().SwipeDailyLimit == 7 }, 2*time.Second, 10*time.Millisecond)
+		assert.False(t, store.Get().FeatureEnabled("travel_mode"))
+		assert.False(t, store.Get().FeatureEnabled("unknown"))
+
+		// a broken file is ignored, unknown keys are typos
+		assert.NoError(t, os.WriteFile(path, []byte("swipe_daily_limt: 1\n"), 0o600))
+		time.Sleep(300 * time.Millisecond)
+		assert.Equal(t, 7, store.Get().SwipeDailyLimit)
+	})
+
+	t.Run("Watcher follows config map symlink swaps", func(t *testing.T) {
+		// the layout of a Kubernetes config map volume: the file points
+		// through ..data, which is swapped to a new directory on updates
+		dir := t.TempDir()
+		version := func(name, content string) {
+			assert.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o755))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name, "settings.yaml"), []byte(content), 0o600))
+		}
+		version("..v1", "swipe_daily_limit: 5\n")
+		assert.NoError(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+		assert.NoError(t, os.Symlink(filepath.Join("..data", "settings.yaml"), filepath.Join(dir, "settings.yaml")))
+
+		store := settings.NewStore(settings.Defaults())
+		watcher := settings.NewWatcher(store, filepath.Join(dir, "settings.yaml"))
+		assert.NoError(t, watcher.Start(context.Background()))
+		defer watcher.Stop(context.Background())
+		assert.Equal(t, 5, store.Get().SwipeDailyLimit)
+
+		version("..v2", "swipe_daily_limit: 9\n")
+		assert.NoError(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+		assert.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+		assert.NoError(t, os.RemoveAll(filepath.Join(dir, "..v1")))
+		assert.Eventually(t, func() bool { return store.Get().SwipeDailyLimit == 9 }, 2*time.Second, 10*time.Millisecond)
+	})
+}
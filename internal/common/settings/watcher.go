@@ -0,0 +1,128 @@
+package settings
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// settle is how long the file must stay unchanged before it is reloaded.
+// Writers truncate the file before writing it, an empty file read in between
+// would reset every setting to its default.
+const settle = 100 * time.Millisecond
+
+// Watcher reloads a settings file into a store whenever it changes. A file
+// that fails to load is logged and the previous snapshot kept.
+type Watcher struct {
+	store *Store
+	path  string
+
+	watcher *fsnotify.Watcher
+	wg      sync.WaitGroup
+	// loaded is the content of the file last loaded
+	loaded []byte
+}
+
+func NewWatcher(store *Store, path string) *Watcher {
+	return &Watcher{store: store, path: filepath.Clean(path)}
+}
+
+func (w *Watcher) Name() string {
+	return "settings"
+}
+
+// Start loads the file once and watches its directory. Any change in the
+// directory rereads the file through its path, so editors replacing the file
+// and Kubernetes config maps swapping the ..data symlink the file points
+// through are caught, and the settings are reloaded when the content changed.
+func (w *Watcher) Start(ctx context.Context) error {
+	_, err := w.reload()
+	if err != nil {
+		return fmt.Errorf("error loading settings: %w", err)
+	}
+
+	w.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = w.watcher.Add(filepath.Dir(w.path))
+	if err != nil {
+		w.watcher.Close()
+		return fmt.Errorf("error watching settings: %w", err)
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+
+	reload := time.NewTimer(settle)
+	reload.Stop()
+	defer reload.Stop()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write | fsnotify.Create | fsnotify.Rename) {
+				continue
+			}
+			reload.Reset(settle)
+		case <-reload.C:
+			changed, err := w.reload()
+			if err != nil {
+				log.Error().Msgf("Error reloading settings, keeping the previous ones | %s", err.Error())
+				continue
+			}
+			if changed {
+				log.Info().Str("file", w.path).Msg("Settings reloaded")
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Msgf("Error watching settings | %s", err.Error())
+		}
+	}
+}
+
+// reload loads the file unless its content is the one last loaded, and
+// reports whether it did
+func (w *Watcher) reload() (changed bool, err error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return false, err
+	}
+	if w.loaded != nil && bytes.Equal(data, w.loaded) {
+		return false, nil
+	}
+
+	err = w.store.load(w.path, data)
+	if err != nil {
+		return false, err
+	}
+	w.loaded = data
+	return true, nil
+}
+
+func (w *Watcher) Stop(ctx context.Context) error {
+	if w.watcher == nil {
+		return nil
+	}
+
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
+}
@@ -0,0 +1,83 @@
+package tracing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing_Unit_Spans(t *testing.T) {
+	var logs bytes.Buffer
+	globalLogger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	t.Cleanup(func() { log.Logger = globalLogger })
+
+	exporter := tracetest.NewInMemoryExporter()
+	globalProvider, globalPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(globalProvider)
+		otel.SetTextMapPropagator(globalPropagator)
+	})
+
+	db, mock, _ := sqlmock.New()
+	mock.ExpectQuery("SELECT").WithArgs("secret-uid").WillReturnError(errors.New("connection reset"))
+	repository := userv1.NewRepository(db)
+
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware("dealls-bumble"))
+	router.Use(middleware.RequestID)
+	router.HandleFunc("/v1/user/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		_, err := repository.GetByUID(r.Context(), mux.Vars(r)["uid"])
+		zerolog.Ctx(r.Context()).Info().Err(err).Msg("inside handler")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/user/secret-uid", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(middleware.HeaderRequestID, "client-id-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	query, server := spans[0], spans[1]
+
+	assert.Equal(t, "/v1/user/{uid}", server.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Contains(t, server.Attributes, attribute.String("request_id", "client-id-1"))
+
+	assert.Equal(t, "user.GetByUID", query.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), query.Parent.SpanID())
+	assert.Equal(t, codes.Error, query.Status.Code)
+	for _, attr := range query.Attributes {
+		assert.NotContains(t, attr.Value.Emit(), "secret-uid")
+	}
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "client-id-1", entry["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entry["trace_id"])
+	assert.Equal(t, server.SpanContext.SpanID().String(), entry["span_id"])
+}
@@ -0,0 +1,204 @@
+package txn
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+)
+
+// Querier runs queries, implemented by both *sql.DB and *sql.Tx so
+// repositories do not care whether they run in a transaction
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type contextKey struct{}
+
+// state is the transaction of a context. The connection is kept to reach the
+// driver, see Raw.
+type state struct {
+	conn  *sql.Conn
+	tx    *sql.Tx
+	depth int
+}
+
+func stateFrom(ctx context.Context) *state {
+	s, _ := ctx.Value(contextKey{}).(*state)
+	return s
+}
+
+// From returns the transaction of ctx, or db outside WithinTx. Repositories
+// call it on every query.
+func From(ctx context.Context, db *sql.DB) Querier {
+	if s := stateFrom(ctx); s != nil {
+		return s.tx
+	}
+	return db
+}
+
+// InTx reports whether ctx carries a transaction
+func InTx(ctx context.Context) bool {
+	return stateFrom(ctx) != nil
+}
+
+// Raw runs fn on the driver connection of the transaction of ctx, for driver
+// features missing from database/sql such as COPY. It fails outside WithinTx.
+func Raw(ctx context.Context, fn func(driverConn any) error) error {
+	s := stateFrom(ctx)
+	if s == nil {
+		return errors.New("no transaction in context")
+	}
+	return s.conn.Raw(fn)
+}
+
+// postgres error codes worth running the transaction again for
+var retryable = map[string]string{
+	"40001": "serialization_failure",
+	"40P01": "deadlock_detected",
+}
+
+// TxManager runs functions in transactions. Build it with NewTxManager.
+type TxManager struct {
+	db         *sql.DB
+	isolation  sql.IsolationLevel
+	maxRetries int
+	backoff    time.Duration
+}
+
+type Option func(*TxManager)
+
+// WithIsolation sets the isolation level of the transactions, the database
+// default otherwise
+func WithIsolation(level sql.IsolationLevel) Option {
+	return func(m *TxManager) { m.isolation = level }
+}
+
+// WithMaxRetries sets how many times a transaction failing to serialize is
+// run again, 3 by default
+func WithMaxRetries(n int) Option {
+	return func(m *TxManager) { m.maxRetries = n }
+}
+
+// WithBackoff sets the wait before the first retry, doubled on each retry
+func WithBackoff(d time.Duration) Option {
+	return func(m *TxManager) { m.backoff = d }
+}
+
+func NewTxManager(db *sql.DB, opts ...Option) *TxManager {
+	m := &TxManager{db: db, maxRetries: 3, backoff: 10 * time.Millisecond}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// WithinTx runs fn with a context carrying a transaction, committed when fn
+// returns nil and rolled back otherwise, including when fn panics.
+//
+// The transaction is run again from the start when postgres fails to
+// serialize it or picks it as a deadlock victim, so fn must not have effects
+// outside the database. Called with a context already in a transaction, fn
+// runs in a savepoint instead: its error rolls back only its own writes and
+// is returned to the outer fn, which decides whether to fail.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s := stateFrom(ctx); s != nil {
+		return m.savepoint(ctx, s, fn)
+	}
+
+	backoff := m.backoff
+	for attempt := 0; ; attempt++ {
+		err := m.run(ctx, fn)
+		reason, ok := retryReason(err)
+		if !ok || attempt >= m.maxRetries {
+			return err
+		}
+
+		metrics.TxRetries.WithLabelValues(reason).Inc()
+		zerolog.Ctx(ctx).Debug().Msgf("retrying transaction after %s: %s", reason, err.Error())
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+func (m *TxManager) run(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: m.isolation})
+	if err != nil {
+		return
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+				err = errors.Join(err, fmt.Errorf("error rolling back: %w", rollbackErr))
+			}
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, contextKey{}, &state{conn: conn, tx: tx}))
+	if err != nil {
+		return
+	}
+
+	committed = true
+	return tx.Commit()
+}
+
+func (m *TxManager) savepoint(ctx context.Context, s *state, fn func(ctx context.Context) error) (err error) {
+	nested := &state{conn: s.conn, tx: s.tx, depth: s.depth + 1}
+	name := fmt.Sprintf("sp_%d", nested.depth)
+
+	_, err = s.tx.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return
+	}
+
+	released := false
+	defer func() {
+		if !released {
+			_, rollbackErr := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			if rollbackErr != nil {
+				err = errors.Join(err, fmt.Errorf("error rolling back to %s: %w", name, rollbackErr))
+			}
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, contextKey{}, nested))
+	if err != nil {
+		return
+	}
+
+	released = true
+	_, err = s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return
+}
+
+// retryReason reports whether err is worth running the transaction again,
+// with the name of the postgres error
+func retryReason(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+	reason, ok := retryable[pgErr.Code]
+	return reason, ok
+}
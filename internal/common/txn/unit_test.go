@@ -0,0 +1,164 @@
+package txn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn_Unit_WithinTx(t *testing.T) {
+	ctx := context.Background()
+	newManager := func(t *testing.T, opts ...txn.Option) (*sql.DB, sqlmock.Sqlmock, *txn.TxManager) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		return db, mocking, txn.NewTxManager(db, append([]txn.Option{txn.WithBackoff(0)}, opts...)...)
+	}
+	insert := func(ctx context.Context, db *sql.DB, value string) error {
+		_, err := txn.From(ctx, db).ExecContext(ctx, "INSERT INTO items VALUES ($1)", value)
+		return err
+	}
+	serializationFailure := &pgconn.PgError{Code: "40001"}
+
+	t.Run("Queries use the transaction and commit", func(t *testing.T) {
+		db, mocking, manager := newManager(t)
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO items").WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+
+		assert.False(t, txn.InTx(ctx))
+		err := manager.WithinTx(ctx, func(ctx context.Context) error {
+			assert.True(t, txn.InTx(ctx))
+			return insert(ctx, db, "a")
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("An error rolls back", func(t *testing.T) {
+		db, mocking, manager := newManager(t)
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO items").WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectRollback()
+
+		failed := errors.New("failed after the insert")
+		err := manager.WithinTx(ctx, func(ctx context.Context) error {
+			err := insert(ctx, db, "a")
+			if err != nil {
+				return err
+			}
+			return failed
+		})
+		assert.ErrorIs(t, err, failed)
+	})
+
+	t.Run("A panic rolls back", func(t *testing.T) {
+		_, mocking, manager := newManager(t)
+		mocking.ExpectBegin()
+		mocking.ExpectRollback()
+
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = manager.WithinTx(ctx, func(ctx context.Context) error {
+				panic("boom")
+			})
+		})
+	})
+
+	t.Run("Serialization failures are retried", func(t *testing.T) {
+		db, mocking, manager := newManager(t)
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO items").WillReturnError(serializationFailure)
+		mocking.ExpectRollback()
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO items").WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+
+		retries := metrics.TxRetries.WithLabelValues("serialization_failure")
+		retriesBefore := testutil.ToFloat64(retries)
+		attempts := 0
+		err := manager.WithinTx(ctx, func(ctx context.Context) error {
+			attempts++
+			return insert(ctx, db, "a")
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, retriesBefore+1, testutil.ToFloat64(retries))
+	})
+
+	t.Run("Retries give up after the maximum", func(t *testing.T) {
+		db, mocking, manager := newManager(t, txn.WithMaxRetries(1))
+		for i := 0; i < 2; i++ {
+			mocking.ExpectBegin()
+			mocking.ExpectExec("INSERT INTO items").WillReturnError(serializationFailure)
+			mocking.ExpectRollback()
+		}
+
+		err := manager.WithinTx(ctx, func(ctx context.Context) error {
+			return insert(ctx, db, "a")
+		})
+		assert.ErrorIs(t, err, serializationFailure)
+	})
+
+	t.Run("Other errors are not retried", func(t *testing.T) {
+		db, mocking, manager := newManager(t)
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO items").WillReturnError(&pgconn.PgError{Code: "23505"})
+		mocking.ExpectRollback()
+
+		err := manager.WithinTx(ctx, func(ctx context.Context) error {
+			return insert(ctx, db, "a")
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Nested calls roll back to their savepoint", func(t *testing.T) {
+		db, mocking, manager := newManager(t)
+		mocking.ExpectBegin()
+		mocking.ExpectExec("INSERT INTO items").WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectExec("INSERT INTO items").WithArgs("b").WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectExec("SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectExec("INSERT INTO items").WithArgs("c").WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec("RELEASE SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mocking.ExpectCommit()
+
+		failed := errors.New("optional step failed")
+		err := manager.WithinTx(ctx, func(ctx context.Context) error {
+			err := insert(ctx, db, "a")
+			if err != nil {
+				return err
+			}
+
+			// the outer function carries on without the writes of b
+			err = manager.WithinTx(ctx, func(ctx context.Context) error {
+				err := insert(ctx, db, "b")
+				if err != nil {
+					return err
+				}
+				return failed
+			})
+			assert.ErrorIs(t, err, failed)
+
+			return manager.WithinTx(ctx, func(ctx context.Context) error {
+				return manager.WithinTx(ctx, func(ctx context.Context) error {
+					return insert(ctx, db, "c")
+				})
+			})
+		})
+		assert.NoError(t, err)
+	})
+}
@@ -9,3 +9,21 @@ const chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 func GenerateStringID(n int) string {
 	return gonanoid.MustGenerate(chars, n)
 }
+
+// Generator generates IDs of n characters, services take one instead of
+// calling GenerateStringID so tests can predict them
+type Generator interface {
+	Generate(n int) string
+}
+
+// GeneratorFunc adapts a function to Generator
+type GeneratorFunc func(n int) string
+
+func (f GeneratorFunc) Generate(n int) string {
+	return f(n)
+}
+
+// Default returns the generator backed by GenerateStringID
+func Default() Generator {
+	return GeneratorFunc(GenerateStringID)
+}
@@ -0,0 +1,338 @@
+package container
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/blob"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/health"
+	"github.com/farolinar/dealls-bumble/internal/common/jobs"
+	"github.com/farolinar/dealls-bumble/internal/common/mail"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+)
+
+// Module is a domain plugging its routes and workers into the App
+type Module interface {
+	Name() string
+	Register(app *App, r *mux.Router)
+}
+
+// Worker is a background process started and stopped with the App
+type Worker interface {
+	Name() string
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// App holds the dependencies shared by the modules and runs the HTTP servers
+// and workers. Build it with New.
+type App struct {
+	Config config.AppConfig
+	DB     *sql.DB
+	// Tx runs functions in transactions of DB, see txn.TxManager
+	Tx *txn.TxManager
+	// Replicas routes reads to the read replicas of DB, if any
+	Replicas *replica.Set
+	// Outbox publishes domain events in transactions of Tx, they are relayed
+	// to the subscribers of Events
+	Outbox *events.Outbox
+	Events *events.Bus
+	// Jobs runs background jobs, modules register their handlers and
+	// schedules on it
+	Jobs   *jobs.Queue
+	Clock  clock.Clock
+	IDs    uid.Generator
+	Mailer mail.Mailer
+	Blobs  blob.Store
+	Probes *health.Registry
+	// Settings holds the runtime settings, read with Settings.Get on every use
+	Settings *settings.Store
+
+	router         *mux.Router
+	trustedProxies []netip.Prefix
+	modules        []Module
+	workers        []Worker
+
+	mu      sync.Mutex
+	servers []*http.Server
+	started []Worker
+}
+
+type Option func(*App)
+
+func WithDB(db *sql.DB) Option {
+	return func(a *App) { a.DB = db }
+}
+
+// WithReplicas reads from replicas, whose primary must be the database
+func WithReplicas(replicas *replica.Set) Option {
+	return func(a *App) { a.Replicas = replicas }
+}
+
+func WithClock(c clock.Clock) Option {
+	return func(a *App) { a.Clock = c }
+}
+
+func WithIDGenerator(ids uid.Generator) Option {
+	return func(a *App) { a.IDs = ids }
+}
+
+func WithMailer(mailer mail.Mailer) Option {
+	return func(a *App) { a.Mailer = mailer }
+}
+
+func WithBlobStore(blobs blob.Store) Option {
+	return func(a *App) { a.Blobs = blobs }
+}
+
+func WithProbes(probes *health.Registry) Option {
+	return func(a *App) { a.Probes = probes }
+}
+
+func WithSettings(store *settings.Store) Option {
+	return func(a *App) { a.Settings = store }
+}
+
+// WithModules registers modules in order, after the App is built
+func WithModules(modules ...Module) Option {
+	return func(a *App) { a.modules = append(a.modules, modules...) }
+}
+
+// New builds the App and its router. The database is required, the other
+// dependencies default to no read replicas, the system clock, nanoid IDs, a
+// logging mailer, the blob store of app.blob_dir, in memory when unset, and
+// the settings store of the process. The caller keeps ownership of the database.
+func New(cfg config.AppConfig, opts ...Option) (*App, error) {
+	a := &App{Config: cfg}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.DB == nil {
+		return nil, errors.New("a database is required")
+	}
+	var err error
+	a.trustedProxies, err = middleware.ParseTrustedProxies(cfg.App.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	a.Tx = txn.NewTxManager(a.DB)
+	if a.Replicas == nil {
+		a.Replicas = replica.Single(a.DB)
+	}
+	// checks the replicas while the app runs
+	a.AddWorker(a.Replicas)
+	if a.Clock == nil {
+		a.Clock = clock.System()
+	}
+	if a.IDs == nil {
+		a.IDs = uid.Default()
+	}
+	if a.Mailer == nil {
+		a.Mailer = mail.Log()
+	}
+	if a.Blobs == nil && cfg.App.BlobDir != "" {
+		a.Blobs, err = blob.Dir(cfg.App.BlobDir)
+		if err != nil {
+			return nil, fmt.Errorf("error opening blob directory: %w", err)
+		}
+	}
+	if a.Blobs == nil {
+		a.Blobs = blob.Memory()
+	}
+	if a.Probes == nil {
+		a.Probes = health.NewRegistry()
+	}
+	if a.Settings == nil {
+		a.Settings = settings.Default()
+	}
+	a.Outbox = events.NewOutbox(a.DB, a.Clock)
+	a.Events = events.NewBus()
+	a.AddWorker(events.NewRelay(a.DB, a.Events, a.Clock))
+	// stopped first, running jobs may still publish events
+	a.Jobs = jobs.NewQueue(a.DB, a.Tx, a.Clock)
+	a.AddWorker(a.Jobs)
+
+	a.Probes.Register("postgres", health.Ping(a.DB), cfg.Postgres.Timeout)
+	a.Probes.Register("mailer", a.Mailer, 5*time.Second)
+	a.Probes.Register("blob_store", a.Blobs, 3*time.Second)
+	// checks the workers in memory, no query to wait for
+	a.Probes.Register("jobs", a.Jobs, time.Second)
+
+	a.router = a.newRouter()
+	for _, module := range a.modules {
+		module.Register(a, a.router)
+		log.Debug().Str("module", module.Name()).Msg("Module registered")
+	}
+
+	return a, nil
+}
+
+func (a *App) newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(otelmux.Middleware(a.Config.App.Name))
+	r.Use(middleware.ClientIP(a.trustedProxies))
+	r.Use(middleware.RequestID)
+	r.Use(middleware.ReadYourWrites)
+	r.Use(middleware.Localize)
+	r.Use(middleware.Logging)
+	r.Use(middleware.PanicRecoverer)
+	r.Use(middleware.RateLimit(a.Settings))
+
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text")
+		w.WriteHeader(http.StatusOK)
+		_, err := io.WriteString(w, "Service ready")
+		if err != nil {
+			zerolog.Ctx(r.Context()).Error().Msgf("Error checking service: %s", err.Error())
+		}
+	})
+
+	if !a.separateMetrics() {
+		r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	}
+
+	hr := r.PathPrefix("/health").Subrouter()
+	hr.HandleFunc("/live", a.Probes.Live).Methods(http.MethodGet)
+	hr.HandleFunc("/ready", a.Probes.Ready).Methods(http.MethodGet)
+	hr.HandleFunc("/startup", a.Probes.Startup).Methods(http.MethodGet)
+
+	return r
+}
+
+func (a *App) separateMetrics() bool {
+	return a.Config.App.MetricsPort != 0 && a.Config.App.MetricsPort != a.Config.App.Port
+}
+
+// Router returns the router serving the API, also used to test it end to end
+func (a *App) Router() *mux.Router {
+	return a.router
+}
+
+// AddWorker adds a worker started by Start, meant to be called by modules
+func (a *App) AddWorker(w Worker) {
+	a.workers = append(a.workers, w)
+}
+
+// Start starts the workers, then listens on the API port and the metrics port
+// if separate. Startup probes pass once it returns without error, on error
+// the caller still calls Stop to release what was started.
+func (a *App) Start(ctx context.Context) error {
+	for _, w := range a.workers {
+		err := w.Start(ctx)
+		if err != nil {
+			return fmt.Errorf("error starting worker %s: %w", w.Name(), err)
+		}
+		a.mu.Lock()
+		a.started = append(a.started, w)
+		a.mu.Unlock()
+	}
+
+	err := a.listen("HTTP", a.Config.App.Port, a.router)
+	if err != nil {
+		return err
+	}
+
+	if a.separateMetrics() {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		err = a.listen("Metrics", a.Config.App.MetricsPort, metricsMux)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.Probes.MarkStarted()
+	return nil
+}
+
+func (a *App) listen(name string, port int, handler http.Handler) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("error listening for %s server: %w", name, err)
+	}
+
+	server := &http.Server{Addr: listener.Addr().String(), Handler: handler}
+	a.mu.Lock()
+	a.servers = append(a.servers, server)
+	a.mu.Unlock()
+
+	go func() {
+		log.Info().Msg(fmt.Sprintf("%s server listening on %s", name, server.Addr))
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Msg(fmt.Sprintf("%s server error: %v", name, err))
+		}
+		log.Info().Msg(fmt.Sprintf("%s server stopped serving new connections.", name))
+	}()
+
+	return nil
+}
+
+// Stop fails readiness, waits the configured shutdown delay, drains the
+// servers and then stops the workers in reverse order
+func (a *App) Stop(ctx context.Context) error {
+	a.Probes.MarkShuttingDown()
+	if delay := a.Config.App.ShutdownDelay; delay > 0 {
+		log.Info().Msg(fmt.Sprintf("Failing readiness for %s before shutting down", delay))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	a.mu.Lock()
+	servers, started := a.servers, a.started
+	a.servers, a.started = nil, nil
+	a.mu.Unlock()
+
+	var errs []error
+	for _, server := range servers {
+		log.Info().Msg(fmt.Sprintf("Shutting down server listening on %s", server.Addr))
+		err := server.Shutdown(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error shutting down server on %s: %w", server.Addr, err))
+		}
+	}
+
+	for i := len(started) - 1; i >= 0; i-- {
+		err := started[i].Stop(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error stopping worker %s: %w", started[i].Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Addrs returns the addresses the servers listen on, useful when started on
+// port 0
+func (a *App) Addrs() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	addrs := make([]string, len(a.servers))
+	for i, server := range a.servers {
+		addrs[i] = server.Addr
+	}
+	return addrs
+}
@@ -0,0 +1,147 @@
+package container_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	cmdapp "github.com/farolinar/dealls-bumble/cmd/app"
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/health"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	"github.com/farolinar/dealls-bumble/internal/container"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type testWorker struct {
+	events *[]string
+	name   string
+}
+
+func (w testWorker) Name() string { return w.name }
+
+func (w testWorker) Start(ctx context.Context) error {
+	*w.events = append(*w.events, "start "+w.name)
+	return nil
+}
+
+func (w testWorker) Stop(ctx context.Context) error {
+	*w.events = append(*w.events, "stop "+w.name)
+	return nil
+}
+
+type testModule struct {
+	events *[]string
+}
+
+func (m testModule) Name() string { return "test" }
+
+func (m testModule) Register(a *container.App, r *mux.Router) {
+	a.AddWorker(testWorker{events: m.events, name: "first"})
+	a.AddWorker(testWorker{events: m.events, name: "second"})
+	r.HandleFunc("/v1/test/now", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, a.Clock.Now().Format(time.RFC3339)+" "+a.IDs.Generate(4))
+	})
+}
+
+// the app is built with the real modules and router, only the database is
+// mocked
+func TestContainer_Unit_App(t *testing.T) {
+	db, mocking, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock: %v", err)
+	}
+
+	var events []string
+	cfg := config.AppConfig{App: config.App{Name: "dealls-bumble", Secret: "secret", JWTSecret: "secret", JWTHourDuration: 1}}
+	a, err := cmdapp.Initialize(cfg, db,
+		container.WithClock(clock.Fixed(time.Date(2024, 5, 26, 10, 0, 0, 0, time.UTC))),
+		container.WithIDGenerator(uid.GeneratorFunc(func(n int) string { return strings.Repeat("x", n) })),
+		container.WithModules(testModule{events: &events}),
+	)
+	if err != nil {
+		t.Fatalf("error initializing app: %v", err)
+	}
+
+	t.Run("Routes of the modules are served", func(t *testing.T) {
+		mocking.ExpectQuery(`SELECT uid, name, email, username, hashed_password`).WithArgs("nobody").
+			WillReturnError(sql.ErrNoRows)
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/user/login", strings.NewReader(`{"username": "nobody", "password": "Pass12345!"}`))
+		requestRecorder := httptest.NewRecorder()
+		a.Router().ServeHTTP(requestRecorder, req)
+
+		var body servicebase.ResponseBody
+		assert.NoError(t, json.NewDecoder(requestRecorder.Body).Decode(&body))
+		assert.Equal(t, http.StatusBadRequest, requestRecorder.Code)
+		assert.Equal(t, userv1.ErrUsernameNotFound.Code, body.Code)
+		assert.NotEmpty(t, requestRecorder.Header().Get(middleware.HeaderRequestID))
+		assert.NoError(t, mocking.ExpectationsWereMet())
+
+		req = httptest.NewRequest(http.MethodGet, "/v1/match/feed", nil)
+		requestRecorder = httptest.NewRecorder()
+		a.Router().ServeHTTP(requestRecorder, req)
+		assert.Equal(t, http.StatusUnauthorized, requestRecorder.Code)
+	})
+
+	t.Run("Modules get the injected dependencies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/test/now", nil)
+		requestRecorder := httptest.NewRecorder()
+		a.Router().ServeHTTP(requestRecorder, req)
+		assert.Equal(t, "2024-05-26T10:00:00Z xxxx", requestRecorder.Body.String())
+	})
+
+	t.Run("Readiness runs every check", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+		requestRecorder := httptest.NewRecorder()
+		a.Router().ServeHTTP(requestRecorder, req)
+
+		var report health.Report
+		assert.NoError(t, json.NewDecoder(requestRecorder.Body).Decode(&report))
+		names := make([]string, 0, len(report.Checks))
+		for _, check := range report.Checks {
+			names = append(names, check.Name)
+		}
+		assert.Equal(t, []string{"blob_store", "jobs", "mailer", "postgres"}, names)
+		// the workers only run once the app started
+		assert.Equal(t, http.StatusServiceUnavailable, requestRecorder.Code)
+		assert.Equal(t, "job workers are not running", report.Checks[1].Error)
+	})
+
+	t.Run("Lifecycle starts and stops servers and workers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health/startup", nil)
+		requestRecorder := httptest.NewRecorder()
+		a.Router().ServeHTTP(requestRecorder, req)
+		assert.Equal(t, http.StatusServiceUnavailable, requestRecorder.Code)
+
+		assert.NoError(t, a.Start(context.Background()))
+		addrs := a.Addrs()
+		if assert.Len(t, addrs, 1) {
+			res, err := http.Get("http://" + addrs[0] + "/health/startup")
+			if assert.NoError(t, err) {
+				res.Body.Close()
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			}
+		}
+
+		assert.NoError(t, a.Stop(context.Background()))
+		assert.Equal(t, []string{"start first", "start second", "stop second", "stop first"}, events)
+
+		req = httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+		requestRecorder = httptest.NewRecorder()
+		a.Router().ServeHTTP(requestRecorder, req)
+		assert.Equal(t, http.StatusServiceUnavailable, requestRecorder.Code)
+	})
+}
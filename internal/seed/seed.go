@@ -0,0 +1,425 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/blob"
+	"github.com/farolinar/dealls-bumble/internal/common/password"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	matchv1 "github.com/farolinar/dealls-bumble/services/v1/match"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+)
+
+// Password is the password of every seeded user
+const Password = "Seeded123!"
+
+// PhotoContentType is the content type of the placeholder photos
+const PhotoContentType = "image/png"
+
+type Options struct {
+	Users int
+	// Seed makes the generated data repeatable, the same seed generates the
+	// same users on the same day
+	Seed int64
+	// SwipesPerUser is how many other users each user swipes on
+	SwipesPerUser int
+	// Now is the day ages are counted from
+	Now time.Time
+	// BCryptSalt is the cost Password is hashed with
+	BCryptSalt int
+}
+
+// Photo is a placeholder picture stored in the blob store under Key
+type Photo struct {
+	Key  string
+	Data []byte
+}
+
+// Dataset is everything a seed generates, in insertion order
+type Dataset struct {
+	Users       []userv1.User
+	Preferences []userv1.UserPreferences
+	Images      []userv1.Image
+	Photos      []Photo
+	Swipes      []matchv1.Swipe
+}
+
+// Matches counts the mutual likes of the dataset
+func (d Dataset) Matches() int {
+	var matched int
+	for _, swipe := range d.Swipes {
+		if swipe.Matched {
+			matched++
+		}
+	}
+	return matched / 2
+}
+
+// Seeder writes generated datasets through the repositories, so the rows are
+// the ones the service would write, in one transaction
+type Seeder struct {
+	Tx      *txn.TxManager
+	Users   userv1.Repository
+	Matches matchv1.Repository
+	// Blobs stores the photos, no photos are created without it
+	Blobs blob.Store
+}
+
+// Result counts the rows created, rows already in the database from an
+// earlier run of the same seed are skipped
+type Result struct {
+	Users   int64
+	Swipes  int64
+	Matches int
+	Photos  int
+}
+
+// Run generates the dataset of opts and writes it. Every user gets Password,
+// hashed once as bcrypt is slow on purpose.
+func (s Seeder) Run(ctx context.Context, opts Options) (result Result, err error) {
+	data := Generate(opts)
+	if s.Blobs == nil {
+		data.Photos, data.Images = nil, nil
+	}
+
+	hashedPassword, err := password.Hash(opts.BCryptSalt, Password)
+	if err != nil {
+		return
+	}
+	for i := range data.Users {
+		data.Users[i].HashedPassword = &hashedPassword
+	}
+
+	// photos of a failed run are left in the blob store, they are
+	// overwritten by the next run of the seed
+	err = s.Tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+		result.Users, err = s.Users.CreateMany(ctx, data.Users)
+		if err != nil {
+			return fmt.Errorf("error creating users: %w", err)
+		}
+
+		err = s.Users.CreateManyPreferences(ctx, data.Preferences)
+		if err != nil {
+			return fmt.Errorf("error creating preferences: %w", err)
+		}
+
+		for _, photo := range data.Photos {
+			err = s.Blobs.Put(ctx, photo.Key, bytes.NewReader(photo.Data), PhotoContentType)
+			if err != nil {
+				return fmt.Errorf("error storing photo %s: %w", photo.Key, err)
+			}
+		}
+
+		err = s.Users.CreateManyImages(ctx, data.Images)
+		if err != nil {
+			return fmt.Errorf("error creating images: %w", err)
+		}
+
+		result.Swipes, err = s.Matches.CreateManySwipes(ctx, data.Swipes)
+		if err != nil {
+			return fmt.Errorf("error creating swipes: %w", err)
+		}
+		return
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	result.Photos = len(data.Photos)
+	result.Matches = data.Matches()
+
+	return
+}
+
+const uidChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var (
+	womenNames   = []string{"Ayu", "Citra", "Dewi", "Fitri", "Gita", "Indah", "Kirana", "Lestari", "Maya", "Nadia", "Putri", "Rani", "Sari", "Tari", "Wulan", "Yuni"}
+	menNames     = []string{"Adi", "Agus", "Andi", "Bayu", "Bima", "Budi", "Dimas", "Eko", "Fajar", "Hadi", "Joko", "Reza", "Rizky", "Teguh", "Wahyu", "Yoga"}
+	neutralNames = []string{"Alex", "Ari", "Dwi", "Eka", "Kris", "Nanda", "Sandy", "Tri"}
+	lastNames    = []string{"Gunawan", "Halim", "Hasibuan", "Hidayat", "Kusuma", "Nasution", "Nugroho", "Pratama", "Putra", "Santoso", "Saputra", "Setiawan", "Siregar", "Susanto", "Wibowo", "Wijaya"}
+	// reserved for documentation, mail never reaches anyone
+	emailDomains = []string{"example.com", "example.net", "example.org"}
+
+	// genderWeights roughly follow published dating app demographics,
+	// identities added by a custom mapping get weight 1
+	genderWeights = map[userv1.Gender]int{
+		userv1.GenderWoman:       44,
+		userv1.GenderMan:         44,
+		userv1.GenderNonBinary:   5,
+		userv1.GenderTransWoman:  2,
+		userv1.GenderTransMan:    2,
+		userv1.GenderGenderfluid: 2,
+		userv1.GenderAgender:     1,
+	}
+
+	// cities weighted by population, users are spread around their center
+	cities = []struct {
+		location userv1.Location
+		weight   int
+	}{
+		{userv1.Location{Latitude: -6.2088, Longitude: 106.8456}, 35}, // Jakarta
+		{userv1.Location{Latitude: -7.2575, Longitude: 112.7521}, 12}, // Surabaya
+		{userv1.Location{Latitude: -6.9175, Longitude: 107.6191}, 10}, // Bandung
+		{userv1.Location{Latitude: 3.5952, Longitude: 98.6722}, 8},    // Medan
+		{userv1.Location{Latitude: -6.9667, Longitude: 110.4167}, 6},  // Semarang
+		{userv1.Location{Latitude: -7.7956, Longitude: 110.3695}, 6},  // Yogyakarta
+		{userv1.Location{Latitude: -5.1477, Longitude: 119.4327}, 5},  // Makassar
+		{userv1.Location{Latitude: -8.6705, Longitude: 115.2126}, 5},  // Denpasar
+		{userv1.Location{Latitude: -2.9761, Longitude: 104.7754}, 5},  // Palembang
+		{userv1.Location{Latitude: -1.2379, Longitude: 116.8529}, 3},  // Balikpapan
+	}
+
+	distancesKm = []int{10, 25, 50, 100}
+)
+
+// Generate builds the dataset of opts. It only depends on Users, Seed,
+// SwipesPerUser and the day of Now, passwords are left to Run.
+func Generate(opts Options) (data Dataset) {
+	r := rand.New(rand.NewSource(opts.Seed))
+	today := time.Date(opts.Now.Year(), opts.Now.Month(), opts.Now.Day(), 0, 0, 0, 0, time.UTC)
+	mapping := userv1.GetGenderMapping()
+	genders, weights := genderChoices(mapping)
+
+	for i := 0; i < opts.Users; i++ {
+		gender := genders[pick(r, weights)]
+		group := mapping.GroupOf(gender)
+		first, last := firstName(r, group), lastNames[r.Intn(len(lastNames))]
+		handle := strings.ToLower(first + "." + last)
+
+		userAge := age(r)
+		user := userv1.User{
+			UID:        randomUID(r),
+			Name:       first + " " + last,
+			Email:      fmt.Sprintf("%s%d@%s", handle, i, emailDomains[r.Intn(len(emailDomains))]),
+			Username:   fmt.Sprintf("%s%d", strings.ReplaceAll(handle, ".", "_"), i),
+			Gender:     gender,
+			ShowGender: r.Intn(10) != 0,
+			Birthdate:  today.AddDate(-userAge, 0, -r.Intn(365)),
+			Sex:        mapping.LegacySexOf(gender),
+		}
+		if r.Intn(10) != 0 {
+			user.Location = nearCity(r)
+		}
+		data.Users = append(data.Users, user)
+
+		if r.Intn(100) < 85 {
+			data.Preferences = append(data.Preferences, userv1.UserPreferences{
+				UID:         user.UID,
+				Preferences: preferences(r, mapping, group, userAge),
+			})
+		}
+
+		photos := 1 + r.Intn(4)
+		for n := 1; n <= photos; n++ {
+			key := fmt.Sprintf("users/%s/photos/%d.png", user.UID, n)
+			data.Photos = append(data.Photos, Photo{Key: key, Data: placeholder(r)})
+			data.Images = append(data.Images, userv1.Image{UserUID: user.UID, Title: fmt.Sprintf("Photo %d", n), URL: key})
+		}
+	}
+
+	data.Swipes = swipes(r, mapping, data, opts.SwipesPerUser)
+	return
+}
+
+// genderChoices returns the identities of the mapping in a stable order with
+// their weights
+func genderChoices(mapping userv1.GenderMapping) (genders []userv1.Gender, weights []int) {
+	for gender := range mapping.Groups {
+		genders = append(genders, gender)
+	}
+	slices.Sort(genders)
+
+	weights = make([]int, len(genders))
+	for i, gender := range genders {
+		weights[i] = genderWeights[gender]
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+	}
+	return
+}
+
+// pick returns an index of weights chosen proportionally to its weight
+func pick(r *rand.Rand, weights []int) int {
+	var total int
+	for _, weight := range weights {
+		total += weight
+	}
+
+	n := r.Intn(total)
+	for i, weight := range weights {
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+	return len(weights) - 1
+}
+
+func firstName(r *rand.Rand, group userv1.GenderGroup) string {
+	switch group {
+	case userv1.GroupWomen:
+		return womenNames[r.Intn(len(womenNames))]
+	case userv1.GroupMen:
+		return menNames[r.Intn(len(menNames))]
+	default:
+		return neutralNames[r.Intn(len(neutralNames))]
+	}
+}
+
+// age is mostly in the twenties and early thirties with a tail up to 60
+func age(r *rand.Rand) int {
+	if r.Intn(10) == 0 {
+		return 35 + r.Intn(26)
+	}
+
+	a := 26 + int(math.Round(r.NormFloat64()*5))
+	return min(max(a, 18), 60)
+}
+
+func nearCity(r *rand.Rand) *userv1.Location {
+	weights := make([]int, len(cities))
+	for i, city := range cities {
+		weights[i] = city.weight
+	}
+	center := cities[pick(r, weights)].location
+
+	// about 10 km around the center
+	return &userv1.Location{
+		Latitude:  center.Latitude + r.NormFloat64()*0.09,
+		Longitude: center.Longitude + r.NormFloat64()*0.09,
+	}
+}
+
+func preferences(r *rand.Rand, mapping userv1.GenderMapping, group userv1.GenderGroup, age int) (p userv1.Preferences) {
+	switch n := r.Intn(100); {
+	case group == userv1.GroupMen && n < 88, group == userv1.GroupWomen && n >= 12:
+		p.InterestedIn = []userv1.GenderGroup{opposite(group)}
+	case group == userv1.GroupMen || group == userv1.GroupWomen:
+		if n%2 == 0 {
+			p.InterestedIn = []userv1.GenderGroup{group}
+		} else {
+			p.InterestedIn = []userv1.GenderGroup{userv1.GroupWomen, userv1.GroupMen}
+		}
+	default:
+		// everyone else is open to each group half of the time, in a stable
+		// order as the mapping lists them from a map
+		var groups []userv1.GenderGroup
+		for _, known := range mapping.GroupList() {
+			groups = append(groups, known.(userv1.GenderGroup))
+		}
+		slices.Sort(groups)
+		for _, group := range groups {
+			if r.Intn(2) == 0 {
+				p.InterestedIn = append(p.InterestedIn, group)
+			}
+		}
+	}
+
+	if r.Intn(10) < 7 {
+		minAge, maxAge := max(18, age-2-r.Intn(6)), age+2+r.Intn(10)
+		p.MinAge, p.MaxAge = &minAge, &maxAge
+	}
+	if r.Intn(10) < 6 {
+		distance := distancesKm[r.Intn(len(distancesKm))]
+		p.MaxDistanceKm = &distance
+	}
+	if len(p.InterestedIn) > 0 && r.Intn(5) == 0 {
+		p.Dealbreakers = append(p.Dealbreakers, userv1.PreferenceInterestedIn)
+	}
+	if p.MinAge != nil && r.Intn(10) == 0 {
+		p.Dealbreakers = append(p.Dealbreakers, userv1.PreferenceAge)
+	}
+
+	return
+}
+
+func opposite(group userv1.GenderGroup) userv1.GenderGroup {
+	if group == userv1.GroupMen {
+		return userv1.GroupWomen
+	}
+	return userv1.GroupMen
+}
+
+// swipes makes each user swipe on random other users, liking those in the
+// groups they are interested in far more often. Mutual likes are matched.
+func swipes(r *rand.Rand, mapping userv1.GenderMapping, data Dataset, perUser int) (result []matchv1.Swipe) {
+	n := len(data.Users)
+	perUser = min(perUser, n-1)
+	if perUser <= 0 {
+		return
+	}
+
+	interests := map[string][]userv1.GenderGroup{}
+	for _, p := range data.Preferences {
+		interests[p.UID] = p.InterestedIn
+	}
+
+	type pair struct{ from, to int }
+	index := map[pair]int{}
+	for from, user := range data.Users {
+		targets := map[int]bool{}
+		for len(targets) < perUser {
+			to := r.Intn(n)
+			if to == from || targets[to] {
+				continue
+			}
+			targets[to] = true
+
+			target := data.Users[to]
+			interested := interests[user.UID]
+			likeChance := 5
+			if len(interested) == 0 || slices.Contains(interested, mapping.GroupOf(target.Gender)) {
+				likeChance = 45
+			}
+
+			index[pair{from, to}] = len(result)
+			result = append(result, matchv1.Swipe{
+				UserUID:   user.UID,
+				TargetUID: target.UID,
+				Liked:     r.Intn(100) < likeChance,
+			})
+		}
+	}
+
+	for p, i := range index {
+		back, ok := index[pair{p.to, p.from}]
+		if ok && result[i].Liked && result[back].Liked {
+			result[i].Matched = true
+		}
+	}
+
+	return
+}
+
+// placeholder returns a small PNG of a single random color
+func placeholder(r *rand.Rand) []byte {
+	fill := color.RGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = fill.R, fill.G, fill.B, fill.A
+	}
+
+	var buf bytes.Buffer
+	// encoding an in-memory image to a buffer does not fail
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func randomUID(r *rand.Rand) string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = uidChars[r.Intn(len(uidChars))]
+	}
+	return string(b)
+}
@@ -0,0 +1,58 @@
+package seed_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/seed"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeed_Unit_Generate(t *testing.T) {
+	now := time.Date(2024, 5, 17, 15, 0, 0, 0, time.UTC)
+	opts := seed.Options{Users: 200, Seed: 7, SwipesPerUser: 20, Now: now}
+
+	data := seed.Generate(opts)
+	assert.Equal(t, data, seed.Generate(opts), "the same seed generates the same data")
+
+	other := opts
+	other.Seed = 8
+	assert.NotEqual(t, data.Users, seed.Generate(other).Users)
+
+	t.Run("Users are valid adults", func(t *testing.T) {
+		assert.Len(t, data.Users, 200)
+		adult := now.AddDate(-18, 0, 0)
+		uids := map[string]bool{}
+		for _, user := range data.Users {
+			assert.False(t, user.Birthdate.After(adult), "%s is under 18", user.Username)
+			assert.LessOrEqual(t, len(user.Username), userv1.MaxUsername)
+			assert.Contains(t, userv1.GetGenderMapping().GenderList(), user.Gender)
+			uids[user.UID] = true
+		}
+		assert.Len(t, uids, 200, "uids are unique")
+	})
+
+	t.Run("Photos have an image row", func(t *testing.T) {
+		assert.Len(t, data.Images, len(data.Photos))
+		for i, photo := range data.Photos {
+			assert.Equal(t, photo.Key, data.Images[i].URL)
+			assert.True(t, bytes.HasPrefix(photo.Data, []byte("\x89PNG")))
+		}
+	})
+
+	t.Run("Matches are mutual likes", func(t *testing.T) {
+		assert.Len(t, data.Swipes, 200*20)
+		liked := map[[2]string]bool{}
+		for _, swipe := range data.Swipes {
+			assert.NotEqual(t, swipe.UserUID, swipe.TargetUID)
+			liked[[2]string{swipe.UserUID, swipe.TargetUID}] = swipe.Liked
+		}
+		for _, swipe := range data.Swipes {
+			mutual := swipe.Liked && liked[[2]string{swipe.TargetUID, swipe.UserUID}]
+			assert.Equal(t, mutual, swipe.Matched)
+		}
+		assert.Positive(t, data.Matches())
+	})
+}
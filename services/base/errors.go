@@ -0,0 +1,205 @@
+package servicebase
+
+//go:generate go run ../../cmd/errdoc -o ../../docs/errors.md
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/response"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/rs/zerolog"
+)
+
+// AppError is an error with a stable code clients can rely on. Codes look like
+// USR-404-001: the service prefix, the HTTP status and a sequence number.
+type AppError struct {
+	Status     int
+	Code       string
+	MessageKey string
+	Cause      error
+}
+
+var (
+	appErrorsMu sync.Mutex
+	appErrors   = map[string]*AppError{}
+)
+
+// NewAppError creates and registers an error for the error catalog, codes
+// must be unique
+func NewAppError(status int, code, messageKey string) *AppError {
+	appErrorsMu.Lock()
+	defer appErrorsMu.Unlock()
+
+	if _, ok := appErrors[code]; ok {
+		panic(fmt.Sprintf("duplicate error code %s", code))
+	}
+
+	err := &AppError{Status: status, Code: code, MessageKey: messageKey}
+	appErrors[code] = err
+
+	return err
+}
+
+// AppErrors returns every registered error sorted by code
+func AppErrors() []*AppError {
+	appErrorsMu.Lock()
+	defer appErrorsMu.Unlock()
+
+	result := make([]*AppError, 0, len(appErrors))
+	for _, err := range appErrors {
+		result = append(result, err)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Code < result[j].Code
+	})
+
+	return result
+}
+
+func (e *AppError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", i18n.DefaultText(e.MessageKey), e.Cause)
+	}
+
+	return i18n.DefaultText(e.MessageKey)
+}
+
+func (e *AppError) MessageID() string {
+	return e.MessageKey
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches errors by code, so errors.Is still holds after WithCause
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Code == e.Code
+}
+
+// WithCause returns a copy of the error wrapping cause
+func (e *AppError) WithCause(cause error) *AppError {
+	err := *e
+	err.Cause = cause
+	return &err
+}
+
+var (
+	ErrFailedDecodeJSON = NewAppError(http.StatusBadRequest, "BE-400-001", MessageFailedDecodeJSON)
+	ErrValidationFailed = NewAppError(http.StatusBadRequest, "BE-400-002", MessageValidationFailed)
+	ErrTooManyRequests  = NewAppError(http.StatusTooManyRequests, "BE-429-001", MessageTooManyRequests)
+	ErrInternal         = NewAppError(http.StatusInternalServerError, "BE-500-001", MessageInternalError)
+)
+
+// WriteError writes the response body of err. An AppError uses its own status
+// and code, validation errors list their fields under ErrValidationFailed and
+// anything else is reported as ErrInternal. Clients accepting
+// application/problem+json get RFC 7807 problem details instead of the
+// ResponseBody envelope.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	localizer := i18n.FromContext(r.Context())
+
+	var appErr *AppError
+	var fieldErrs validation.Errors
+	var errs []FieldError
+	switch {
+	case errors.As(err, &appErr):
+	case errors.As(err, &fieldErrs):
+		appErr = ErrValidationFailed
+		errs = NewFieldErrors(localizer, fieldErrs)
+	default:
+		zerolog.Ctx(r.Context()).Error().Msgf("unmapped error: %v", err)
+		appErr = ErrInternal
+	}
+
+	message := localizer.T(appErr.MessageKey)
+	if response.WantsProblem(r) {
+		detail := message
+		if len(errs) > 0 {
+			detail = localizer.Error(fieldErrs)
+		}
+		err = response.ProblemJSON(w, appErr.Status, Problem{
+			Type:     ProblemType(appErr.Code),
+			Title:    message,
+			Status:   appErr.Status,
+			Detail:   detail,
+			Instance: r.URL.Path,
+			Code:     appErr.Code,
+			Errors:   errs,
+		})
+	} else {
+		err = response.JSON(w, appErr.Status, ResponseBody{
+			Code:    appErr.Code,
+			Message: message,
+			Errors:  errs,
+		})
+	}
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+// WriteErrorCatalog writes the markdown table of every registered error, the
+// source of docs/errors.md
+func WriteErrorCatalog(w io.Writer, bundle *i18n.Bundle) error {
+	langs := bundle.Languages()
+
+	var sb strings.Builder
+	sb.WriteString("# Error catalog\n\n")
+	sb.WriteString("<!-- Code generated by cmd/errdoc. DO NOT EDIT. -->\n\n")
+	sb.WriteString("Every error response carries one of these codes in `code`. Successful responses use `" + CodeSuccess + "`.\n")
+	sb.WriteString("The `type` of problem details responses links to the row of its code.\n\n")
+	sb.WriteString("| Code | HTTP status | Message key |")
+	for _, lang := range langs {
+		sb.WriteString(" Message (" + lang + ") |")
+	}
+	sb.WriteString("\n|---|---|---|")
+	for range langs {
+		sb.WriteString("---|")
+	}
+	sb.WriteString("\n")
+
+	for _, appErr := range AppErrors() {
+		fmt.Fprintf(&sb, "| <a id=\"%s\"></a>`%s` | %d %s | `%s` |", problemAnchor(appErr.Code), appErr.Code, appErr.Status, http.StatusText(appErr.Status), appErr.MessageKey)
+		for _, lang := range langs {
+			sb.WriteString(" " + bundle.Localizer(lang).T(appErr.MessageKey) + " |")
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ProblemTypeBase is the document problem types point into, one anchor per
+// error code
+var ProblemTypeBase = "https://github.com/farolinar/dealls-bumble/blob/main/docs/errors.md"
+
+// Problem is an RFC 7807 problem details body, Code and Errors are extension
+// members
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// ProblemType returns the problem type URI of an error code
+func ProblemType(code string) string {
+	return ProblemTypeBase + "#" + problemAnchor(code)
+}
+
+func problemAnchor(code string) string {
+	return strings.ToLower(code)
+}
@@ -1,21 +1,25 @@
 package servicebase
 
-var (
-	ID_LANG = "id"
-)
+import (
+	"embed"
 
-var (
-	MessageSuccess          = "Success"
-	MessageInternalError    = "Internal server error"
-	MessageFailedDecodeJSON = "Failed to decode JSON"
-	MessagePasswordInvalid  = "Minimum eight characters, at least one uppercase letter, one lowercase letter, one number, and one special character"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
 )
 
-func Translate(lang string) {
-	switch lang {
-	case ID_LANG:
-		MessageSuccess = "Sukses"
-		MessageInternalError = "Terjadi kegagalan pada server"
-		MessageFailedDecodeJSON = "Minimum 8 karakter, satu huruf kapital, satu huruf kecil, satu angka, dan satu karakter spesial"
-	}
+//go:embed locales/*.json
+var locales embed.FS
+
+func init() {
+	i18n.MustLoadFS(locales, "locales")
 }
+
+// Message IDs, the text of each language lives in locales/<lang>.json
+var (
+	MessageSuccess          = "base.success"
+	MessageInternalError    = "base.internal_error"
+	MessageFailedDecodeJSON = "base.failed_decode_json"
+	MessagePasswordInvalid  = "base.password_invalid"
+	MessageMustAbove18      = "base.must_above_18"
+	MessageValidationFailed = "base.validation_failed"
+	MessageTooManyRequests  = "base.too_many_requests"
+)
@@ -4,6 +4,7 @@ import (
 	"time"
 	"unicode"
 
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
@@ -12,45 +13,61 @@ var (
 	MinAge             = 18
 )
 
-var PasswordValidationRule = validation.NewStringRule(func(s string) bool {
-	if len(s) < 8 {
+// PasswordValidationRule checks the password policy of the current runtime
+// settings, so a reloaded policy applies to the next request
+var PasswordValidationRule = validation.NewStringRuleWithError(func(s string) bool {
+	policy := settings.Default().Get().Password
+	if len(s) < policy.MinLength {
 		return false
 	}
 
-	var hasUpper, hasLower, hasSpecial bool
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
 	for _, char := range s {
 		switch {
 		case unicode.IsUpper(char):
 			hasUpper = true
 		case unicode.IsLower(char):
 			hasLower = true
+		case unicode.IsDigit(char):
+			hasDigit = true
 		case unicode.IsPunct(char) || unicode.IsSymbol(char):
 			hasSpecial = true
 		}
 	}
 
-	return hasUpper && hasLower && hasSpecial
-}, MessagePasswordInvalid)
+	return (hasUpper || !policy.RequireUpper) &&
+		(hasLower || !policy.RequireLower) &&
+		(hasDigit || !policy.RequireDigit) &&
+		(hasSpecial || !policy.RequireSpecial)
+}, validation.NewError(MessagePasswordInvalid, MessagePasswordInvalid))
 
-var MustAbove18Rule = func(birthdate, layout string) bool {
-	birthdateTime, err := time.Parse(layout, birthdate)
-	if err != nil {
-		return false
-	}
+// MustAbove18Rule validates a birthdate formatted with layout is at least
+// MinAge years ago, empty values are left to validation.Required
+var MustAbove18Rule = func(layout string) validation.Rule {
+	return validation.NewStringRuleWithError(func(birthdate string) bool {
+		birthdateTime, err := time.Parse(layout, birthdate)
+		if err != nil {
+			return false
+		}
 
-	// Calculate the age.
-	age := CalculateAge(birthdateTime)
+		// Calculate the age.
+		age := CalculateAge(birthdateTime)
 
-	// Check if age is at least 18.
-	return age >= MinAge
+		// Check if age is at least 18.
+		return age >= MinAge
+	}, validation.NewError(MessageMustAbove18, MessageMustAbove18))
 }
 
 var CalculateAge = func(birthdate time.Time) int {
-	now := time.Now()
+	return AgeAt(birthdate, time.Now())
+}
+
+// AgeAt returns the age in full years at now of someone born on birthdate
+func AgeAt(birthdate, now time.Time) int {
 	age := now.Year() - birthdate.Year()
 
 	// Adjust age if the birthday hasn't occurred yet this year.
-	if now.YearDay() < birthdate.YearDay() {
+	if now.Month() < birthdate.Month() || (now.Month() == birthdate.Month() && now.Day() < birthdate.Day()) {
 		age--
 	}
 
@@ -1,5 +1,13 @@
 package servicebase
 
+import (
+	"errors"
+	"sort"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
 type Pagination struct {
 	Limit      int `json:"limit"`
 	Page       int `json:"page"`
@@ -8,13 +16,90 @@ type Pagination struct {
 }
 
 type ResponseBody struct {
-	Code       string      `json:"code"`
-	Message    string      `json:"message"`
-	Pagination *Pagination `json:"pagination,omitempty"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Errors     []FieldError `json:"errors,omitempty"`
+	Pagination *Pagination  `json:"pagination,omitempty"`
 }
 
-var (
-	CodeSuccess = "BE-000"
-	Code4XX     = "BE-4XX"
-	Code5XX     = "BE-5XX"
-)
+// FieldError is a single failed validation rule of a request field
+type FieldError struct {
+	// Field is the json name of the field, nested fields and slice items are
+	// joined with a dot, e.g. interested_in.0
+	Field string `json:"field"`
+	// Code is the stable rule code, e.g. validation_required
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CodeSuccess is the code of every successful response, error codes are
+// defined with NewAppError
+var CodeSuccess = "BE-000"
+
+// CodeValidationInvalid is used for field errors not created by a rule
+var CodeValidationInvalid = "validation_invalid"
+
+func NewPagination(page, limit, records int) Pagination {
+	totalPages := 0
+	if limit > 0 {
+		totalPages = (records + limit - 1) / limit
+	}
+
+	return Pagination{
+		Limit:      limit,
+		Page:       page,
+		TotalPages: totalPages,
+		Records:    records,
+	}
+}
+
+// NewFieldErrors flattens the validation errors of err into field errors with
+// localized messages, sorted by field. It returns nil when err does not come
+// from ozzo validation.
+func NewFieldErrors(localizer *i18n.Localizer, err error) []FieldError {
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) {
+		return nil
+	}
+
+	result := appendFieldErrors(nil, localizer, "", fieldErrs)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Field < result[j].Field
+	})
+
+	return result
+}
+
+func appendFieldErrors(result []FieldError, localizer *i18n.Localizer, prefix string, fieldErrs validation.Errors) []FieldError {
+	for field, err := range fieldErrs {
+		if err == nil {
+			continue
+		}
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+
+		var nested validation.Errors
+		if errors.As(err, &nested) {
+			result = appendFieldErrors(result, localizer, field, nested)
+			continue
+		}
+
+		code := CodeValidationInvalid
+		var ruleErr validation.Error
+		var messageErr i18n.MessageError
+		if errors.As(err, &ruleErr) {
+			code = ruleErr.Code()
+		} else if errors.As(err, &messageErr) {
+			code = messageErr.MessageID()
+		}
+
+		result = append(result, FieldError{
+			Field:   field,
+			Code:    code,
+			Message: localizer.Error(err),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,279 @@
+package servicebase_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	matchv1 "github.com/farolinar/dealls-bumble/services/v1/match"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	_ "github.com/farolinar/dealls-bumble/services/v1/webhook"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// every catalog must translate every message of the fallback catalog, so a
+// new language only needs its <lang>.json files next to the existing ones
+func TestBase_Unit_CatalogCoverage(t *testing.T) {
+	bundle := i18n.Default()
+
+	langs := bundle.Languages()
+	assert.Contains(t, langs, i18n.LangEN)
+	assert.Contains(t, langs, i18n.LangID)
+	for _, lang := range langs {
+		t.Run(lang, func(t *testing.T) {
+			assert.Empty(t, bundle.Missing(lang), "messages missing from the %s catalog", lang)
+		})
+	}
+}
+
+func TestBase_Unit_FieldErrors(t *testing.T) {
+	minAge, maxAge := 30, 25
+	payload := userv1.UserPreferencesPayload{
+		InterestedIn: []userv1.GenderGroup{userv1.GroupWomen, "aliens"},
+		MinAge:       &minAge,
+		MaxAge:       &maxAge,
+	}
+	err := validation.Errors{
+		"password":    validation.Validate("password", servicebase.PasswordValidationRule),
+		"birthdate":   validation.Validate("2020-01-02", servicebase.MustAbove18Rule("2006-01-02")),
+		"preferences": payload.Validate(),
+	}.Filter()
+
+	tests := []struct {
+		name   string
+		lang   string
+		errors []servicebase.FieldError
+	}{
+		{
+			name: "English",
+			lang: i18n.LangEN,
+			errors: []servicebase.FieldError{
+				{Field: "birthdate", Code: servicebase.MessageMustAbove18, Message: "Age must above 18"},
+				{Field: "password", Code: servicebase.MessagePasswordInvalid, Message: "Minimum eight characters, at least one uppercase letter, one lowercase letter, one number, and one special character"},
+				{Field: "preferences.interested_in.1", Code: "validation_in_invalid", Message: "must be a valid value"},
+				{Field: "preferences.max_age", Code: userv1.MessageMaxAgeBelowMinAge, Message: "Max age must not be below min age"},
+			},
+		},
+		{
+			name: "Indonesian",
+			lang: i18n.LangID,
+			errors: []servicebase.FieldError{
+				{Field: "birthdate", Code: servicebase.MessageMustAbove18, Message: "Umur harus di atas 18 tahun"},
+				{Field: "password", Code: servicebase.MessagePasswordInvalid, Message: "Minimum 8 karakter, satu huruf kapital, satu huruf kecil, satu angka, dan satu karakter spesial"},
+				{Field: "preferences.interested_in.1", Code: "validation_in_invalid", Message: "harus berupa nilai yang valid"},
+				{Field: "preferences.max_age", Code: userv1.MessageMaxAgeBelowMinAge, Message: "Umur maksimal tidak boleh di bawah umur minimal"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			localizer := i18n.Default().Localizer(tt.lang)
+			assert.Equal(t, tt.errors, servicebase.NewFieldErrors(localizer, err))
+		})
+	}
+
+	// rule parameters are rendered into the translated template
+	lengthErr := validation.Errors{"name": validation.Validate("a", validation.Length(3, 50))}
+	assert.Equal(t, []servicebase.FieldError{
+		{Field: "name", Code: "validation_length_out_of_range", Message: "panjang harus di antara 3 dan 50"},
+	}, servicebase.NewFieldErrors(i18n.Default().Localizer(i18n.LangID), lengthErr))
+	assert.Nil(t, servicebase.NewFieldErrors(i18n.Default().Localizer(i18n.LangEN), userv1.ErrNotFound))
+}
+
+func TestBase_Unit_WriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		httpStatus int
+		code       string
+		message    string
+		errors     int
+	}{
+		{
+			name:       "AppError uses its status and code",
+			err:        userv1.ErrNotFound,
+			httpStatus: http.StatusNotFound,
+			code:       "USR-404-001",
+			message:    "User tidak ditemukan",
+		},
+		{
+			name:       "Wrapped AppError keeps its code",
+			err:        fmt.Errorf("swiping: %w", matchv1.ErrSwipeSelf.WithCause(errors.New("same uid"))),
+			httpStatus: http.StatusBadRequest,
+			code:       "MCH-400-001",
+			message:    "Tidak dapat swipe diri sendiri",
+		},
+		{
+			name:       "Perk errors are forbidden",
+			err:        premiumv1.ErrPerkRequired,
+			httpStatus: http.StatusForbidden,
+			code:       "PRM-403-001",
+			message:    "Membutuhkan perk premium",
+		},
+		{
+			name:       "Validation errors list their fields",
+			err:        validation.Errors{"name": validation.ErrRequired, "email": validation.ErrRequired},
+			httpStatus: http.StatusBadRequest,
+			code:       servicebase.ErrValidationFailed.Code,
+			message:    "Validasi gagal",
+			errors:     2,
+		},
+		{
+			name:       "Unknown errors are internal errors",
+			err:        errors.New("connection refused"),
+			httpStatus: http.StatusInternalServerError,
+			code:       servicebase.ErrInternal.Code,
+			message:    "Terjadi kegagalan pada server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/user/profile", nil)
+			req = req.WithContext(i18n.NewContext(req.Context(), i18n.Default().Localizer(i18n.LangID)))
+			requestRecorder := httptest.NewRecorder()
+			servicebase.WriteError(requestRecorder, req, tt.err)
+
+			var resp servicebase.ResponseBody
+			err := json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding response body: %v", err)
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.Equal(t, tt.message, resp.Message)
+			assert.Len(t, resp.Errors, tt.errors)
+		})
+	}
+
+	assert.ErrorIs(t, matchv1.ErrSwipeSelf.WithCause(errors.New("same uid")), matchv1.ErrSwipeSelf)
+	assert.NotErrorIs(t, userv1.ErrUsernameNotFound, userv1.ErrNotFound)
+}
+
+func TestBase_Unit_WriteProblem(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		err         error
+		contentType string
+		problem     servicebase.Problem
+	}{
+		{
+			name:        "Envelope stays the default",
+			accept:      "application/json",
+			err:         userv1.ErrNotFound,
+			contentType: "application/json",
+		},
+		{
+			name:        "Problem with q-value zero is refused",
+			accept:      "application/problem+json;q=0, application/json",
+			err:         userv1.ErrNotFound,
+			contentType: "application/json",
+		},
+		{
+			name:        "AppError as problem details",
+			accept:      "application/problem+json, application/json;q=0.5",
+			err:         userv1.ErrNotFound,
+			contentType: "application/problem+json",
+			problem: servicebase.Problem{
+				Type:     servicebase.ProblemTypeBase + "#usr-404-001",
+				Title:    "User not found",
+				Status:   http.StatusNotFound,
+				Detail:   "User not found",
+				Instance: "/v1/user/profile",
+				Code:     "USR-404-001",
+			},
+		},
+		{
+			name:        "Validation errors as problem details",
+			accept:      "application/problem+json",
+			err:         validation.Errors{"name": validation.ErrRequired},
+			contentType: "application/problem+json",
+			problem: servicebase.Problem{
+				Type:     servicebase.ProblemTypeBase + "#be-400-002",
+				Title:    "Validation failed",
+				Status:   http.StatusBadRequest,
+				Detail:   "name: cannot be blank.",
+				Instance: "/v1/user/profile",
+				Code:     "BE-400-002",
+				Errors: []servicebase.FieldError{
+					{Field: "name", Code: "validation_required", Message: "cannot be blank"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/user/profile?page=1", nil)
+			req.Header.Set("Accept", tt.accept)
+			requestRecorder := httptest.NewRecorder()
+			servicebase.WriteError(requestRecorder, req, tt.err)
+
+			assert.Equal(t, tt.contentType, requestRecorder.Header().Get("Content-Type"))
+			if tt.contentType != "application/problem+json" {
+				var resp servicebase.ResponseBody
+				assert.NoError(t, json.NewDecoder(requestRecorder.Body).Decode(&resp))
+				assert.NotEmpty(t, resp.Code)
+				return
+			}
+
+			var problem servicebase.Problem
+			assert.NoError(t, json.NewDecoder(requestRecorder.Body).Decode(&problem))
+			assert.Equal(t, tt.problem, problem)
+		})
+	}
+}
+
+func TestBase_Unit_PanicProblem(t *testing.T) {
+	handler := middleware.PanicRecoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/problem+json")
+	requestRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(requestRecorder, req)
+
+	var problem servicebase.Problem
+	assert.NoError(t, json.NewDecoder(requestRecorder.Body).Decode(&problem))
+	assert.Equal(t, http.StatusInternalServerError, requestRecorder.Code)
+	assert.Equal(t, "application/problem+json", requestRecorder.Header().Get("Content-Type"))
+	assert.Equal(t, servicebase.ErrInternal.Code, problem.Code)
+	assert.Equal(t, http.StatusInternalServerError, problem.Status)
+}
+
+// the password rule reads the policy of the runtime settings on every use
+func TestBase_Unit_PasswordPolicy(t *testing.T) {
+	original := settings.Default().Get()
+	defer settings.Default().Update(original)
+
+	assert.Error(t, validation.Validate("Password1", servicebase.PasswordValidationRule))
+
+	relaxed := original
+	relaxed.Password = settings.PasswordPolicy{MinLength: 8, RequireDigit: true}
+	assert.NoError(t, settings.Default().Update(relaxed))
+	assert.NoError(t, validation.Validate("password1", servicebase.PasswordValidationRule))
+	assert.Error(t, validation.Validate("password", servicebase.PasswordValidationRule))
+}
+
+// docs/errors.md is generated, run go generate ./services/base/ after adding
+// or changing an error
+func TestBase_Unit_ErrorCatalog(t *testing.T) {
+	var generated bytes.Buffer
+	err := servicebase.WriteErrorCatalog(&generated, i18n.Default())
+	assert.NoError(t, err)
+
+	current, err := os.ReadFile("../../docs/errors.md")
+	assert.NoError(t, err)
+	assert.Equal(t, generated.String(), string(current), "docs/errors.md is outdated, run go generate ./services/base/")
+}
@@ -0,0 +1,66 @@
+package matchv1
+
+import (
+	"time"
+
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+)
+
+// Like is a pending incoming like, a user who swiped right on the current
+// user and has not been swiped back yet.
+type Like struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+	// Gender is nil when the user chose not to show it
+	Gender    *userv1.Gender `json:"gender,omitempty"`
+	Birthdate time.Time      `json:"birthdate"`
+	LikedAt   time.Time      `json:"liked_at"`
+}
+
+type LikesCount struct {
+	Count int `json:"count"`
+	// Blurred tells the client to hide who the likes are from, it is set
+	// when the user does not hold the see_likes perk.
+	Blurred bool `json:"blurred"`
+}
+
+// Candidate is a user shown in the feed
+type Candidate struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+	// Gender is nil when the user chose not to show it
+	Gender *userv1.Gender `json:"gender,omitempty"`
+	Age    int            `json:"age"`
+	// Visiting is set when the user is in travel mode
+	Visiting bool `json:"visiting"`
+	// DistanceKm is rounded and fuzzed, nil when either user has no location
+	DistanceKm *int `json:"distance_km,omitempty"`
+}
+
+// FeedRow is a feed entry as read from the database, before the distance is
+// made approximate
+type FeedRow struct {
+	UID            string
+	Name           string
+	Gender         *userv1.Gender
+	Birthdate      time.Time
+	Visiting       bool
+	DistanceMeters *float64
+}
+
+type SwipeResult struct {
+	// Matched is set when the swipe completed a mutual like
+	Matched bool `json:"matched"`
+	// FirstMessageBy is the UID of the user who has to send the first
+	// message in the match, empty when either of them may
+	FirstMessageBy string `json:"first_message_by,omitempty"`
+}
+
+// Swipe is a swipe as stored, used to write many at once. Matched is set on
+// both swipes of a mutual like.
+type Swipe struct {
+	UserUID   string
+	TargetUID string
+	Liked     bool
+	Matched   bool
+}
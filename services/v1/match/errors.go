@@ -0,0 +1,14 @@
+package matchv1
+
+import (
+	"net/http"
+
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+)
+
+var (
+	ErrSwipeSelf          = servicebase.NewAppError(http.StatusBadRequest, "MCH-400-001", MessageSwipeSelf)
+	ErrAlreadySwiped      = servicebase.NewAppError(http.StatusBadRequest, "MCH-400-002", MessageAlreadySwiped)
+	ErrPreferenceMismatch = servicebase.NewAppError(http.StatusBadRequest, "MCH-400-003", MessagePreferenceMismatch)
+	ErrSwipeQuotaExceeded = servicebase.NewAppError(http.StatusTooManyRequests, "MCH-429-001", MessageSwipeQuotaExceeded)
+)
@@ -0,0 +1,154 @@
+package matchv1
+
+import (
+	"net/http"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/request"
+	"github.com/farolinar/dealls-bumble/internal/common/response"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	cfg     config.AppConfig
+	service Service
+}
+
+func NewHandler(cfg config.AppConfig, service Service) *Handler {
+	return &Handler{cfg: cfg, service: service}
+}
+
+func (h *Handler) CountLikes(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp LikesCountResponse
+
+	userUID, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	count, err := h.service.CountLikes(r.Context(), userUID)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &count
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) ListLikes(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp LikesResponse
+
+	userUID, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := NewPageQuery(r)
+	err := query.Validate()
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	likes, pagination, err := h.service.ListLikes(r.Context(), userUID, query)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Pagination = &pagination
+	resp.Data = likes
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) Feed(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp FeedResponse
+
+	userUID, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := NewPageQuery(r)
+	err := query.Validate()
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	candidates, err := h.service.Feed(r.Context(), userUID, query)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = candidates
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) Swipe(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var payload SwipePayload
+	var resp SwipeResponse
+
+	userUID, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err := request.DecodeJSON(w, r, &payload)
+	if err != nil {
+		servicebase.WriteError(w, r, servicebase.ErrFailedDecodeJSON.WithCause(err))
+		return
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	result, err := h.service.Swipe(r.Context(), userUID, payload)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &result
+	err = response.JSON(w, http.StatusCreated, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package matchv1
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	dbtest "github.com/farolinar/dealls-bumble/internal/common/db/test"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	_ "github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// integration testing for the feed distance filter
+func TestMatch_Integration_FeedMaxDistance(t *testing.T) {
+	ctx := context.Background()
+	cfg := getConfig()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	userRepo := userv1.NewRepository(db)
+	matchService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userRepo, premiumv1.NewRepository(db))
+
+	// jakarta, a user about 5km away and one in bandung
+	viewer := injectUser(t, userRepo, "viewer", &userv1.Location{Latitude: -6.2000, Longitude: 106.8166})
+	near := injectUser(t, userRepo, "near", &userv1.Location{Latitude: -6.2450, Longitude: 106.8166})
+	far := injectUser(t, userRepo, "far", &userv1.Location{Latitude: -6.9175, Longitude: 107.6191})
+	unknown := injectUser(t, userRepo, "unknown", nil)
+
+	candidates, err := matchService.Feed(ctx, viewer, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{near, far, unknown}, candidateUIDs(candidates))
+
+	maxDistance := 10
+	err = userRepo.UpsertPreferences(ctx, viewer, userv1.Preferences{MaxDistanceKm: &maxDistance})
+	assert.NoError(t, err)
+
+	candidates, err = matchService.Feed(ctx, viewer, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{near}, candidateUIDs(candidates))
+	if assert.NotNil(t, candidates[0].DistanceKm) {
+		assert.InDelta(t, 5, *candidates[0].DistanceKm, 1)
+	}
+
+	// travelling to bandung swaps which users are in range
+	err = userRepo.SetTravel(ctx, viewer, userv1.Travel{
+		Location:  userv1.Location{Latitude: -6.9147, Longitude: 107.6098},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+
+	candidates, err = matchService.Feed(ctx, viewer, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{far}, candidateUIDs(candidates))
+
+	// the viewer shows up as visiting to users in bandung
+	candidates, err = matchService.Feed(ctx, far, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, candidates) {
+		assert.Equal(t, viewer, candidates[0].UID)
+		assert.True(t, candidates[0].Visiting)
+	}
+}
+
+// integration testing for pending likes
+func TestMatch_Integration_PendingLikes(t *testing.T) {
+	ctx := context.Background()
+	cfg := getConfig()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	userRepo := userv1.NewRepository(db)
+	matchService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userRepo, premiumv1.NewRepository(db))
+
+	me := injectUser(t, userRepo, "me", nil)
+	pending := injectUser(t, userRepo, "pending", nil)
+	swipedBack := injectUser(t, userRepo, "swipedback", nil)
+	injectSwipe(t, db, pending, me)
+	injectSwipe(t, db, swipedBack, me)
+	injectSwipe(t, db, me, swipedBack)
+
+	count, err := matchService.CountLikes(ctx, me)
+	assert.NoError(t, err)
+	assert.Equal(t, LikesCount{Count: 1, Blurred: true}, count)
+
+	_, _, err = matchService.ListLikes(ctx, me, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.ErrorIs(t, err, premiumv1.ErrPerkRequired)
+
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO dealls_bumble.premium_packages (id, title, perks_codes) VALUES (1, 'Gold', '{see_likes}');
+    `)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `UPDATE dealls_bumble.users SET premium_package_id = 1 WHERE uid = $1;`, me)
+	assert.NoError(t, err)
+
+	likes, pagination, err := matchService.ListLikes(ctx, me, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pagination.Records)
+	if assert.Len(t, likes, 1) {
+		assert.Equal(t, pending, likes[0].UID)
+	}
+}
+
+// integration testing for preferences and dealbreakers in both directions
+func TestMatch_Integration_FeedPreferences(t *testing.T) {
+	ctx := context.Background()
+	cfg := getConfig()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	userRepo := userv1.NewRepository(db)
+	matchService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userRepo, premiumv1.NewRepository(db))
+
+	viewer := injectUser(t, userRepo, "viewer", nil)
+	choosy := injectUser(t, userRepo, "choosy", nil)
+	open := injectUser(t, userRepo, "open", nil)
+
+	// choosy only wants men and makes it a dealbreaker, the viewer is a
+	// woman so choosy is hidden from the viewer and cannot be liked
+	err = userRepo.UpsertPreferences(ctx, choosy, userv1.Preferences{
+		InterestedIn: []userv1.GenderGroup{userv1.GroupMen},
+		Dealbreakers: []userv1.PreferenceKey{userv1.PreferenceInterestedIn},
+	})
+	assert.NoError(t, err)
+
+	candidates, err := matchService.Feed(ctx, viewer, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{open}, candidateUIDs(candidates))
+
+	liked := true
+	_, err = matchService.Swipe(ctx, viewer, SwipePayload{UID: choosy, Liked: &liked})
+	assert.ErrorIs(t, err, ErrPreferenceMismatch)
+
+	// the viewer only wants men, nobody is left
+	err = userRepo.UpsertPreferences(ctx, viewer, userv1.Preferences{InterestedIn: []userv1.GenderGroup{userv1.GroupMen}})
+	assert.NoError(t, err)
+
+	candidates, err = matchService.Feed(ctx, viewer, PageQuery{Page: 1, Limit: DefaultLimit})
+	assert.NoError(t, err)
+	assert.Empty(t, candidates)
+
+	// users missing from the feed cannot be liked either
+	_, err = matchService.Swipe(ctx, viewer, SwipePayload{UID: open, Liked: &liked})
+	assert.ErrorIs(t, err, ErrPreferenceMismatch)
+
+	// the preferences of the target are not dealbreakers, a like back matches
+	err = userRepo.UpsertPreferences(ctx, viewer, userv1.Preferences{})
+	assert.NoError(t, err)
+	err = userRepo.UpsertPreferences(ctx, open, userv1.Preferences{InterestedIn: []userv1.GenderGroup{userv1.GroupMen}})
+	assert.NoError(t, err)
+
+	result, err := matchService.Swipe(ctx, viewer, SwipePayload{UID: open, Liked: &liked})
+	assert.NoError(t, err)
+	assert.False(t, result.Matched)
+
+	err = userRepo.UpsertPreferences(ctx, open, userv1.Preferences{})
+	assert.NoError(t, err)
+
+	result, err = matchService.Swipe(ctx, open, SwipePayload{UID: viewer, Liked: &liked})
+	assert.NoError(t, err)
+	assert.True(t, result.Matched)
+
+	_, err = matchService.Swipe(ctx, open, SwipePayload{UID: viewer, Liked: &liked})
+	assert.ErrorIs(t, err, ErrAlreadySwiped)
+}
+
+// integration testing for swipes made at the same time
+func TestMatch_Integration_ConcurrentSwipes(t *testing.T) {
+	ctx := context.Background()
+	cfg := getConfig()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	current := settings.Defaults()
+	current.SwipeDailyLimit = 3
+	store := settings.NewStore(current)
+	userRepo := userv1.NewRepository(db)
+	matchService := NewService(cfg, clock.System(), store, txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userRepo, premiumv1.NewRepository(db))
+
+	liked := true
+	swipe := func(from, to string) (result SwipeResult, err error) {
+		return matchService.Swipe(ctx, from, SwipePayload{UID: to, Liked: &liked})
+	}
+
+	t.Run("Crossing likes match", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			first := injectUser(t, userRepo, fmt.Sprintf("first%d", i), nil)
+			second := injectUser(t, userRepo, fmt.Sprintf("second%d", i), nil)
+
+			var wg sync.WaitGroup
+			results := make([]SwipeResult, 2)
+			errs := make([]error, 2)
+			for j, pair := range [][2]string{{first, second}, {second, first}} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results[j], errs[j] = swipe(pair[0], pair[1])
+				}()
+			}
+			wg.Wait()
+
+			assert.NoError(t, errs[0])
+			assert.NoError(t, errs[1])
+			assert.True(t, results[0].Matched != results[1].Matched, "exactly one like matches")
+		}
+	})
+
+	t.Run("The daily limit holds", func(t *testing.T) {
+		swiper := injectUser(t, userRepo, "swiper", nil)
+		targets := make([]string, 6)
+		for i := range targets {
+			targets[i] = injectUser(t, userRepo, fmt.Sprintf("target%d", i), nil)
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(targets))
+		for i, target := range targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = swipe(swiper, target)
+			}()
+		}
+		wg.Wait()
+
+		exceeded := 0
+		for _, err := range errs {
+			if err != nil {
+				assert.ErrorIs(t, err, ErrSwipeQuotaExceeded)
+				exceeded++
+			}
+		}
+		assert.Equal(t, 3, exceeded)
+	})
+}
+
+func injectUser(t *testing.T, repo userv1.Repository, username string, location *userv1.Location) string {
+	ctx := context.Background()
+	hashedPassword := "hashed"
+	user := &userv1.User{
+		UID:            (username + "0000000000000000")[:16],
+		Name:           username,
+		Email:          username + "@email.com",
+		Username:       username,
+		HashedPassword: &hashedPassword,
+		Gender:         userv1.GenderWoman,
+		ShowGender:     true,
+	}
+	err := repo.Create(ctx, user)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+	if location != nil {
+		err = repo.UpdateLocation(ctx, user.UID, *location)
+		if err != nil {
+			t.Fatalf("error updating location: %v", err)
+		}
+	}
+
+	return user.UID
+}
+
+func injectSwipe(t *testing.T, db *sql.DB, fromUID, toUID string) {
+	_, err := db.ExecContext(context.Background(), `
+        INSERT INTO dealls_bumble.user_matches (user_id, match_id)
+        SELECT f.id, t.id FROM dealls_bumble.users f, dealls_bumble.users t
+        WHERE f.uid = $1 AND t.uid = $2;
+    `, fromUID, toUID)
+	if err != nil {
+		t.Fatalf("error creating swipe: %v", err)
+	}
+}
+
+func candidateUIDs(candidates []Candidate) []string {
+	uids := []string{}
+	for _, candidate := range candidates {
+		uids = append(uids, candidate.UID)
+	}
+
+	return uids
+}
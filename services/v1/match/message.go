@@ -0,0 +1,22 @@
+package matchv1
+
+import (
+	"embed"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+func init() {
+	i18n.MustLoadFS(locales, "locales")
+}
+
+// Message IDs, the text of each language lives in locales/<lang>.json
+var (
+	MessageSwipeSelf          = "match.swipe_self"
+	MessageAlreadySwiped      = "match.already_swiped"
+	MessagePreferenceMismatch = "match.preference_mismatch"
+	MessageSwipeQuotaExceeded = "match.swipe_quota_exceeded"
+)
@@ -0,0 +1,30 @@
+package matchv1
+
+import (
+	"net/http"
+
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/container"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/gorilla/mux"
+)
+
+// Module registers the match routes under /v1/match
+type Module struct{}
+
+func (Module) Name() string {
+	return "match"
+}
+
+func (Module) Register(app *container.App, r *mux.Router) {
+	cfg := app.Config
+	service := NewService(cfg, app.Clock, app.Settings, app.Tx, app.Outbox, NewReplicatedRepository(app.Replicas), userv1.NewReplicatedRepository(app.Replicas), premiumv1.NewReplicatedRepository(app.Replicas))
+	handler := NewHandler(cfg, service)
+
+	mr := r.PathPrefix("/v1/match").Subrouter()
+	mr.HandleFunc("/feed", middleware.Authorize(cfg, handler.Feed)).Methods(http.MethodGet)
+	mr.HandleFunc("/swipe", middleware.Authorize(cfg, handler.Swipe)).Methods(http.MethodPost)
+	mr.HandleFunc("/likes", middleware.Authorize(cfg, handler.ListLikes)).Methods(http.MethodGet)
+	mr.HandleFunc("/likes/count", middleware.Authorize(cfg, handler.CountLikes)).Methods(http.MethodGet)
+}
@@ -0,0 +1,358 @@
+package matchv1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/pgcopy"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+)
+
+type Repository interface {
+	CountPendingLikes(ctx context.Context, userUID string) (count int, err error)
+	GetPendingLikes(ctx context.Context, userUID string, limit, offset int) (likes []Like, err error)
+	GetFeed(ctx context.Context, userUID string, limit, offset int, now time.Time) (rows []FeedRow, err error)
+	LockSwiper(ctx context.Context, userUID string) (err error)
+	CreateSwipe(ctx context.Context, userUID, targetUID string, liked bool) (created, matched bool, err error)
+	CountSwipesSince(ctx context.Context, userUID string, since time.Time) (count int, err error)
+	CreateManySwipes(ctx context.Context, swipes []Swipe) (created int64, err error)
+}
+
+type dbRepository struct {
+	db    *sql.DB
+	reads *replica.Set
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return NewReplicatedRepository(replica.Single(db))
+}
+
+// NewReplicatedRepository writes to the primary of reads and reads from its
+// replicas
+func NewReplicatedRepository(reads *replica.Set) Repository {
+	return &dbRepository{db: reads.Primary(), reads: reads}
+}
+
+// writer returns the transaction of ctx when the call runs within one. The
+// write is recorded so the rest of the request reads from the primary.
+func (d *dbRepository) writer(ctx context.Context) txn.Querier {
+	replica.MarkWrite(ctx)
+	return txn.From(ctx, d.db)
+}
+
+// reader returns the transaction of ctx when the call runs within one, a
+// replica otherwise
+func (d *dbRepository) reader(ctx context.Context) txn.Querier {
+	if txn.InTx(ctx) {
+		return txn.From(ctx, d.db)
+	}
+	return d.reads.Reader(ctx)
+}
+
+// pendingLikesFilter matches likes sent to the user which the user has not
+// swiped back on yet, in either direction.
+const pendingLikesFilter = `
+        FROM dealls_bumble.user_matches um
+        JOIN dealls_bumble.users me ON me.id = um.match_id
+        JOIN dealls_bumble.users u ON u.id = um.user_id
+        WHERE me.uid = $1
+            AND um.liked AND NOT um.matched AND NOT um.is_deleted
+            AND NOT u.is_deleted
+            AND NOT EXISTS (
+                SELECT 1
+                FROM dealls_bumble.user_matches swiped
+                WHERE swiped.user_id = me.id AND swiped.match_id = um.user_id
+                    AND NOT swiped.is_deleted
+            )
+`
+
+func (d *dbRepository) CountPendingLikes(ctx context.Context, userUID string) (count int, err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.CountPendingLikes")
+	defer tracing.EndQuery(span, &err)
+
+	q := `SELECT count(*)` + pendingLikesFilter + `;`
+	row := d.reader(ctx).QueryRowContext(ctx, q, userUID)
+	err = row.Scan(&count)
+	return
+}
+
+func (d *dbRepository) GetPendingLikes(ctx context.Context, userUID string, limit, offset int) (likes []Like, err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.GetPendingLikes")
+	defer tracing.EndQuery(span, &err)
+
+	q := `SELECT u.uid, u.name, CASE WHEN u.show_gender THEN u.gender END, u.birthdate, um.created_at` +
+		pendingLikesFilter + `
+        ORDER BY um.created_at DESC, um.id DESC
+        LIMIT $2 OFFSET $3;
+    `
+	rows, err := d.reader(ctx).QueryContext(ctx, q, userUID, limit, offset)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	likes = []Like{}
+	for rows.Next() {
+		var like Like
+		err = rows.Scan(&like.UID, &like.Name, &like.Gender, &like.Birthdate, &like.LikedAt)
+		if err != nil {
+			return
+		}
+		likes = append(likes, like)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetFeed returns users the viewer has not swiped yet. Users in travel mode
+// at now are placed at their travel location, both as viewer and as
+// candidate, and ages are taken at now.
+//
+// Gender groups come from the configured userv1.GenderMapping, passed in as
+// two parallel arrays so a mapping change applies without touching the rows.
+//
+// Candidates are the users userv1.Preferences.Shows to the viewer: they must
+// satisfy every preference of the viewer, and the viewer must satisfy every
+// preference the candidate marked as a dealbreaker. Candidates
+// whose other preferences also fit the viewer come first, then the nearest.
+// When the viewer has a location and a max distance, earth_box narrows the
+// search through the location indexes before the exact earth_distance check.
+func (d *dbRepository) GetFeed(ctx context.Context, userUID string, limit, offset int, now time.Time) (rows []FeedRow, err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.GetFeed")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        WITH mapping AS (
+            SELECT *
+            FROM unnest($4::varchar[], $5::varchar[]) AS m(gender, gender_group)
+        ), viewer AS (
+            SELECT u.id, m.gender_group, date_part('year', age($6::timestamp, u.birthdate))::int AS age,
+                CASE WHEN u.travel_expires_at > $6
+                    THEN ll_to_earth(u.travel_latitude, u.travel_longitude)
+                    ELSE ll_to_earth(u.latitude, u.longitude)
+                END AS position,
+                p.interested_in, p.min_age, p.max_age,
+                p.max_distance_km * 1000.0 AS max_distance
+            FROM dealls_bumble.users u
+            LEFT JOIN mapping m ON m.gender = u.gender
+            LEFT JOIN dealls_bumble.user_preferences p ON p.user_id = u.id
+            WHERE u.uid = $1 AND NOT u.is_deleted
+        ), candidates AS (
+            SELECT c.id, c.uid, c.name, m.gender_group, c.birthdate,
+                CASE WHEN c.show_gender THEN c.gender END AS gender,
+                date_part('year', age($6::timestamp, c.birthdate))::int AS age,
+                COALESCE(c.travel_expires_at > $6, false) AS visiting,
+                CASE WHEN c.travel_expires_at > $6
+                    THEN ll_to_earth(c.travel_latitude, c.travel_longitude)
+                    ELSE ll_to_earth(c.latitude, c.longitude)
+                END AS position,
+                p.interested_in, p.min_age, p.max_age,
+                p.max_distance_km * 1000.0 AS max_distance,
+                COALESCE(p.dealbreakers, '{}') AS dealbreakers
+            FROM viewer v
+            JOIN dealls_bumble.users c ON c.id <> v.id AND NOT c.is_deleted
+            LEFT JOIN mapping m ON m.gender = c.gender
+            LEFT JOIN dealls_bumble.user_preferences p ON p.user_id = c.id
+            WHERE (v.interested_in IS NULL OR m.gender_group = ANY(v.interested_in))
+                AND (
+                    v.max_distance IS NULL OR v.position IS NULL
+                    OR (
+                        earth_box(v.position, v.max_distance) @> ll_to_earth(c.latitude, c.longitude)
+                        AND NOT COALESCE(c.travel_expires_at > $6, false)
+                    )
+                    OR (
+                        earth_box(v.position, v.max_distance) @> ll_to_earth(c.travel_latitude, c.travel_longitude)
+                        AND c.travel_expires_at > $6
+                    )
+                )
+        ), scored AS (
+            SELECT c.*, earth_distance(v.position, c.position) AS distance,
+                c.interested_in IS NULL OR COALESCE(v.gender_group = ANY(c.interested_in), false) AS accepts_gender,
+                (c.min_age IS NULL OR v.age >= c.min_age) AND (c.max_age IS NULL OR v.age <= c.max_age) AS accepts_age,
+                c.max_distance IS NULL OR c.position IS NULL
+                    OR COALESCE(earth_distance(v.position, c.position) <= c.max_distance, false) AS accepts_distance
+            FROM viewer v
+            JOIN candidates c ON true
+            WHERE NOT EXISTS (
+                    SELECT 1
+                    FROM dealls_bumble.user_matches swiped
+                    WHERE swiped.user_id = v.id AND swiped.match_id = c.id AND NOT swiped.is_deleted
+                )
+                AND (v.min_age IS NULL OR c.age >= v.min_age)
+                AND (v.max_age IS NULL OR c.age <= v.max_age)
+                AND (
+                    v.max_distance IS NULL OR v.position IS NULL
+                    OR earth_distance(v.position, c.position) <= v.max_distance
+                )
+        )
+        SELECT uid, name, gender, birthdate, visiting, distance
+        FROM scored
+        WHERE (accepts_gender OR NOT 'interested_in' = ANY(dealbreakers))
+            AND (accepts_age OR NOT 'age' = ANY(dealbreakers))
+            AND (accepts_distance OR NOT 'distance' = ANY(dealbreakers))
+        ORDER BY accepts_gender AND accepts_age AND accepts_distance DESC, distance NULLS LAST, id
+        LIMIT $2 OFFSET $3;
+    `
+	genders, groups := mappingArgs(userv1.GetGenderMapping())
+	result, err := d.reader(ctx).QueryContext(ctx, q, userUID, limit, offset, genders, groups, now)
+	if err != nil {
+		return
+	}
+	defer result.Close()
+
+	rows = []FeedRow{}
+	for result.Next() {
+		var row FeedRow
+		err = result.Scan(&row.UID, &row.Name, &row.Gender, &row.Birthdate, &row.Visiting, &row.DistanceMeters)
+		if err != nil {
+			return
+		}
+		rows = append(rows, row)
+	}
+	err = result.Err()
+	return
+}
+
+// mappingArgs flattens the gender mapping into two arrays, sorted by gender,
+// where the n-th gender belongs to the n-th group
+func mappingArgs(mapping userv1.GenderMapping) (genders, groups []string) {
+	genders = make([]string, 0, len(mapping.Groups))
+	for gender := range mapping.Groups {
+		genders = append(genders, string(gender))
+	}
+	sort.Strings(genders)
+
+	groups = make([]string, len(genders))
+	for i, gender := range genders {
+		groups[i] = string(mapping.GroupOf(userv1.Gender(gender)))
+	}
+	return
+}
+
+// LockSwiper locks the row of the user until the transaction ends, so the
+// swipes of a user are counted and written one at a time. The lock is NO KEY
+// UPDATE, swipes on the user still reference the row meanwhile.
+// sql.ErrNoRows is returned when the user does not exist.
+func (d *dbRepository) LockSwiper(ctx context.Context, userUID string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.LockSwiper")
+	defer tracing.EndQuery(span, &err)
+
+	q := `SELECT id FROM dealls_bumble.users WHERE uid = $1 AND NOT is_deleted FOR NO KEY UPDATE;`
+	var id int64
+	err = d.writer(ctx).QueryRowContext(ctx, q, userUID).Scan(&id)
+	return
+}
+
+// CreateSwipe records a swipe from the user on the target, created is false
+// when the user already swiped on the target. A like on someone who already
+// liked the user marks both rows as matched in the same statement.
+// sql.ErrNoRows is returned when either user does not exist.
+func (d *dbRepository) CreateSwipe(ctx context.Context, userUID, targetUID string, liked bool) (created, matched bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.CreateSwipe")
+	defer tracing.EndQuery(span, &err)
+
+	// swipes between the same two users take turns on a lock of the pair,
+	// keyed by two ints apart from the bigint keys of the relay and migrations.
+	// It is taken before the swipe statement starts, whose snapshot then holds
+	// the like of the other user when both like at the same time.
+	lock := `
+        SELECT pg_advisory_xact_lock(least(s.id, t.id), greatest(s.id, t.id))
+        FROM dealls_bumble.users s, dealls_bumble.users t
+        WHERE s.uid = $1 AND NOT s.is_deleted AND t.uid = $2 AND NOT t.is_deleted;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, lock, userUID, targetUID)
+	if err != nil {
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+		return
+	}
+
+	q := `
+        WITH swiper AS (
+            SELECT id FROM dealls_bumble.users WHERE uid = $1 AND NOT is_deleted
+        ), target AS (
+            SELECT id FROM dealls_bumble.users WHERE uid = $2 AND NOT is_deleted
+        ), mutual AS (
+            UPDATE dealls_bumble.user_matches um
+            SET matched = true
+            FROM swiper s, target t
+            WHERE $3 AND um.user_id = t.id AND um.match_id = s.id AND um.liked AND NOT um.is_deleted
+                AND NOT EXISTS (
+                    SELECT 1 FROM dealls_bumble.user_matches swiped
+                    WHERE swiped.user_id = s.id AND swiped.match_id = t.id
+                )
+            RETURNING um.id
+        )
+        INSERT INTO dealls_bumble.user_matches (user_id, match_id, liked, matched)
+        SELECT s.id, t.id, $3, EXISTS (SELECT 1 FROM mutual)
+        FROM swiper s, target t
+        ON CONFLICT (user_id, match_id) DO NOTHING
+        RETURNING matched;
+    `
+	row := d.writer(ctx).QueryRowContext(ctx, q, userUID, targetUID, liked)
+	err = row.Scan(&matched)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	return err == nil, matched, err
+}
+
+// CountSwipesSince counts the swipes of the user, in either direction, made at
+// or after since
+func (d *dbRepository) CountSwipesSince(ctx context.Context, userUID string, since time.Time) (count int, err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.CountSwipesSince")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        SELECT count(*)
+        FROM dealls_bumble.user_matches um
+        JOIN dealls_bumble.users u ON u.id = um.user_id
+        WHERE u.uid = $1 AND um.created_at >= $2 AND NOT um.is_deleted;
+    `
+	row := d.reader(ctx).QueryRowContext(ctx, q, userUID, since)
+	err = row.Scan(&count)
+	return
+}
+
+// CreateManySwipes records many swipes with COPY and returns how many were
+// created. Unlike CreateSwipe it trusts the Matched flag of each swipe,
+// swipes on unknown users or already made are skipped.
+func (d *dbRepository) CreateManySwipes(ctx context.Context, swipes []Swipe) (created int64, err error) {
+	ctx, span := tracing.StartQuery(ctx, "match.CreateManySwipes")
+	defer tracing.EndQuery(span, &err)
+
+	rows := make([][]any, len(swipes))
+	for i, swipe := range swipes {
+		rows[i] = []any{swipe.UserUID, swipe.TargetUID, swipe.Liked, swipe.Matched}
+	}
+
+	replica.MarkWrite(ctx)
+	return pgcopy.Run(ctx, d.db, pgcopy.Load{
+		Staging: "staging_user_matches",
+		Columns: []pgcopy.Column{
+			{Name: "user_uid", Type: "text"}, {Name: "target_uid", Type: "text"},
+			{Name: "liked", Type: "bool"}, {Name: "matched", Type: "bool"},
+		},
+		Rows: rows,
+		Statements: []string{`
+            INSERT INTO dealls_bumble.user_matches (user_id, match_id, liked, matched)
+            SELECT u.id, t.id, s.liked, s.matched
+            FROM staging_user_matches s
+            JOIN dealls_bumble.users u ON u.uid = s.user_uid AND NOT u.is_deleted
+            JOIN dealls_bumble.users t ON t.uid = s.target_uid AND NOT t.is_deleted
+            ON CONFLICT (user_id, match_id) DO NOTHING;
+        `},
+	})
+}
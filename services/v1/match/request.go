@@ -0,0 +1,56 @@
+package matchv1
+
+import (
+	"net/http"
+	"strconv"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+var (
+	DefaultLimit = 10
+	MaxLimit     = 50
+)
+
+type PageQuery struct {
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
+
+// NewPageQuery reads the pagination query params, missing or malformed
+// values fall back to the defaults and are caught by Validate.
+func NewPageQuery(r *http.Request) PageQuery {
+	q := PageQuery{Page: 1, Limit: DefaultLimit}
+	if page := r.URL.Query().Get("page"); page != "" {
+		q.Page, _ = strconv.Atoi(page)
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		q.Limit, _ = strconv.Atoi(limit)
+	}
+
+	return q
+}
+
+func (q PageQuery) Validate() error {
+	return validation.ValidateStruct(&q,
+		validation.Field(&q.Page, validation.Required, validation.Min(1)),
+		validation.Field(&q.Limit, validation.Required, validation.Min(1), validation.Max(MaxLimit)),
+	)
+}
+
+func (q PageQuery) Offset() int {
+	return (q.Page - 1) * q.Limit
+}
+
+type SwipePayload struct {
+	UID string `json:"uid"`
+	// Liked is true for a swipe right and false for a pass
+	Liked *bool `json:"liked"`
+}
+
+func (p SwipePayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.UID, validation.Required),
+		validation.Field(&p.Liked, validation.NotNil),
+	)
+}
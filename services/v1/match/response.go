@@ -0,0 +1,23 @@
+package matchv1
+
+import servicebase "github.com/farolinar/dealls-bumble/services/base"
+
+type LikesResponse struct {
+	servicebase.ResponseBody
+	Data []Like `json:"data"`
+}
+
+type LikesCountResponse struct {
+	servicebase.ResponseBody
+	Data *LikesCount `json:"data,omitempty"`
+}
+
+type FeedResponse struct {
+	servicebase.ResponseBody
+	Data []Candidate `json:"data"`
+}
+
+type SwipeResponse struct {
+	servicebase.ResponseBody
+	Data *SwipeResult `json:"data,omitempty"`
+}
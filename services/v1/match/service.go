@@ -0,0 +1,256 @@
+package matchv1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/geo"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	"github.com/rs/zerolog"
+)
+
+type Service interface {
+	CountLikes(ctx context.Context, userUID string) (resp LikesCount, err error)
+	ListLikes(ctx context.Context, userUID string, query PageQuery) (likes []Like, pagination servicebase.Pagination, err error)
+	Feed(ctx context.Context, userUID string, query PageQuery) (candidates []Candidate, err error)
+	Swipe(ctx context.Context, userUID string, payload SwipePayload) (resp SwipeResult, err error)
+}
+
+type matchService struct {
+	cfg               config.AppConfig
+	clock             clock.Clock
+	settings          *settings.Store
+	tx                *txn.TxManager
+	outbox            *events.Outbox
+	repository        Repository
+	userRepository    userv1.Repository
+	premiumRepository premiumv1.Repository
+}
+
+func NewService(cfg config.AppConfig, clk clock.Clock, store *settings.Store, tx *txn.TxManager, outbox *events.Outbox, repository Repository, userRepository userv1.Repository, premiumRepository premiumv1.Repository) Service {
+	return &matchService{
+		cfg:               cfg,
+		clock:             clk,
+		settings:          store,
+		tx:                tx,
+		outbox:            outbox,
+		repository:        repository,
+		userRepository:    userRepository,
+		premiumRepository: premiumRepository,
+	}
+}
+
+func (s *matchService) CountLikes(ctx context.Context, userUID string) (resp LikesCount, err error) {
+	resp.Count, err = s.repository.CountPendingLikes(ctx, userUID)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error counting pending likes: %s", err.Error())
+		return
+	}
+
+	canSee, err := s.premiumRepository.HasPerk(ctx, userUID, premiumv1.PerkSeeLikes)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error checking perk: %s", err.Error())
+		return
+	}
+	resp.Blurred = !canSee
+
+	return
+}
+
+func (s *matchService) ListLikes(ctx context.Context, userUID string, query PageQuery) (likes []Like, pagination servicebase.Pagination, err error) {
+	canSee, err := s.premiumRepository.HasPerk(ctx, userUID, premiumv1.PerkSeeLikes)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error checking perk: %s", err.Error())
+		return
+	}
+	if !canSee {
+		err = premiumv1.ErrPerkRequired
+		return
+	}
+
+	records, err := s.repository.CountPendingLikes(ctx, userUID)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error counting pending likes: %s", err.Error())
+		return
+	}
+
+	likes, err = s.repository.GetPendingLikes(ctx, userUID, query.Limit, query.Offset())
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting pending likes: %s", err.Error())
+		return
+	}
+
+	pagination = servicebase.NewPagination(query.Page, query.Limit, records)
+	return
+}
+
+func (s *matchService) Feed(ctx context.Context, userUID string, query PageQuery) (candidates []Candidate, err error) {
+	now := s.clock.Now()
+	rows, err := s.repository.GetFeed(ctx, userUID, query.Limit, query.Offset(), now)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting feed: %s", err.Error())
+		return
+	}
+
+	candidates = make([]Candidate, 0, len(rows))
+	for _, row := range rows {
+		candidate := Candidate{
+			UID:      row.UID,
+			Name:     row.Name,
+			Gender:   row.Gender,
+			Age:      servicebase.AgeAt(row.Birthdate, now),
+			Visiting: row.Visiting,
+		}
+		if row.DistanceMeters != nil {
+			km := geo.ApproximateKm(*row.DistanceMeters, userUID+":"+row.UID)
+			candidate.DistanceKm = &km
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return
+}
+
+// Swipe records a like or a pass. Likes are rejected when the target would
+// not be in the feed of the user, see userv1.Preferences.Shows, passes are
+// always allowed.
+func (s *matchService) Swipe(ctx context.Context, userUID string, payload SwipePayload) (resp SwipeResult, err error) {
+	if payload.UID == userUID {
+		err = ErrSwipeSelf
+		return
+	}
+
+	// the checks read in the transaction writing the swipe
+	var user, target userv1.User
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+		err = s.checkSwipeQuota(ctx, userUID)
+		if err != nil {
+			return
+		}
+
+		if *payload.Liked {
+			var userPreferences, targetPreferences userv1.Preferences
+			user, userPreferences, err = s.getUserWithPreferences(ctx, userUID)
+			if err != nil {
+				return
+			}
+			target, targetPreferences, err = s.getUserWithPreferences(ctx, payload.UID)
+			if err != nil {
+				return
+			}
+
+			if !userPreferences.Shows(user, target, targetPreferences, s.clock.Now()) {
+				return ErrPreferenceMismatch
+			}
+		}
+
+		var created bool
+		created, resp.Matched, err = s.repository.CreateSwipe(ctx, userUID, payload.UID, *payload.Liked)
+		if err != nil {
+			zerolog.Ctx(ctx).Debug().Msgf("error creating swipe: %s", err.Error())
+			if errors.Is(err, sql.ErrNoRows) {
+				err = userv1.ErrNotFound
+			}
+			return
+		}
+		if !created {
+			return ErrAlreadySwiped
+		}
+
+		published := []events.Event{events.Swiped{UserUID: userUID, TargetUID: payload.UID, Liked: *payload.Liked}}
+		if resp.Matched {
+			published = append(published, events.Matched{UserUID: userUID, MatchUID: payload.UID})
+		}
+		return s.outbox.Publish(ctx, published...)
+	})
+	if err != nil {
+		return
+	}
+
+	direction := metrics.DirectionPass
+	if *payload.Liked {
+		direction = metrics.DirectionLike
+	}
+	metrics.Swipes.WithLabelValues(direction).Inc()
+
+	if resp.Matched {
+		metrics.Matches.Inc()
+		resp.FirstMessageBy = userv1.GetGenderMapping().FirstMover(user, target)
+	}
+
+	return
+}
+
+// checkSwipeQuota fails once the user made the daily swipe limit of the
+// current settings since midnight UTC, unless the user has unlimited swipes
+func (s *matchService) checkSwipeQuota(ctx context.Context, userUID string) (err error) {
+	limit := s.settings.Get().SwipeDailyLimit
+	if limit <= 0 {
+		return
+	}
+
+	// the count holds until the swipe is written, a swipe of the user made
+	// meanwhile waits for the lock
+	err = s.repository.LockSwiper(ctx, userUID)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error locking swiper: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = userv1.ErrNotFound
+		}
+		return
+	}
+
+	now := s.clock.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	count, err := s.repository.CountSwipesSince(ctx, userUID, since)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error counting swipes: %s", err.Error())
+		return
+	}
+	if count < limit {
+		return
+	}
+
+	unlimited, err := s.premiumRepository.HasPerk(ctx, userUID, premiumv1.PerkUnlimitedSwipes)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error checking perk: %s", err.Error())
+		return
+	}
+	if !unlimited {
+		err = ErrSwipeQuotaExceeded
+	}
+
+	return
+}
+
+func (s *matchService) getUserWithPreferences(ctx context.Context, uid string) (user userv1.User, preferences userv1.Preferences, err error) {
+	user, err = s.userRepository.GetByUID(ctx, uid)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting user: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = userv1.ErrNotFound
+		}
+		return
+	}
+
+	preferences, err = s.userRepository.GetPreferences(ctx, uid)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting preferences: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = userv1.ErrNotFound
+		}
+		return
+	}
+
+	return
+}
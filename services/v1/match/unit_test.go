@@ -0,0 +1,578 @@
+package matchv1
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	userv1 "github.com/farolinar/dealls-bumble/services/v1/user"
+	_ "github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch_Unit_ListLikes(t *testing.T) {
+	url := "/v1/match/likes"
+	userUID := "uid123"
+	likedAt := time.Date(2024, 5, 26, 0, 0, 0, 0, time.UTC)
+	birthdate := time.Date(1999, 10, 23, 0, 0, 0, 0, time.UTC)
+
+	type fields struct {
+		svc func() Service
+	}
+	type args struct {
+		r func() *http.Request
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		args       args
+		code       string
+		records    int
+		httpStatus int
+	}{
+		{
+			name: "Success with see_likes perk - returns 200",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectQuery(`SELECT EXISTS`).WithArgs(userUID, string(premiumv1.PerkSeeLikes)).
+						WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+					mocking.ExpectQuery(`SELECT count\(\*\)`).WithArgs(userUID).
+						WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+					mocking.ExpectQuery(`SELECT u.uid, u.name, CASE WHEN u.show_gender THEN u.gender END`).WithArgs(userUID, 1, 1).
+						WillReturnRows(sqlmock.NewRows([]string{"uid", "name", "gender", "birthdate", "created_at"}).
+							AddRow("uid456", "Tav", "woman", birthdate, likedAt))
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			args: args{
+				r: func() *http.Request {
+					return newAuthorizedRequest(t, url+"?page=2&limit=1", userUID)
+				},
+			},
+			code:       servicebase.CodeSuccess,
+			records:    2,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name: "Without see_likes perk - returns 403",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectQuery(`SELECT EXISTS`).WithArgs(userUID, string(premiumv1.PerkSeeLikes)).
+						WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			args: args{
+				r: func() *http.Request {
+					return newAuthorizedRequest(t, url, userUID)
+				},
+			},
+			code:       premiumv1.ErrPerkRequired.Code,
+			httpStatus: http.StatusForbidden,
+		},
+		{
+			name: "Validation limit error - returns 400",
+			fields: fields{
+				svc: func() Service {
+					db, _, _ := sqlmock.New()
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			args: args{
+				r: func() *http.Request {
+					return newAuthorizedRequest(t, url+"?limit=1000", userUID)
+				},
+			},
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Handler{
+				service: tt.fields.svc(),
+			}
+
+			requestRecorder := httptest.NewRecorder()
+			c.ListLikes(requestRecorder, tt.args.r())
+			var resp LikesResponse
+			err := json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+
+			if resp.Code == servicebase.CodeSuccess {
+				assert.Equal(t, tt.records, resp.Pagination.Records)
+				assert.Equal(t, 2, resp.Pagination.TotalPages)
+				assert.Len(t, resp.Data, 1)
+			}
+		})
+	}
+}
+
+func TestMatch_Unit_CountLikes(t *testing.T) {
+	url := "/v1/match/likes/count"
+	userUID := "uid123"
+
+	tests := []struct {
+		name    string
+		hasPerk bool
+		count   int
+		blurred bool
+	}{
+		{
+			name:    "Standard user - returns blurred count",
+			hasPerk: false,
+			count:   3,
+			blurred: true,
+		},
+		{
+			name:    "Premium user - returns count",
+			hasPerk: true,
+			count:   3,
+			blurred: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mocking, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating mock: %v", err)
+			}
+			mocking.ExpectQuery(`SELECT count\(\*\)`).WithArgs(userUID).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.count))
+			mocking.ExpectQuery(`SELECT EXISTS`).WithArgs(userUID, string(premiumv1.PerkSeeLikes)).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.hasPerk))
+
+			c := &Handler{
+				service: NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db)),
+			}
+
+			requestRecorder := httptest.NewRecorder()
+			c.CountLikes(requestRecorder, newAuthorizedRequest(t, url, userUID))
+			var resp LikesCountResponse
+			err = json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, http.StatusOK, requestRecorder.Code)
+			assert.Equal(t, servicebase.CodeSuccess, resp.Code)
+			assert.Equal(t, tt.count, resp.Data.Count)
+			assert.Equal(t, tt.blurred, resp.Data.Blurred)
+		})
+	}
+}
+
+func TestMatch_Unit_Feed(t *testing.T) {
+	url := "/v1/match/feed"
+	userUID := "uid123"
+	now := time.Date(2024, 5, 26, 10, 0, 0, 0, time.UTC)
+	// 25 at now, 26 by the wall clock
+	birthdate := time.Date(1998, 5, 27, 0, 0, 0, 0, time.UTC)
+
+	db, mocking, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	if err != nil {
+		t.Fatalf("error creating mock: %v", err)
+	}
+	// the mapping goes in as arrays, the n-th gender in the n-th group
+	genders := []string{"agender", "genderfluid", "man", "non_binary", "trans_man", "trans_woman", "woman"}
+	groups := []string{"non_binary", "non_binary", "men", "non_binary", "men", "women", "women"}
+	mocking.ExpectQuery(`FROM unnest\(\$4::varchar\[\], \$5::varchar\[\]\)`).WithArgs(userUID, DefaultLimit, 0, genders, groups, now).
+		WillReturnRows(sqlmock.NewRows([]string{"uid", "name", "gender", "birthdate", "visiting", "distance"}).
+			AddRow("uid456", "Tav", "woman", birthdate, false, 12345.6).
+			AddRow("uid789", "Astarion", nil, birthdate, true, nil))
+
+	c := &Handler{
+		service: NewService(getConfig(), clock.Fixed(now), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db)),
+	}
+
+	requestRecorder := httptest.NewRecorder()
+	c.Feed(requestRecorder, newAuthorizedRequest(t, url, userUID))
+	var resp FeedResponse
+	err = json.NewDecoder(requestRecorder.Body).Decode(&resp)
+	if err != nil {
+		t.Fatalf("Error decoding JSON: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, requestRecorder.Code)
+	assert.Equal(t, servicebase.CodeSuccess, resp.Code)
+	assert.Len(t, resp.Data, 2)
+
+	// distance is rounded to whole kilometers within the fuzz range
+	if assert.NotNil(t, resp.Data[0].DistanceKm) {
+		assert.InDelta(t, 12, *resp.Data[0].DistanceKm, 1)
+	}
+	assert.Equal(t, 25, resp.Data[0].Age)
+	assert.Equal(t, userv1.GenderWoman, *resp.Data[0].Gender)
+	assert.Nil(t, resp.Data[1].Gender)
+	assert.Nil(t, resp.Data[1].DistanceKm)
+	assert.True(t, resp.Data[1].Visiting)
+}
+
+func TestMatch_Unit_Swipe(t *testing.T) {
+	url := "/v1/match/swipe"
+	userUID := "uid123"
+	targetUID := "uid456"
+	adult := time.Now().AddDate(-25, 0, -1)
+
+	expectProfile := func(mocking sqlmock.Sqlmock, uid string, gender userv1.Gender, interestedIn, dealbreakers any) {
+		mocking.ExpectQuery(`SELECT uid, name, email, username, gender`).WithArgs(uid).
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "name", "email", "username", "gender", "show_gender", "sex",
+				"birthdate", "verified", "latitude", "longitude", "travel_latitude", "travel_longitude", "travel_expires_at",
+				"is_admin", "created_at"}).
+				AddRow(uid, "name", uid+"@email.com", uid, gender, true, nil, adult, false, nil, nil, nil, nil, nil, false, adult))
+		mocking.ExpectQuery(`SELECT array_to_string\(p.interested_in`).WithArgs(uid).
+			WillReturnRows(sqlmock.NewRows([]string{"interested_in", "min_age", "max_age", "max_distance_km", "dealbreakers"}).
+				AddRow(interestedIn, nil, nil, nil, dealbreakers))
+	}
+
+	now := time.Date(2024, 5, 26, 15, 30, 0, 0, time.UTC)
+	midnight := time.Date(2024, 5, 26, 0, 0, 0, 0, time.UTC)
+	limited := func(limit int) *settings.Store {
+		current := settings.Defaults()
+		current.SwipeDailyLimit = limit
+		return settings.NewStore(current)
+	}
+
+	type fields struct {
+		svc func() Service
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		payload        string
+		code           string
+		matched        bool
+		firstMessageBy string
+		httpStatus     int
+	}{
+		{
+			name: "Mutual like - returns 201 matched",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					expectProfile(mocking, userUID, userv1.GenderMan, "women", "interested_in")
+					expectProfile(mocking, targetUID, userv1.GenderWoman, "men", "interested_in")
+					mocking.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(userUID, targetUID).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectQuery(`INSERT INTO dealls_bumble.user_matches`).WithArgs(userUID, targetUID, true).
+						WillReturnRows(sqlmock.NewRows([]string{"matched"}).AddRow(true))
+					mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).WithArgs("Swiped", "user:"+userUID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).WithArgs("Matched", "user:"+userUID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectCommit()
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:        `{"uid": "uid456", "liked": true}`,
+			code:           servicebase.CodeSuccess,
+			matched:        true,
+			firstMessageBy: targetUID,
+			httpStatus:     http.StatusCreated,
+		},
+		{
+			name: "Like failing the target dealbreaker - returns 400",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					expectProfile(mocking, userUID, userv1.GenderMan, nil, nil)
+					expectProfile(mocking, targetUID, userv1.GenderWoman, "women", "interested_in")
+					mocking.ExpectRollback()
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": true}`,
+			code:       ErrPreferenceMismatch.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			// the target would not be in the feed of the user either
+			name: "Like failing a preference of the user - returns 400",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					expectProfile(mocking, userUID, userv1.GenderMan, "men", nil)
+					expectProfile(mocking, targetUID, userv1.GenderWoman, nil, nil)
+					mocking.ExpectRollback()
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": true}`,
+			code:       ErrPreferenceMismatch.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Pass ignores dealbreakers - returns 201",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					mocking.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(userUID, targetUID).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectQuery(`INSERT INTO dealls_bumble.user_matches`).WithArgs(userUID, targetUID, false).
+						WillReturnRows(sqlmock.NewRows([]string{"matched"}).AddRow(false))
+					mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).WithArgs("Swiped", "user:"+userUID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectCommit()
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": false}`,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusCreated,
+		},
+		{
+			name: "Swipe made before - returns 400",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					mocking.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(userUID, targetUID).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectQuery(`ON CONFLICT \(user_id, match_id\) DO NOTHING`).WithArgs(userUID, targetUID, false).
+						WillReturnRows(sqlmock.NewRows([]string{"matched"}))
+					mocking.ExpectRollback()
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": false}`,
+			code:       ErrAlreadySwiped.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Swipe on unknown user - returns 404",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					mocking.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(userUID, targetUID).
+						WillReturnResult(sqlmock.NewResult(0, 0))
+					mocking.ExpectRollback()
+
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": false}`,
+			code:       userv1.ErrNotFound.Code,
+			httpStatus: http.StatusNotFound,
+		},
+		{
+			name: "Daily limit reached - returns 429",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					mocking.ExpectQuery(`SELECT id FROM dealls_bumble.users WHERE uid = \$1 AND NOT is_deleted FOR NO KEY UPDATE`).WithArgs(userUID).
+						WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+					mocking.ExpectQuery(`SELECT count\(\*\)`).WithArgs(userUID, midnight).
+						WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+					mocking.ExpectQuery(`SELECT EXISTS`).WithArgs(userUID, string(premiumv1.PerkUnlimitedSwipes)).
+						WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+					mocking.ExpectRollback()
+
+					return NewService(getConfig(), clock.Fixed(now), limited(10), txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": false}`,
+			code:       ErrSwipeQuotaExceeded.Code,
+			httpStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "Daily limit reached with unlimited swipes - returns 201",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					mocking.ExpectQuery(`SELECT id FROM dealls_bumble.users WHERE uid = \$1 AND NOT is_deleted FOR NO KEY UPDATE`).WithArgs(userUID).
+						WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+					mocking.ExpectQuery(`SELECT count\(\*\)`).WithArgs(userUID, midnight).
+						WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+					mocking.ExpectQuery(`SELECT EXISTS`).WithArgs(userUID, string(premiumv1.PerkUnlimitedSwipes)).
+						WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+					mocking.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(userUID, targetUID).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectQuery(`INSERT INTO dealls_bumble.user_matches`).WithArgs(userUID, targetUID, false).
+						WillReturnRows(sqlmock.NewRows([]string{"matched"}).AddRow(false))
+					mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).WithArgs("Swiped", "user:"+userUID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectCommit()
+
+					return NewService(getConfig(), clock.Fixed(now), limited(10), txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": false}`,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusCreated,
+		},
+		{
+			name: "Under the daily limit - returns 201",
+			fields: fields{
+				svc: func() Service {
+					db, mocking, err := sqlmock.New()
+					if err != nil {
+						t.Fatalf("error creating mock: %v", err)
+					}
+					mocking.ExpectBegin()
+					mocking.ExpectQuery(`SELECT id FROM dealls_bumble.users WHERE uid = \$1 AND NOT is_deleted FOR NO KEY UPDATE`).WithArgs(userUID).
+						WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+					mocking.ExpectQuery(`SELECT count\(\*\)`).WithArgs(userUID, midnight).
+						WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(9))
+					mocking.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(userUID, targetUID).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectQuery(`INSERT INTO dealls_bumble.user_matches`).WithArgs(userUID, targetUID, false).
+						WillReturnRows(sqlmock.NewRows([]string{"matched"}).AddRow(false))
+					mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).WithArgs("Swiped", "user:"+userUID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mocking.ExpectCommit()
+
+					return NewService(getConfig(), clock.Fixed(now), limited(10), txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456", "liked": false}`,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusCreated,
+		},
+		{
+			name: "Swipe on self - returns 400",
+			fields: fields{
+				svc: func() Service {
+					db, _, _ := sqlmock.New()
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid123", "liked": true}`,
+			code:       ErrSwipeSelf.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Validation liked error - returns 400",
+			fields: fields{
+				svc: func() Service {
+					db, _, _ := sqlmock.New()
+					return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), userv1.NewRepository(db), premiumv1.NewRepository(db))
+				},
+			},
+			payload:    `{"uid": "uid456"}`,
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Handler{
+				service: tt.fields.svc(),
+			}
+
+			req := newAuthorizedRequest(t, url, userUID)
+			req.Method = http.MethodPost
+			req.Body = io.NopCloser(bytes.NewBufferString(tt.payload))
+
+			requestRecorder := httptest.NewRecorder()
+			c.Swipe(requestRecorder, req)
+			var resp SwipeResponse
+			err := json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+
+			if resp.Code == servicebase.CodeSuccess {
+				assert.Equal(t, tt.matched, resp.Data.Matched)
+				assert.Equal(t, tt.firstMessageBy, resp.Data.FirstMessageBy)
+			}
+		})
+	}
+}
+
+// arrayConverter passes string slices through like the pgx driver does
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if values, ok := v.([]string); ok {
+		return values, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func getConfig() config.AppConfig {
+	return config.AppConfig{
+		App: config.App{
+			JWTSecret:       "jwt_secret",
+			BCryptSalt:      8,
+			JWTHourDuration: 2,
+		},
+	}
+}
+
+func newAuthorizedRequest(t *testing.T, url, userUID string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(req.Context(), middleware.ContextAuthKey{}, userUID)
+	return req.WithContext(ctx)
+}
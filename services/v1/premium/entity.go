@@ -0,0 +1,10 @@
+package premiumv1
+
+type PerkCode string
+
+const (
+	PerkSeeLikes   PerkCode = "see_likes"
+	PerkTravelMode PerkCode = "travel_mode"
+	// PerkUnlimitedSwipes lifts the daily swipe limit of the runtime settings
+	PerkUnlimitedSwipes PerkCode = "unlimited_swipes"
+)
@@ -0,0 +1,12 @@
+package premiumv1
+
+import (
+	"net/http"
+
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+)
+
+var (
+	ErrPerkRequired = servicebase.NewAppError(http.StatusForbidden, "PRM-403-001", MessagePerkRequired)
+	ErrNotFound     = servicebase.NewAppError(http.StatusNotFound, "PRM-404-001", MessageNotFound)
+)
@@ -0,0 +1,20 @@
+package premiumv1
+
+import (
+	"embed"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+func init() {
+	i18n.MustLoadFS(locales, "locales")
+}
+
+// Message IDs, the text of each language lives in locales/<lang>.json
+var (
+	MessagePerkRequired = "premium.perk_required"
+	MessageNotFound     = "premium.not_found"
+)
@@ -0,0 +1,81 @@
+package premiumv1
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+)
+
+type Repository interface {
+	HasPerk(ctx context.Context, userUID string, perk PerkCode) (ok bool, err error)
+	GrantPackage(ctx context.Context, userUID string, packageID int64) (title string, err error)
+}
+
+type dbRepository struct {
+	db    *sql.DB
+	reads *replica.Set
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return NewReplicatedRepository(replica.Single(db))
+}
+
+// NewReplicatedRepository reads from the replicas of reads
+func NewReplicatedRepository(reads *replica.Set) Repository {
+	return &dbRepository{db: reads.Primary(), reads: reads}
+}
+
+// writer returns the transaction of ctx when the call runs within one. The
+// write is recorded so the rest of the request reads from the primary.
+func (d *dbRepository) writer(ctx context.Context) txn.Querier {
+	replica.MarkWrite(ctx)
+	return txn.From(ctx, d.db)
+}
+
+// reader returns the transaction of ctx when the call runs within one, a
+// replica otherwise
+func (d *dbRepository) reader(ctx context.Context) txn.Querier {
+	if txn.InTx(ctx) {
+		return txn.From(ctx, d.db)
+	}
+	return d.reads.Reader(ctx)
+}
+
+func (d *dbRepository) HasPerk(ctx context.Context, userUID string, perk PerkCode) (ok bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "premium.HasPerk")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        SELECT EXISTS (
+            SELECT 1
+            FROM dealls_bumble.users u
+            JOIN dealls_bumble.premium_packages pp ON pp.id = u.premium_package_id
+            WHERE u.uid = $1 AND $2 = ANY(pp.perks_codes) AND NOT pp.is_deleted
+        );
+    `
+	row := d.reader(ctx).QueryRowContext(ctx, q, userUID, string(perk))
+	err = row.Scan(&ok)
+	return
+}
+
+// GrantPackage gives the user the perks of the package, replacing the package
+// they had, and returns the title of the package. sql.ErrNoRows is returned
+// when the user or the package does not exist.
+func (d *dbRepository) GrantPackage(ctx context.Context, userUID string, packageID int64) (title string, err error) {
+	ctx, span := tracing.StartQuery(ctx, "premium.GrantPackage")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        UPDATE dealls_bumble.users u
+        SET premium_package_id = pp.id
+        FROM dealls_bumble.premium_packages pp
+        WHERE u.uid = $1 AND NOT u.is_deleted AND pp.id = $2 AND NOT pp.is_deleted
+        RETURNING pp.title;
+    `
+	row := d.writer(ctx).QueryRowContext(ctx, q, userUID, packageID)
+	err = row.Scan(&title)
+	return
+}
@@ -0,0 +1,52 @@
+package premiumv1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/rs/zerolog"
+)
+
+type Service interface {
+	GrantPackage(ctx context.Context, userUID string, packageID int64) (title string, err error)
+}
+
+type premiumService struct {
+	tx         *txn.TxManager
+	outbox     *events.Outbox
+	repository Repository
+}
+
+func NewService(tx *txn.TxManager, outbox *events.Outbox, repository Repository) Service {
+	return &premiumService{
+		tx:         tx,
+		outbox:     outbox,
+		repository: repository,
+	}
+}
+
+// GrantPackage gives the user the package, which counts as a purchase until
+// a payment flow exists, and publishes PackagePurchased
+func (s *premiumService) GrantPackage(ctx context.Context, userUID string, packageID int64) (title string, err error) {
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+		title, err = s.repository.GrantPackage(ctx, userUID, packageID)
+		if err != nil {
+			return
+		}
+		return s.outbox.Publish(ctx, events.PackagePurchased{UserUID: userUID, PackageID: packageID, Package: title})
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error granting package: %v", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	metrics.Purchases.WithLabelValues(title).Inc()
+	return
+}
@@ -0,0 +1,63 @@
+package premiumv1
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPremium_Unit_GrantPackage(t *testing.T) {
+	now := time.Date(2024, 5, 26, 10, 0, 0, 0, time.UTC)
+	newService := func(t *testing.T) (sqlmock.Sqlmock, Service) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		return mocking, NewService(txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db))
+	}
+
+	t.Run("Granting counts as a purchase", func(t *testing.T) {
+		mocking, service := newService(t)
+		purchased, err := json.Marshal(events.PackagePurchased{UserUID: "uid123", PackageID: 1, Package: "Gold"})
+		if err != nil {
+			t.Fatalf("error encoding event: %v", err)
+		}
+		mocking.ExpectBegin()
+		mocking.ExpectQuery(`UPDATE dealls_bumble.users u\s+SET premium_package_id`).WithArgs("uid123", int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("Gold"))
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).
+			WithArgs("PackagePurchased", "user:uid123", string(purchased), now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectCommit()
+
+		before := testutil.ToFloat64(metrics.Purchases.WithLabelValues("Gold"))
+		title, err := service.GrantPackage(context.Background(), "uid123", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "Gold", title)
+		assert.Equal(t, before+1, testutil.ToFloat64(metrics.Purchases.WithLabelValues("Gold")))
+	})
+
+	t.Run("Unknown users or packages are not found", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectBegin()
+		mocking.ExpectQuery(`UPDATE dealls_bumble.users u\s+SET premium_package_id`).WithArgs("nobody", int64(1)).
+			WillReturnError(sql.ErrNoRows)
+		mocking.ExpectRollback()
+
+		_, err := service.GrantPackage(context.Background(), "nobody", 1)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
@@ -1,7 +1,19 @@
 package userv1
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
 
+	"github.com/farolinar/dealls-bumble/internal/common/geo"
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+)
+
+// Sex is the legacy two value field, kept so older clients can still
+// register and read profiles. Gender replaces it.
 type Sex string
 
 const (
@@ -11,6 +23,146 @@ const (
 
 var SexList = []interface{}{Male, Female}
 
+// Gender is the gender identity the user registered with
+type Gender string
+
+const (
+	GenderWoman       Gender = "woman"
+	GenderMan         Gender = "man"
+	GenderTransWoman  Gender = "trans_woman"
+	GenderTransMan    Gender = "trans_man"
+	GenderNonBinary   Gender = "non_binary"
+	GenderGenderfluid Gender = "genderfluid"
+	GenderAgender     Gender = "agender"
+)
+
+// GenderGroup is what matching rules work with. Users choose the groups they
+// are interested in, and several gender identities can share a group.
+type GenderGroup string
+
+const (
+	GroupWomen     GenderGroup = "women"
+	GroupMen       GenderGroup = "men"
+	GroupNonBinary GenderGroup = "non_binary"
+)
+
+// GenderMapping drives every gender based rule, so identities and groups can
+// change through configuration instead of code
+type GenderMapping struct {
+	// Groups maps each accepted gender identity to its group
+	Groups map[Gender]GenderGroup `json:"groups"`
+	// LegacySex maps the legacy sex values to a gender identity
+	LegacySex map[Sex]Gender `json:"legacy_sex"`
+	// FirstMoveGroups send the first message when a match is between a user
+	// in one of these groups and a user outside them
+	FirstMoveGroups []GenderGroup `json:"first_move_groups"`
+}
+
+var DefaultGenderMapping = GenderMapping{
+	Groups: map[Gender]GenderGroup{
+		GenderWoman:       GroupWomen,
+		GenderTransWoman:  GroupWomen,
+		GenderMan:         GroupMen,
+		GenderTransMan:    GroupMen,
+		GenderNonBinary:   GroupNonBinary,
+		GenderGenderfluid: GroupNonBinary,
+		GenderAgender:     GroupNonBinary,
+	},
+	LegacySex: map[Sex]Gender{
+		Female: GenderWoman,
+		Male:   GenderMan,
+	},
+	FirstMoveGroups: []GenderGroup{GroupWomen},
+}
+
+// genderMapping is nil until a mapping is loaded. Requests read it while
+// LoadGenderMapping may replace it.
+var genderMapping atomic.Pointer[GenderMapping]
+
+// LoadGenderMapping replaces the default mapping with the one in the JSON
+// file at path
+func LoadGenderMapping(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var mapping GenderMapping
+	err = json.Unmarshal(data, &mapping)
+	if err != nil {
+		return err
+	}
+	if len(mapping.Groups) == 0 {
+		return fmt.Errorf("gender mapping %s has no groups", path)
+	}
+
+	genderMapping.Store(&mapping)
+	return nil
+}
+
+// GetGenderMapping returns the loaded mapping, DefaultGenderMapping until one
+// is loaded
+func GetGenderMapping() GenderMapping {
+	if mapping := genderMapping.Load(); mapping != nil {
+		return *mapping
+	}
+	return DefaultGenderMapping
+}
+
+// GenderList returns the accepted gender identities for validation
+func (m GenderMapping) GenderList() []interface{} {
+	list := make([]interface{}, 0, len(m.Groups))
+	for gender := range m.Groups {
+		list = append(list, gender)
+	}
+
+	return list
+}
+
+// GroupList returns the known groups for validation
+func (m GenderMapping) GroupList() []interface{} {
+	list := []interface{}{}
+	for _, group := range m.Groups {
+		if !slices.Contains(list, interface{}(group)) {
+			list = append(list, group)
+		}
+	}
+
+	return list
+}
+
+// GroupOf returns the group of a gender identity, empty when unknown
+func (m GenderMapping) GroupOf(gender Gender) GenderGroup {
+	return m.Groups[gender]
+}
+
+// LegacySexOf returns the legacy sex of a gender identity, nil when the
+// identity has no legacy equivalent
+func (m GenderMapping) LegacySexOf(gender Gender) *Sex {
+	for sex, legacy := range m.LegacySex {
+		if legacy == gender {
+			return &sex
+		}
+	}
+
+	return nil
+}
+
+// FirstMover returns the UID of the user who sends the first message in a
+// match between a and b, empty when either of them may
+func (m GenderMapping) FirstMover(a, b User) string {
+	aFirst := slices.Contains(m.FirstMoveGroups, m.GroupOf(a.Gender))
+	bFirst := slices.Contains(m.FirstMoveGroups, m.GroupOf(b.Gender))
+	switch {
+	case aFirst && !bFirst:
+		return a.UID
+	case bFirst && !aFirst:
+		return b.UID
+	}
+
+	return ""
+}
+
 type User struct {
 	ID             uint64    `json:"-"`
 	UID            string    `json:"uid"`
@@ -18,9 +170,135 @@ type User struct {
 	Email          string    `json:"email"`
 	Username       string    `json:"username"`
 	HashedPassword *string   `json:"-"`
-	Sex            Sex       `json:"sex"`
+	Gender         Gender    `json:"gender"`
+	ShowGender     bool      `json:"show_gender"`
+	Sex            *Sex      `json:"sex,omitempty"`
 	Birthdate      time.Time `json:"birthdate"`
 	Verified       bool      `json:"verified"`
 	MaxSwipes      int       `json:"maxs_swipes"`
-	CreatedAt      time.Time `json:"created_at"`
+	// Admin is only set by the create-admin command, admins may use the
+	// admin routes
+	Admin     bool      `json:"-"`
+	Location  *Location `json:"location,omitempty"`
+	Travel    *Travel   `json:"travel,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EffectiveLocation is the location used for distances, the travel location
+// while travel mode is active at now and the real location otherwise
+func (u User) EffectiveLocation(now time.Time) *Location {
+	if u.Travel != nil && u.Travel.ExpiresAt.After(now) {
+		return &u.Travel.Location
+	}
+
+	return u.Location
+}
+
+// Image is a picture of a user, URL is the key of the picture in the blob
+// store
+type Image struct {
+	UserUID string `json:"-"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// FeatureTravelMode is the feature flag of the runtime settings that allows
+// starting travels
+const FeatureTravelMode = "travel_mode"
+
+// Travel is a temporary virtual location used in place of the real one by
+// the feed and distance calculations until it expires
+type Travel struct {
+	Location  Location  `json:"location"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type PreferenceKey string
+
+const (
+	PreferenceInterestedIn PreferenceKey = "interested_in"
+	PreferenceAge          PreferenceKey = "age"
+	PreferenceDistance     PreferenceKey = "distance"
+)
+
+var PreferenceKeyList = []interface{}{PreferenceInterestedIn, PreferenceAge, PreferenceDistance}
+
+// Preferences describe who a user wants to see in the feed. They always
+// filter the user's own feed. Preferences listed in Dealbreakers are also
+// enforced the other way round, users who fail them never see this user in
+// their feed and cannot swipe on them.
+type Preferences struct {
+	// InterestedIn limits the gender groups shown, empty means everyone
+	InterestedIn []GenderGroup `json:"interested_in"`
+	MinAge       *int          `json:"min_age"`
+	MaxAge       *int          `json:"max_age"`
+	// MaxDistanceKm limits the feed to users within this distance, nil
+	// means no limit
+	MaxDistanceKm *int            `json:"max_distance_km"`
+	Dealbreakers  []PreferenceKey `json:"dealbreakers"`
+}
+
+// UserPreferences are the preferences of the user with the UID, used to
+// write many at once
+type UserPreferences struct {
+	UID string
+	Preferences
+}
+
+// Accepts reports whether other satisfies the preference identified by key,
+// as seen from self at now. Unset preferences accept everyone. A distance
+// preference accepts everyone until self has a location, then rejects users
+// without one.
+func (p Preferences) Accepts(key PreferenceKey, self, other User, now time.Time) bool {
+	switch key {
+	case PreferenceInterestedIn:
+		return len(p.InterestedIn) == 0 || slices.Contains(p.InterestedIn, GetGenderMapping().GroupOf(other.Gender))
+	case PreferenceAge:
+		age := servicebase.AgeAt(other.Birthdate, now)
+		return (p.MinAge == nil || age >= *p.MinAge) && (p.MaxAge == nil || age <= *p.MaxAge)
+	case PreferenceDistance:
+		if p.MaxDistanceKm == nil {
+			return true
+		}
+		from, to := self.EffectiveLocation(now), other.EffectiveLocation(now)
+		if from == nil {
+			return true
+		}
+		if to == nil {
+			return false
+		}
+		return geo.DistanceMeters(from.Latitude, from.Longitude, to.Latitude, to.Longitude) <= float64(*p.MaxDistanceKm)*1000
+	}
+
+	return true
+}
+
+// AcceptsDealbreakers reports whether other satisfies every preference self
+// marked as a dealbreaker
+func (p Preferences) AcceptsDealbreakers(self, other User, now time.Time) bool {
+	for _, key := range p.Dealbreakers {
+		if !p.Accepts(key, self, other, now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Shows reports whether other belongs in the feed of self and may be liked
+// by self: other satisfies every preference of self, and self every
+// dealbreaker of other. The feed query applies the same rules.
+func (p Preferences) Shows(self, other User, otherPreferences Preferences, now time.Time) bool {
+	for _, key := range []PreferenceKey{PreferenceInterestedIn, PreferenceAge, PreferenceDistance} {
+		if !p.Accepts(key, self, other, now) {
+			return false
+		}
+	}
+
+	return otherPreferences.AcceptsDealbreakers(other, self, now)
 }
@@ -1,10 +1,26 @@
 package userv1
 
-import "errors"
+import (
+	"net/http"
 
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+var (
+	ErrNotFound = servicebase.NewAppError(http.StatusNotFound, "USR-404-001", MessageNotFound)
+	// ErrUsernameNotFound is returned by login, which answers unknown
+	// usernames with 400 like wrong passwords
+	ErrUsernameNotFound = servicebase.NewAppError(http.StatusBadRequest, "USR-400-001", MessageNotFound)
+	ErrWrongPassword    = servicebase.NewAppError(http.StatusBadRequest, "USR-400-002", MessageWrongPassword)
+	ErrAlreadyExists    = servicebase.NewAppError(http.StatusBadRequest, "USR-400-003", MessageAlreadyExists)
+	// ErrTravelModeDisabled is returned while the travel_mode feature flag
+	// is off
+	ErrTravelModeDisabled = servicebase.NewAppError(http.StatusForbidden, "USR-403-001", MessageTravelModeDisabled)
+	ErrAdminRequired      = servicebase.NewAppError(http.StatusForbidden, "USR-403-002", MessageAdminRequired)
+)
+
+// validation rule errors, their code is the message ID
 var (
-	ErrNotFound         = errors.New(MessageNotFound)
-	ErrWrongPassword    = errors.New(MessageWrongPassword)
-	ErrAlreadyExists    = errors.New(MessageAlreadyExists)
-	ErrValidationFailed = errors.New(MessageValidationFailed)
+	ErrMaxAgeBelowMinAge = validation.NewError(MessageMaxAgeBelowMinAge, MessageMaxAgeBelowMinAge)
 )
@@ -1,20 +1,16 @@
 package userv1
 
 import (
-	"errors"
 	"net/http"
 
 	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
 	"github.com/farolinar/dealls-bumble/internal/common/request"
 	"github.com/farolinar/dealls-bumble/internal/common/response"
 	servicebase "github.com/farolinar/dealls-bumble/services/base"
-	"github.com/rs/zerolog/log"
-)
-
-var (
-	MessageSuccess          = "Success"
-	MessageInternalError    = "Internal server error"
-	MessageFailedDecodeJSON = "Failed to decode JSON"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
 )
 
 type Handler struct {
@@ -27,140 +23,289 @@ func NewHandler(cfg config.AppConfig, service Service) *Handler {
 }
 
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	translateMessage(r)
+	localizer := i18n.FromContext(r.Context())
 
 	var payload UserCreatePayload
 	var resp UserAuthenticationResponse
 
 	err := request.DecodeJSON(w, r, &payload)
 	if err != nil {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: MessageFailedDecodeJSON,
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+		servicebase.WriteError(w, r, servicebase.ErrFailedDecodeJSON.WithCause(err))
 		return
 	}
 
 	payload = payload.NewLayoutDateOnly()
 	err = payload.Validate()
 	if err != nil {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: err.Error(),
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+		servicebase.WriteError(w, r, err)
 		return
 	}
 
 	userResp, err := h.service.Create(r.Context(), payload)
-	if errors.Is(err, ErrAlreadyExists) {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: err.Error(),
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
-		return
-	}
 	if err != nil {
-		err = response.JSON(w, http.StatusInternalServerError, servicebase.ResponseBody{
-			Message: MessageInternalError,
-			Code:    servicebase.Code5XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+		servicebase.WriteError(w, r, err)
 		return
 	}
 
-	resp.Message = MessageSuccess
+	resp.Message = localizer.T(servicebase.MessageSuccess)
 	resp.Code = servicebase.CodeSuccess
 	resp.Data = &userResp
 	err = response.JSON(w, http.StatusCreated, resp)
 	if err != nil {
-		log.Error().Msgf("error encoding response body: %v", err)
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
 	}
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
-	translateMessage(r)
+	localizer := i18n.FromContext(r.Context())
 
 	var payload UserLoginPayload
 	var resp UserAuthenticationResponse
 
 	err := request.DecodeJSON(w, r, &payload)
 	if err != nil {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: MessageFailedDecodeJSON,
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+		servicebase.WriteError(w, r, servicebase.ErrFailedDecodeJSON.WithCause(err))
 		return
 	}
 
 	err = payload.Validate()
 	if err != nil {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: err.Error(),
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+		servicebase.WriteError(w, r, err)
 		return
 	}
 
 	userResp, err := h.service.Login(r.Context(), payload)
-	if errors.Is(err, ErrNotFound) {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: err.Error(),
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+	if err != nil {
+		servicebase.WriteError(w, r, err)
 		return
 	}
-	if errors.Is(err, ErrWrongPassword) {
-		err = response.JSON(w, http.StatusBadRequest, servicebase.ResponseBody{
-			Message: err.Error(),
-			Code:    servicebase.Code4XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &userResp
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp UserResponse
+
+	uid, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+
+	user, err := h.service.GetProfile(r.Context(), uid)
 	if err != nil {
-		err = response.JSON(w, http.StatusInternalServerError, servicebase.ResponseBody{
-			Message: MessageInternalError,
-			Code:    servicebase.Code5XX,
-		})
-		if err != nil {
-			log.Error().Msgf("error encoding response body: %v", err)
-		}
+		servicebase.WriteError(w, r, err)
 		return
 	}
 
-	resp.Message = MessageSuccess
+	resp.Message = localizer.T(servicebase.MessageSuccess)
 	resp.Code = servicebase.CodeSuccess
-	resp.Data = &userResp
+	resp.Data = &user
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp UserResponse
+
+	adminUID, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.service.GetUser(r.Context(), adminUID, mux.Vars(r)["uid"])
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &user
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var payload UserLocationPayload
+	var resp UserResponse
+
+	uid, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err := request.DecodeJSON(w, r, &payload)
+	if err != nil {
+		servicebase.WriteError(w, r, servicebase.ErrFailedDecodeJSON.WithCause(err))
+		return
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	user, err := h.service.UpdateLocation(r.Context(), uid, payload)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &user
 	err = response.JSON(w, http.StatusOK, resp)
 	if err != nil {
-		log.Error().Msgf("error encoding response body: %v", err)
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
 	}
 }
 
-func translateMessage(r *http.Request) {
-	lang := r.Header.Get("Accept-Language")
-	servicebase.Translate(lang)
-	Translate(lang)
+func (h *Handler) StartTravel(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var payload UserTravelPayload
+	var resp UserResponse
+
+	uid, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err := request.DecodeJSON(w, r, &payload)
+	if err != nil {
+		servicebase.WriteError(w, r, servicebase.ErrFailedDecodeJSON.WithCause(err))
+		return
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	user, err := h.service.StartTravel(r.Context(), uid, payload)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &user
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) StopTravel(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp UserResponse
+
+	uid, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.service.StopTravel(r.Context(), uid)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &user
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var resp UserPreferencesResponse
+
+	uid, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	preferences, err := h.service.GetPreferences(r.Context(), uid)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &preferences
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
+}
+
+func (h *Handler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	localizer := i18n.FromContext(r.Context())
+
+	var payload UserPreferencesPayload
+	var resp UserPreferencesResponse
+
+	uid, ok := middleware.AuthSubject(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err := request.DecodeJSON(w, r, &payload)
+	if err != nil {
+		servicebase.WriteError(w, r, servicebase.ErrFailedDecodeJSON.WithCause(err))
+		return
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	preferences, err := h.service.UpdatePreferences(r.Context(), uid, payload)
+	if err != nil {
+		servicebase.WriteError(w, r, err)
+		return
+	}
+
+	resp.Message = localizer.T(servicebase.MessageSuccess)
+	resp.Code = servicebase.CodeSuccess
+	resp.Data = &preferences
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Msgf("error encoding response body: %v", err)
+	}
 }
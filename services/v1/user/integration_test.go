@@ -5,15 +5,22 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
 	dbtest "github.com/farolinar/dealls-bumble/internal/common/db/test"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
 	"github.com/farolinar/dealls-bumble/internal/common/jwt"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
 	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
 	_ "github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
@@ -45,7 +52,7 @@ func TestUser_Integration_CreateUserSuccess(t *testing.T) {
 	})
 
 	userRepo := NewRepository(db)
-	userService := NewService(cfg, userRepo)
+	userService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 	userHandler := NewHandler(cfg, userService)
 
 	// serviceData, err := userService.Create(ctx, getUserCreatePayload())
@@ -107,7 +114,7 @@ func TestUser_Integration_CreateUserErrAlreadyExists(t *testing.T) {
 	})
 
 	userRepo := NewRepository(db)
-	userService := NewService(cfg, userRepo)
+	userService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 	_, err = userService.Create(ctx, getUserCreatePayload())
 	assert.NoError(t, err)
@@ -142,7 +149,7 @@ func TestUser_Integration_LoginSuccess(t *testing.T) {
 	})
 
 	userRepo := NewRepository(db)
-	userService := NewService(cfg, userRepo)
+	userService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 	userHandler := NewHandler(cfg, userService)
 
 	// inject user data
@@ -202,7 +209,7 @@ func TestUser_Integration_LoginErrUserNotExists(t *testing.T) {
 	})
 
 	userRepo := NewRepository(db)
-	userService := NewService(cfg, userRepo)
+	userService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 	userHandler := NewHandler(cfg, userService)
 
 	// inject user data
@@ -228,5 +235,129 @@ func TestUser_Integration_LoginErrUserNotExists(t *testing.T) {
 	if err != nil {
 		t.Errorf("error unmarshaling resp json: %v", err)
 	}
-	assert.Equal(t, servicebase.Code4XX, resp.Code)
+	assert.Equal(t, ErrUsernameNotFound.Code, resp.Code)
+}
+
+func TestUser_Integration_CreateMany(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	userRepo := NewRepository(db)
+	hashedPassword := "hashed"
+	maxAge := 30
+	users := []User{
+		{UID: "bulk_user_1", Name: "Bulk One", Email: "bulk1@example.com", Username: "bulk_one", HashedPassword: &hashedPassword,
+			Gender: GenderWoman, ShowGender: true, Birthdate: clock.System().Now().AddDate(-25, 0, 0),
+			Location: &Location{Latitude: -6.2, Longitude: 106.8}},
+		{UID: "bulk_user_2", Name: "Bulk Two", Email: "bulk2@example.com", Username: "bulk_two", HashedPassword: &hashedPassword,
+			Gender: GenderNonBinary, Birthdate: clock.System().Now().AddDate(-30, 0, 0)},
+	}
+
+	created, err := userRepo.CreateMany(ctx, users)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), created)
+
+	// rows already there are skipped
+	created, err = userRepo.CreateMany(ctx, users)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), created)
+
+	err = userRepo.CreateManyPreferences(ctx, []UserPreferences{{UID: "bulk_user_1", Preferences: Preferences{MaxAge: &maxAge}}})
+	assert.NoError(t, err)
+	err = userRepo.CreateManyImages(ctx, []Image{{UserUID: "bulk_user_2", Title: "Photo 1", URL: "users/bulk_user_2/photos/1.png"}})
+	assert.NoError(t, err)
+
+	user, err := userRepo.GetByUID(ctx, "bulk_user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "bulk_one", user.Username)
+	assert.NotNil(t, user.Location)
+
+	preferences, err := userRepo.GetPreferences(ctx, "bulk_user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, &maxAge, preferences.MaxAge)
+}
+
+func TestUser_Integration_WithinTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	userRepo := NewRepository(db)
+	manager := txn.NewTxManager(db)
+	hashedPassword := "hashed"
+	newUser := func(uid string) *User {
+		return &User{UID: uid, Name: "Tx " + uid, Email: uid + "@example.com", Username: uid, HashedPassword: &hashedPassword,
+			Gender: GenderAgender, Birthdate: clock.System().Now().AddDate(-20, 0, 0)}
+	}
+	failed := errors.New("failed")
+
+	err = manager.WithinTx(ctx, func(ctx context.Context) error {
+		err := userRepo.Create(ctx, newUser("tx_rolled_back_1"))
+		if err != nil {
+			return err
+		}
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+	_, err = userRepo.GetByUID(ctx, "tx_rolled_back_1")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = manager.WithinTx(ctx, func(ctx context.Context) error {
+		err := userRepo.Create(ctx, newUser("tx_committed_001"))
+		if err != nil {
+			return err
+		}
+
+		// only the savepoint is rolled back
+		err = manager.WithinTx(ctx, func(ctx context.Context) error {
+			err := userRepo.Create(ctx, newUser("tx_rolled_back_2"))
+			if err != nil {
+				return err
+			}
+			return failed
+		})
+		assert.ErrorIs(t, err, failed)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	_, err = userRepo.GetByUID(ctx, "tx_committed_001")
+	assert.NoError(t, err)
+	_, err = userRepo.GetByUID(ctx, "tx_rolled_back_2")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
 }
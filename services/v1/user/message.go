@@ -1,22 +1,24 @@
 package userv1
 
-import servicebase "github.com/farolinar/dealls-bumble/services/base"
+import (
+	"embed"
 
-var (
-	MessageNotFound         = "User not found"
-	MessageWrongPassword    = "Wrong password"
-	MessageAlreadyExists    = "User already exists"
-	MessageValidationFailed = "Validation failed"
-	MessageMustAbove18      = "Age must above 18"
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
 )
 
-func Translate(lang string) {
-	switch lang {
-	case servicebase.ID_LANG:
-		MessageNotFound = "User tidak ditemukan"
-		MessageWrongPassword = "Password salah"
-		MessageAlreadyExists = "User sudah pernah dibuat"
-		MessageValidationFailed = "Validasi gagal"
-		MessageMustAbove18 = "Umur harus di atas 18 tahun"
-	}
+//go:embed locales/*.json
+var locales embed.FS
+
+func init() {
+	i18n.MustLoadFS(locales, "locales")
 }
+
+// Message IDs, the text of each language lives in locales/<lang>.json
+var (
+	MessageNotFound           = "user.not_found"
+	MessageWrongPassword      = "user.wrong_password"
+	MessageAlreadyExists      = "user.already_exists"
+	MessageMaxAgeBelowMinAge  = "user.max_age_below_min_age"
+	MessageTravelModeDisabled = "user.travel_mode_disabled"
+	MessageAdminRequired      = "user.admin_required"
+)
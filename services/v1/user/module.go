@@ -0,0 +1,35 @@
+package userv1
+
+import (
+	"net/http"
+
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
+	"github.com/farolinar/dealls-bumble/internal/container"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	"github.com/gorilla/mux"
+)
+
+// Module registers the user routes under /v1/user
+type Module struct{}
+
+func (Module) Name() string {
+	return "user"
+}
+
+func (Module) Register(app *container.App, r *mux.Router) {
+	cfg := app.Config
+	service := NewService(cfg, app.Clock, app.Settings, app.IDs, app.Tx, app.Outbox, NewReplicatedRepository(app.Replicas), premiumv1.NewReplicatedRepository(app.Replicas))
+	handler := NewHandler(cfg, service)
+
+	ur := r.PathPrefix("/v1/user").Subrouter()
+	ur.HandleFunc("/register", handler.CreateUser).Methods(http.MethodPost)
+	ur.HandleFunc("/login", handler.Login).Methods(http.MethodPost)
+	ur.HandleFunc("/profile", middleware.Authorize(cfg, handler.GetProfile)).Methods(http.MethodGet)
+	ur.HandleFunc("/profile/location", middleware.Authorize(cfg, handler.UpdateLocation)).Methods(http.MethodPut)
+	ur.HandleFunc("/profile/travel", middleware.Authorize(cfg, handler.StartTravel)).Methods(http.MethodPut)
+	ur.HandleFunc("/profile/travel", middleware.Authorize(cfg, handler.StopTravel)).Methods(http.MethodDelete)
+	ur.HandleFunc("/profile/preferences", middleware.Authorize(cfg, handler.GetPreferences)).Methods(http.MethodGet)
+	ur.HandleFunc("/profile/preferences", middleware.Authorize(cfg, handler.UpdatePreferences)).Methods(http.MethodPut)
+	// admin only
+	ur.HandleFunc("/admin/users/{uid}", middleware.Authorize(cfg, handler.GetUser)).Methods(http.MethodGet)
+}
@@ -3,44 +3,396 @@ package userv1
 import (
 	"context"
 	"database/sql"
+	"strings"
+
+	"github.com/farolinar/dealls-bumble/internal/common/pgcopy"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
 )
 
 type Repository interface {
 	Create(ctx context.Context, user *User) (err error)
 	GetByUsername(ctx context.Context, username string) (user User, err error)
+	GetByUID(ctx context.Context, uid string) (user User, err error)
+	UpdateLocation(ctx context.Context, uid string, location Location) (err error)
+	SetTravel(ctx context.Context, uid string, travel Travel) (err error)
+	ClearTravel(ctx context.Context, uid string) (err error)
+	GetPreferences(ctx context.Context, uid string) (preferences Preferences, err error)
+	UpsertPreferences(ctx context.Context, uid string, preferences Preferences) (err error)
+	UpdatePassword(ctx context.Context, uid string, hashedPassword string) (err error)
+	CreateMany(ctx context.Context, users []User) (created int64, err error)
+	CreateManyPreferences(ctx context.Context, preferences []UserPreferences) (err error)
+	CreateManyImages(ctx context.Context, images []Image) (err error)
 }
 
 type dbRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	reads *replica.Set
 }
 
 func NewRepository(db *sql.DB) Repository {
-	return &dbRepository{db: db}
+	return NewReplicatedRepository(replica.Single(db))
+}
+
+// NewReplicatedRepository writes to the primary of reads and reads from its
+// replicas
+func NewReplicatedRepository(reads *replica.Set) Repository {
+	return &dbRepository{db: reads.Primary(), reads: reads}
+}
+
+// writer returns the transaction of ctx when the call runs within one. The
+// write is recorded so the rest of the request reads from the primary.
+func (d *dbRepository) writer(ctx context.Context) txn.Querier {
+	replica.MarkWrite(ctx)
+	return txn.From(ctx, d.db)
+}
+
+// reader returns the transaction of ctx when the call runs within one, a
+// replica otherwise
+func (d *dbRepository) reader(ctx context.Context) txn.Querier {
+	if txn.InTx(ctx) {
+		return txn.From(ctx, d.db)
+	}
+	return d.reads.Reader(ctx)
 }
 
 func (d *dbRepository) Create(ctx context.Context, user *User) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.Create")
+	defer tracing.EndQuery(span, &err)
+
 	q := `
-        INSERT INTO dealls_bumble.users (uid, name, email, username, hashed_password, sex, birthdate)
-        VALUES ($1, $2, $3, $4, $5, $6, $7);
+        INSERT INTO dealls_bumble.users (uid, name, email, username, hashed_password, gender, show_gender, sex, birthdate, is_admin)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
     `
-	_, err = d.db.ExecContext(ctx, q,
-		user.UID, user.Name, user.Email, user.Username, user.HashedPassword, user.Sex,
-		user.Birthdate)
+	_, err = d.writer(ctx).ExecContext(ctx, q,
+		user.UID, user.Name, user.Email, user.Username, user.HashedPassword, user.Gender, user.ShowGender,
+		user.Sex, user.Birthdate, user.Admin)
 
 	return
 }
 
 func (d dbRepository) GetByUsername(ctx context.Context, username string) (user User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.GetByUsername")
+	defer tracing.EndQuery(span, &err)
+
 	q := `
-        SELECT uid, name, email, username, hashed_password, sex, birthdate, created_at
+        SELECT uid, name, email, username, hashed_password, gender, show_gender, sex, birthdate, is_admin, created_at
         FROM dealls_bumble.users
         WHERE username = $1;
     `
-	row := d.db.QueryRowContext(ctx, q, username)
+	row := d.reader(ctx).QueryRowContext(ctx, q, username)
 	err = row.Scan(&user.UID, &user.Name, &user.Email, &user.Username, &user.HashedPassword,
-		&user.Sex, &user.Birthdate, &user.CreatedAt)
+		&user.Gender, &user.ShowGender, &user.Sex, &user.Birthdate, &user.Admin, &user.CreatedAt)
 	// if err == sql.ErrNoRows {
 	//     return nil, ErrNotFound
 	// }
 	return
 }
+
+// GetByUID returns the travel as stored, expired or not, see
+// User.EffectiveLocation
+func (d *dbRepository) GetByUID(ctx context.Context, uid string) (user User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.GetByUID")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        SELECT uid, name, email, username, gender, show_gender, sex, birthdate, verified, latitude, longitude,
+            travel_latitude, travel_longitude, travel_expires_at, is_admin, created_at
+        FROM dealls_bumble.users
+        WHERE uid = $1 AND NOT is_deleted;
+    `
+	var latitude, longitude, travelLatitude, travelLongitude sql.NullFloat64
+	var travelExpiresAt sql.NullTime
+	row := d.reader(ctx).QueryRowContext(ctx, q, uid)
+	err = row.Scan(&user.UID, &user.Name, &user.Email, &user.Username, &user.Gender, &user.ShowGender,
+		&user.Sex, &user.Birthdate, &user.Verified, &latitude, &longitude, &travelLatitude, &travelLongitude, &travelExpiresAt,
+		&user.Admin, &user.CreatedAt)
+	if err != nil {
+		return
+	}
+
+	if latitude.Valid && longitude.Valid {
+		user.Location = &Location{Latitude: latitude.Float64, Longitude: longitude.Float64}
+	}
+	if travelLatitude.Valid && travelLongitude.Valid && travelExpiresAt.Valid {
+		user.Travel = &Travel{
+			Location:  Location{Latitude: travelLatitude.Float64, Longitude: travelLongitude.Float64},
+			ExpiresAt: travelExpiresAt.Time,
+		}
+	}
+	return
+}
+
+// UpdateLocation sets the real location and appends it to the location
+// history. Travel locations are never written to the history.
+func (d *dbRepository) UpdateLocation(ctx context.Context, uid string, location Location) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.UpdateLocation")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        WITH updated AS (
+            UPDATE dealls_bumble.users
+            SET latitude = $2, longitude = $3, location_updated_at = current_timestamp
+            WHERE uid = $1 AND NOT is_deleted
+            RETURNING id, latitude, longitude
+        )
+        INSERT INTO dealls_bumble.user_location_history (user_id, latitude, longitude)
+        SELECT id, latitude, longitude FROM updated;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, q, uid, location.Latitude, location.Longitude)
+	if err != nil {
+		return
+	}
+
+	return requireAffected(res)
+}
+
+func (d *dbRepository) SetTravel(ctx context.Context, uid string, travel Travel) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.SetTravel")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        UPDATE dealls_bumble.users
+        SET travel_latitude = $2, travel_longitude = $3, travel_expires_at = $4
+        WHERE uid = $1 AND NOT is_deleted;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, q, uid, travel.Location.Latitude, travel.Location.Longitude, travel.ExpiresAt)
+	if err != nil {
+		return
+	}
+
+	return requireAffected(res)
+}
+
+func (d *dbRepository) ClearTravel(ctx context.Context, uid string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.ClearTravel")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        UPDATE dealls_bumble.users
+        SET travel_latitude = NULL, travel_longitude = NULL, travel_expires_at = NULL
+        WHERE uid = $1 AND NOT is_deleted;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, q, uid)
+	if err != nil {
+		return
+	}
+
+	return requireAffected(res)
+}
+
+func (d *dbRepository) GetPreferences(ctx context.Context, uid string) (preferences Preferences, err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.GetPreferences")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        SELECT array_to_string(p.interested_in, ','), p.min_age, p.max_age, p.max_distance_km,
+            array_to_string(p.dealbreakers, ',')
+        FROM dealls_bumble.users u
+        LEFT JOIN dealls_bumble.user_preferences p ON p.user_id = u.id
+        WHERE u.uid = $1 AND NOT u.is_deleted;
+    `
+	var interestedIn, dealbreakers sql.NullString
+	row := d.reader(ctx).QueryRowContext(ctx, q, uid)
+	err = row.Scan(&interestedIn, &preferences.MinAge, &preferences.MaxAge, &preferences.MaxDistanceKm,
+		&dealbreakers)
+	if err != nil {
+		return
+	}
+
+	preferences.InterestedIn = splitList[GenderGroup](interestedIn)
+	preferences.Dealbreakers = splitList[PreferenceKey](dealbreakers)
+	return
+}
+
+func (d *dbRepository) UpsertPreferences(ctx context.Context, uid string, preferences Preferences) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.UpsertPreferences")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        INSERT INTO dealls_bumble.user_preferences (user_id, interested_in, min_age, max_age, max_distance_km, dealbreakers)
+        SELECT id, string_to_array($2, ','), $3, $4, $5, string_to_array($6, ',')
+        FROM dealls_bumble.users
+        WHERE uid = $1 AND NOT is_deleted
+        ON CONFLICT (user_id) DO UPDATE
+        SET interested_in = EXCLUDED.interested_in, min_age = EXCLUDED.min_age, max_age = EXCLUDED.max_age,
+            max_distance_km = EXCLUDED.max_distance_km, dealbreakers = EXCLUDED.dealbreakers,
+            updated_at = current_timestamp;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, q, uid, joinList(preferences.InterestedIn), preferences.MinAge,
+		preferences.MaxAge, preferences.MaxDistanceKm, joinList(preferences.Dealbreakers))
+	if err != nil {
+		return
+	}
+
+	return requireAffected(res)
+}
+
+func (d *dbRepository) UpdatePassword(ctx context.Context, uid string, hashedPassword string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.UpdatePassword")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        UPDATE dealls_bumble.users
+        SET hashed_password = $2
+        WHERE uid = $1 AND NOT is_deleted;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, q, uid, hashedPassword)
+	if err != nil {
+		return
+	}
+
+	return requireAffected(res)
+}
+
+// CreateMany creates users with COPY, together with the history of their
+// locations, and returns how many were created. Users whose UID, email or
+// username is taken are skipped.
+func (d *dbRepository) CreateMany(ctx context.Context, users []User) (created int64, err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.CreateMany")
+	defer tracing.EndQuery(span, &err)
+
+	rows := make([][]any, len(users))
+	for i, user := range users {
+		var latitude, longitude *float64
+		if user.Location != nil {
+			latitude, longitude = &user.Location.Latitude, &user.Location.Longitude
+		}
+		rows[i] = []any{user.UID, user.Name, user.Email, user.Username, user.HashedPassword, string(user.Gender),
+			user.ShowGender, (*string)(user.Sex), user.Birthdate, user.Admin, latitude, longitude}
+	}
+
+	replica.MarkWrite(ctx)
+	return pgcopy.Run(ctx, d.db, pgcopy.Load{
+		Staging: "staging_users",
+		Columns: []pgcopy.Column{
+			{Name: "uid", Type: "text"}, {Name: "name", Type: "text"}, {Name: "email", Type: "text"},
+			{Name: "username", Type: "text"}, {Name: "hashed_password", Type: "text"}, {Name: "gender", Type: "text"},
+			{Name: "show_gender", Type: "bool"}, {Name: "sex", Type: "text"}, {Name: "birthdate", Type: "timestamp"},
+			{Name: "is_admin", Type: "bool"}, {Name: "latitude", Type: "double precision"},
+			{Name: "longitude", Type: "double precision"},
+		},
+		Rows: rows,
+		Statements: []string{`
+            INSERT INTO dealls_bumble.users (uid, name, email, username, hashed_password, gender, show_gender, sex,
+                birthdate, is_admin, latitude, longitude, location_updated_at)
+            SELECT uid, name, email, username, hashed_password::bytea, gender, show_gender, sex::sex,
+                birthdate, is_admin, latitude, longitude, CASE WHEN latitude IS NOT NULL THEN current_timestamp END
+            FROM staging_users
+            ON CONFLICT DO NOTHING;
+        `, `
+            INSERT INTO dealls_bumble.user_location_history (user_id, latitude, longitude)
+            SELECT u.id, u.latitude, u.longitude
+            FROM staging_users s
+            JOIN dealls_bumble.users u ON u.uid = s.uid AND u.username = s.username
+            WHERE u.latitude IS NOT NULL
+                AND NOT EXISTS (SELECT 1 FROM dealls_bumble.user_location_history h WHERE h.user_id = u.id);
+        `},
+	})
+}
+
+// CreateManyPreferences upserts the preferences of many users with COPY,
+// unknown UIDs are skipped
+func (d *dbRepository) CreateManyPreferences(ctx context.Context, preferences []UserPreferences) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.CreateManyPreferences")
+	defer tracing.EndQuery(span, &err)
+
+	rows := make([][]any, len(preferences))
+	for i, p := range preferences {
+		rows[i] = []any{p.UID, joinList(p.InterestedIn), p.MinAge, p.MaxAge, p.MaxDistanceKm, joinList(p.Dealbreakers)}
+	}
+
+	replica.MarkWrite(ctx)
+	_, err = pgcopy.Run(ctx, d.db, pgcopy.Load{
+		Staging: "staging_user_preferences",
+		Columns: []pgcopy.Column{
+			{Name: "uid", Type: "text"}, {Name: "interested_in", Type: "text"}, {Name: "min_age", Type: "int"},
+			{Name: "max_age", Type: "int"}, {Name: "max_distance_km", Type: "int"}, {Name: "dealbreakers", Type: "text"},
+		},
+		Rows: rows,
+		Statements: []string{`
+            INSERT INTO dealls_bumble.user_preferences (user_id, interested_in, min_age, max_age, max_distance_km, dealbreakers)
+            SELECT u.id, string_to_array(s.interested_in, ','), s.min_age, s.max_age, s.max_distance_km,
+                string_to_array(s.dealbreakers, ',')
+            FROM staging_user_preferences s
+            JOIN dealls_bumble.users u ON u.uid = s.uid AND NOT u.is_deleted
+            ON CONFLICT (user_id) DO UPDATE
+            SET interested_in = EXCLUDED.interested_in, min_age = EXCLUDED.min_age, max_age = EXCLUDED.max_age,
+                max_distance_km = EXCLUDED.max_distance_km, dealbreakers = EXCLUDED.dealbreakers,
+                updated_at = current_timestamp;
+        `},
+	})
+	return
+}
+
+// CreateManyImages records many images with COPY, images of unknown UIDs or
+// already recorded at the same URL are skipped
+func (d *dbRepository) CreateManyImages(ctx context.Context, images []Image) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "user.CreateManyImages")
+	defer tracing.EndQuery(span, &err)
+
+	rows := make([][]any, len(images))
+	for i, image := range images {
+		rows[i] = []any{image.UserUID, image.Title, image.URL}
+	}
+
+	replica.MarkWrite(ctx)
+	_, err = pgcopy.Run(ctx, d.db, pgcopy.Load{
+		Staging: "staging_user_images",
+		Columns: []pgcopy.Column{{Name: "uid", Type: "text"}, {Name: "title", Type: "text"}, {Name: "url", Type: "text"}},
+		Rows:    rows,
+		Statements: []string{`
+            INSERT INTO dealls_bumble.user_images (user_id, title, url)
+            SELECT u.id, s.title, s.url
+            FROM staging_user_images s
+            JOIN dealls_bumble.users u ON u.uid = s.uid AND NOT u.is_deleted
+            WHERE NOT EXISTS (
+                SELECT 1 FROM dealls_bumble.user_images i
+                WHERE i.user_id = u.id AND i.url = s.url AND NOT i.is_deleted
+            );
+        `},
+	})
+	return
+}
+
+// joinList and splitList pass enum lists to postgres arrays as comma
+// separated text, an empty list is stored as NULL
+func joinList[T ~string](values []T) *string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = string(value)
+	}
+	joined := strings.Join(parts, ",")
+	return &joined
+}
+
+func splitList[T ~string](value sql.NullString) []T {
+	if !value.Valid || value.String == "" {
+		return []T{}
+	}
+
+	parts := strings.Split(value.String, ",")
+	values := make([]T, len(parts))
+	for i, part := range parts {
+		values[i] = T(part)
+	}
+	return values
+}
+
+// requireAffected reports sql.ErrNoRows when a write matched no user
+func requireAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -1,8 +1,7 @@
 package userv1
 
 import (
-	"fmt"
-
+	"github.com/farolinar/dealls-bumble/internal/common/geo"
 	"github.com/farolinar/dealls-bumble/internal/common/parser"
 	servicebase "github.com/farolinar/dealls-bumble/services/base"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -15,13 +14,25 @@ var (
 
 	MinUsername = 3
 	MaxUsername = 30
+
+	MinMaxDistanceKm = 1
+	MaxMaxDistanceKm = 500
+
+	MaxPreferredAge = 100
+
+	MinTravelHours = 1
+	MaxTravelHours = 7 * 24
 )
 
 type UserCreatePayload struct {
-	Name       string `json:"name"`
-	Email      string `json:"email"`
-	Username   string `json:"username"`
-	Password   string `json:"password"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Gender   Gender `json:"gender"`
+	// ShowGender defaults to true when omitted
+	ShowGender *bool `json:"show_gender"`
+	// Sex is accepted for older clients when gender is not sent
 	Sex        Sex    `json:"sex"`
 	Birthdate  string `json:"birthdate"`
 	TimeLayout string `json:"-"`
@@ -33,6 +44,8 @@ func (p UserCreatePayload) NewLayoutDateOnly() UserCreatePayload {
 		Email:      p.Email,
 		Username:   p.Username,
 		Password:   p.Password,
+		Gender:     p.Gender,
+		ShowGender: p.ShowGender,
 		Sex:        p.Sex,
 		Birthdate:  p.Birthdate,
 		TimeLayout: parser.LayoutDateOnly,
@@ -40,20 +53,28 @@ func (p UserCreatePayload) NewLayoutDateOnly() UserCreatePayload {
 }
 
 func (p UserCreatePayload) Validate() error {
-	if !servicebase.MustAbove18Rule(p.Birthdate, p.TimeLayout) {
-		return fmt.Errorf(fmt.Sprintf("birthdate: %s", MessageMustAbove18))
-	}
-
+	mapping := GetGenderMapping()
 	return validation.ValidateStruct(&p,
 		validation.Field(&p.Name, validation.Required, validation.Length(MinName, MaxName)),
 		validation.Field(&p.Email, validation.Required, is.Email),
 		validation.Field(&p.Username, validation.Required, validation.Length(MinUsername, MaxUsername)),
 		validation.Field(&p.Password, validation.Required, servicebase.PasswordValidationRule),
-		validation.Field(&p.Sex, validation.Required, validation.In(SexList...)),
-		validation.Field(&p.Birthdate, validation.Required, validation.Date(p.TimeLayout)),
+		validation.Field(&p.Gender, validation.Required.When(p.Sex == ""), validation.In(mapping.GenderList()...)),
+		validation.Field(&p.Sex, validation.In(SexList...)),
+		validation.Field(&p.Birthdate, validation.Required, validation.Date(p.TimeLayout), servicebase.MustAbove18Rule(p.TimeLayout)),
 	)
 }
 
+// GenderIdentity returns the gender sent by the client, falling back to the
+// identity mapped from the legacy sex field
+func (p UserCreatePayload) GenderIdentity() Gender {
+	if p.Gender != "" {
+		return p.Gender
+	}
+
+	return GetGenderMapping().LegacySex[p.Sex]
+}
+
 type UserLoginPayload struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -65,3 +86,91 @@ func (p UserLoginPayload) Validate() error {
 		validation.Field(&p.Password, validation.Required),
 	)
 }
+
+// UserResetPasswordPayload is read by the reset-password command, no route
+// accepts it
+type UserResetPasswordPayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (p UserResetPasswordPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Username, validation.Required),
+		validation.Field(&p.Password, validation.Required, servicebase.PasswordValidationRule),
+	)
+}
+
+type UserLocationPayload struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+func (p UserLocationPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Latitude, validation.NotNil, validation.Min(geo.MinLatitude), validation.Max(geo.MaxLatitude)),
+		validation.Field(&p.Longitude, validation.NotNil, validation.Min(geo.MinLongitude), validation.Max(geo.MaxLongitude)),
+	)
+}
+
+func (p UserLocationPayload) Location() Location {
+	return Location{Latitude: *p.Latitude, Longitude: *p.Longitude}
+}
+
+type UserTravelPayload struct {
+	UserLocationPayload
+	DurationHours int `json:"duration_hours"`
+}
+
+func (p UserTravelPayload) Validate() error {
+	err := p.UserLocationPayload.Validate()
+	if err != nil {
+		return err
+	}
+
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.DurationHours, validation.Required, validation.Min(MinTravelHours), validation.Max(MaxTravelHours)),
+	)
+}
+
+type UserPreferencesPayload struct {
+	InterestedIn  []GenderGroup   `json:"interested_in"`
+	MinAge        *int            `json:"min_age"`
+	MaxAge        *int            `json:"max_age"`
+	MaxDistanceKm *int            `json:"max_distance_km"`
+	Dealbreakers  []PreferenceKey `json:"dealbreakers"`
+}
+
+func (p UserPreferencesPayload) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.InterestedIn, validation.Each(validation.In(GetGenderMapping().GroupList()...))),
+		validation.Field(&p.MinAge, validation.Min(servicebase.MinAge), validation.Max(MaxPreferredAge)),
+		validation.Field(&p.MaxAge, validation.Min(servicebase.MinAge), validation.Max(MaxPreferredAge),
+			validation.When(p.MinAge != nil && p.MaxAge != nil, validation.By(func(interface{}) error {
+				if *p.MaxAge < *p.MinAge {
+					return ErrMaxAgeBelowMinAge
+				}
+				return nil
+			}))),
+		validation.Field(&p.MaxDistanceKm, validation.Min(MinMaxDistanceKm), validation.Max(MaxMaxDistanceKm)),
+		validation.Field(&p.Dealbreakers, validation.Each(validation.In(PreferenceKeyList...))),
+	)
+}
+
+func (p UserPreferencesPayload) Preferences() Preferences {
+	preferences := Preferences{
+		InterestedIn:  p.InterestedIn,
+		MinAge:        p.MinAge,
+		MaxAge:        p.MaxAge,
+		MaxDistanceKm: p.MaxDistanceKm,
+		Dealbreakers:  p.Dealbreakers,
+	}
+	if preferences.InterestedIn == nil {
+		preferences.InterestedIn = []GenderGroup{}
+	}
+	if preferences.Dealbreakers == nil {
+		preferences.Dealbreakers = []PreferenceKey{}
+	}
+
+	return preferences
+}
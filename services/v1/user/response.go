@@ -15,3 +15,8 @@ type UserAuthenticationResponse struct {
 type UserAuthentication struct {
 	Token string `json:"token"`
 }
+
+type UserPreferencesResponse struct {
+	servicebase.ResponseBody
+	Data *Preferences `json:"data,omitempty"`
+}
@@ -9,88 +9,181 @@ import (
 
 	"github.com/farolinar/dealls-bumble/config"
 	"github.com/farolinar/dealls-bumble/internal/common/auth"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
 	"github.com/farolinar/dealls-bumble/internal/common/password"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
 	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 type Service interface {
 	Create(ctx context.Context, payload UserCreatePayload) (resp UserAuthentication, err error)
 	Login(ctx context.Context, payload UserLoginPayload) (resp UserAuthentication, err error)
+	CreateAdmin(ctx context.Context, payload UserCreatePayload) (resp User, err error)
+	ResetPassword(ctx context.Context, payload UserResetPasswordPayload) (err error)
+	GetProfile(ctx context.Context, uid string) (resp User, err error)
+	GetUser(ctx context.Context, adminUID, uid string) (resp User, err error)
+	UpdateLocation(ctx context.Context, uid string, payload UserLocationPayload) (resp User, err error)
+	StartTravel(ctx context.Context, uid string, payload UserTravelPayload) (resp User, err error)
+	StopTravel(ctx context.Context, uid string) (resp User, err error)
+	GetPreferences(ctx context.Context, uid string) (resp Preferences, err error)
+	UpdatePreferences(ctx context.Context, uid string, payload UserPreferencesPayload) (resp Preferences, err error)
 }
 
 type userService struct {
-	cfg        config.AppConfig
-	repository Repository
+	cfg               config.AppConfig
+	clock             clock.Clock
+	settings          *settings.Store
+	ids               uid.Generator
+	tx                *txn.TxManager
+	outbox            *events.Outbox
+	repository        Repository
+	premiumRepository premiumv1.Repository
 }
 
-func NewService(cfg config.AppConfig, repository Repository) Service {
-	return &userService{cfg: cfg, repository: repository}
+func NewService(cfg config.AppConfig, clk clock.Clock, store *settings.Store, ids uid.Generator, tx *txn.TxManager, outbox *events.Outbox, repository Repository, premiumRepository premiumv1.Repository) Service {
+	return &userService{
+		cfg:               cfg,
+		clock:             clk,
+		settings:          store,
+		ids:               ids,
+		tx:                tx,
+		outbox:            outbox,
+		repository:        repository,
+		premiumRepository: premiumRepository,
+	}
 }
 
 func (s *userService) Create(ctx context.Context, payload UserCreatePayload) (resp UserAuthentication, err error) {
+	user, err := s.createUser(ctx, payload, false)
+	if err != nil {
+		return
+	}
+	metrics.Registrations.Inc()
+
+	// create access token with signed jwt
+	accessToken, err := auth.CreateAccessToken(s.cfg, fmt.Sprint(user.UID))
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error creating access token: %s", err.Error())
+		return
+	}
+	resp.Token = accessToken
+
+	// TODO: upload image
+
+	return
+}
+
+// CreateAdmin creates a user flagged as admin, it does not count as a
+// registration
+func (s *userService) CreateAdmin(ctx context.Context, payload UserCreatePayload) (resp User, err error) {
+	user, err := s.createUser(ctx, payload, true)
+	if err != nil {
+		return
+	}
+
+	return *user, nil
+}
+
+func (s *userService) createUser(ctx context.Context, payload UserCreatePayload, admin bool) (user *User, err error) {
 	hashedPassword, err := password.Hash(s.cfg.App.BCryptSalt, payload.Password)
 	if err != nil {
-		log.Debug().Msgf("error hashing password: %s", err.Error())
+		zerolog.Ctx(ctx).Debug().Msgf("error hashing password: %s", err.Error())
 		return
 	}
 
 	birthdateTime, err := time.Parse(payload.TimeLayout, payload.Birthdate)
 	if err != nil {
-		log.Debug().Msgf("error parsing birthdate: %s", err.Error())
+		zerolog.Ctx(ctx).Debug().Msgf("error parsing birthdate: %s", err.Error())
 		return
 	}
 
-	user := &User{
-		UID:            uid.GenerateStringID(16),
+	user = &User{
+		UID:            s.ids.Generate(16),
 		Name:           payload.Name,
 		Email:          payload.Email,
 		Username:       payload.Username,
 		HashedPassword: &hashedPassword,
-		Sex:            payload.Sex,
+		Gender:         payload.GenderIdentity(),
+		ShowGender:     payload.ShowGender == nil || *payload.ShowGender,
 		Birthdate:      birthdateTime,
+		Admin:          admin,
 	}
-	err = s.repository.Create(ctx, user)
+	user.Sex = GetGenderMapping().LegacySexOf(user.Gender)
+	// admins are created by hand and are not announced as registrations
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		err := s.repository.Create(ctx, user)
+		if err != nil || admin {
+			return err
+		}
+		return s.outbox.Publish(ctx, events.UserRegistered{UserUID: user.UID, Username: user.Username, Email: user.Email})
+	})
 	var pgErr *pgconn.PgError
 	if err != nil {
-		log.Debug().Msgf("error creating user: %s", err.Error())
+		zerolog.Ctx(ctx).Debug().Msgf("error creating user: %s", err.Error())
 		if errors.As(err, &pgErr) {
 			switch pgErr.Code {
 			case "23505":
 				err = ErrAlreadyExists
 			default:
-				return
+				return nil, err
 			}
 		}
+		return nil, err
+	}
+
+	return
+}
+
+// ResetPassword replaces the password of the user with the username
+func (s *userService) ResetPassword(ctx context.Context, payload UserResetPasswordPayload) (err error) {
+	user, err := s.repository.GetByUsername(ctx, payload.Username)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting user: %v", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
 		return
 	}
 
-	// create access token with signed jwt
-	accessToken, err := auth.CreateAccessToken(s.cfg, fmt.Sprint(user.UID))
+	hashedPassword, err := password.Hash(s.cfg.App.BCryptSalt, payload.Password)
 	if err != nil {
-		log.Debug().Msgf("error creating access token: %s", err.Error())
+		zerolog.Ctx(ctx).Debug().Msgf("error hashing password: %s", err.Error())
 		return
 	}
-	resp.Token = accessToken
 
-	// TODO: upload image
+	err = s.repository.UpdatePassword(ctx, user.UID, hashedPassword)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error updating password: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
+	}
 
 	return
 }
 
 func (s *userService) Login(ctx context.Context, payload UserLoginPayload) (resp UserAuthentication, err error) {
+	defer func() {
+		metrics.Logins.WithLabelValues(loginOutcome(err)).Inc()
+	}()
+
 	user, err := s.repository.GetByUsername(ctx, payload.Username)
 	if err != nil {
-		log.Debug().Msgf("error getting user: %v", err)
+		zerolog.Ctx(ctx).Debug().Msgf("error getting user: %v", err)
 		if err == sql.ErrNoRows {
-			err = ErrNotFound
+			err = ErrUsernameNotFound
 		}
 		return
 	}
 	match, err := password.Matches(payload.Password, *user.HashedPassword)
 	if err != nil {
-		log.Debug().Msgf("error matching password: %v", err)
+		zerolog.Ctx(ctx).Debug().Msgf("error matching password: %v", err)
 		return
 	}
 	if !match {
@@ -101,10 +194,141 @@ func (s *userService) Login(ctx context.Context, payload UserLoginPayload) (resp
 	// create access token with signed jwt
 	accessToken, err := auth.CreateAccessToken(s.cfg, fmt.Sprint(user.UID))
 	if err != nil {
-		log.Debug().Msgf("error creating access token: %v", err)
+		zerolog.Ctx(ctx).Debug().Msgf("error creating access token: %v", err)
 		return
 	}
 
 	resp.Token = accessToken
 	return
 }
+
+// loginOutcome returns the metrics label of a login result
+func loginOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.Is(err, ErrUsernameNotFound):
+		return metrics.OutcomeNotFound
+	case errors.Is(err, ErrWrongPassword):
+		return metrics.OutcomeWrongPassword
+	default:
+		return metrics.OutcomeError
+	}
+}
+
+func (s *userService) GetProfile(ctx context.Context, uid string) (resp User, err error) {
+	resp, err = s.repository.GetByUID(ctx, uid)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting user: %v", err)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	// expired travels are left in the row until the next one
+	if resp.Travel != nil && !resp.Travel.ExpiresAt.After(s.clock.Now()) {
+		resp.Travel = nil
+	}
+	return
+}
+
+// GetUser returns the profile of the user with uid to an admin, other users
+// get ErrAdminRequired
+func (s *userService) GetUser(ctx context.Context, adminUID, uid string) (resp User, err error) {
+	admin, err := s.GetProfile(ctx, adminUID)
+	if err != nil {
+		return
+	}
+	if !admin.Admin {
+		err = ErrAdminRequired
+		return
+	}
+
+	return s.GetProfile(ctx, uid)
+}
+
+func (s *userService) UpdateLocation(ctx context.Context, uid string, payload UserLocationPayload) (resp User, err error) {
+	err = s.repository.UpdateLocation(ctx, uid, payload.Location())
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error updating location: %v", err)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	return s.GetProfile(ctx, uid)
+}
+
+func (s *userService) StartTravel(ctx context.Context, uid string, payload UserTravelPayload) (resp User, err error) {
+	// travels already started run until they expire or are stopped
+	if !s.settings.Get().FeatureEnabled(FeatureTravelMode) {
+		err = ErrTravelModeDisabled
+		return
+	}
+
+	canTravel, err := s.premiumRepository.HasPerk(ctx, uid, premiumv1.PerkTravelMode)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error checking perk: %v", err)
+		return
+	}
+	if !canTravel {
+		err = premiumv1.ErrPerkRequired
+		return
+	}
+
+	err = s.repository.SetTravel(ctx, uid, Travel{
+		Location:  payload.Location(),
+		ExpiresAt: s.clock.Now().Add(time.Duration(payload.DurationHours) * time.Hour),
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error setting travel location: %v", err)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	return s.GetProfile(ctx, uid)
+}
+
+func (s *userService) StopTravel(ctx context.Context, uid string) (resp User, err error) {
+	err = s.repository.ClearTravel(ctx, uid)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error clearing travel location: %v", err)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	return s.GetProfile(ctx, uid)
+}
+
+func (s *userService) GetPreferences(ctx context.Context, uid string) (resp Preferences, err error) {
+	resp, err = s.repository.GetPreferences(ctx, uid)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting preferences: %v", err)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	return
+}
+
+func (s *userService) UpdatePreferences(ctx context.Context, uid string, payload UserPreferencesPayload) (resp Preferences, err error) {
+	resp = payload.Preferences()
+	err = s.repository.UpsertPreferences(ctx, uid, resp)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error updating preferences: %v", err)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	return
+}
@@ -2,16 +2,32 @@ package userv1
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/farolinar/dealls-bumble/config"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/middleware"
 	"github.com/farolinar/dealls-bumble/internal/common/password"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/settings"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
 	servicebase "github.com/farolinar/dealls-bumble/services/base"
+	premiumv1 "github.com/farolinar/dealls-bumble/services/v1/premium"
+	"github.com/gorilla/mux"
 	_ "github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
@@ -20,7 +36,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 	url := "/v1/user/register"
 
 	var validationErrorBody UserAuthenticationResponse
-	validationErrorBody.Code = servicebase.Code4XX
+	validationErrorBody.Code = servicebase.ErrValidationFailed.Code
 
 	type fields struct {
 		svc func() Service
@@ -43,7 +59,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -75,7 +91,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -107,7 +123,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -139,7 +155,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -171,7 +187,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -203,7 +219,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -235,7 +251,7 @@ func TestUser_Unit_CreateUser(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -285,7 +301,7 @@ func TestUser_Unit_Login(t *testing.T) {
 	successBody.Code = servicebase.CodeSuccess
 
 	var validationErrorBody UserAuthenticationResponse
-	validationErrorBody.Code = servicebase.Code4XX
+	validationErrorBody.Code = servicebase.ErrValidationFailed.Code
 
 	type fields struct {
 		svc func() Service
@@ -311,11 +327,11 @@ func TestUser_Unit_Login(t *testing.T) {
 					}
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
-					mocking.ExpectQuery(`SELECT uid, name, email, username, hashed_password, sex, birthdate, created_at
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
+					mocking.ExpectQuery(`SELECT uid, name, email, username, hashed_password, gender, show_gender, sex, birthdate, is_admin, created_at
 					FROM dealls_bumble.users`).WithArgs(user.Username).
-						WillReturnRows(sqlmock.NewRows([]string{"uid", "name", "email", "username", "hashed_password", "sex", "birthdate", "created_at"}).
-							AddRow(user.UID, user.Name, user.Email, user.Username, user.HashedPassword, user.Sex, user.Birthdate, user.CreatedAt))
+						WillReturnRows(sqlmock.NewRows([]string{"uid", "name", "email", "username", "hashed_password", "gender", "show_gender", "sex", "birthdate", "is_admin", "created_at"}).
+							AddRow(user.UID, user.Name, user.Email, user.Username, user.HashedPassword, user.Gender, user.ShowGender, user.Sex, user.Birthdate, user.Admin, user.CreatedAt))
 
 					return mockUserService
 				},
@@ -341,7 +357,7 @@ func TestUser_Unit_Login(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -367,7 +383,7 @@ func TestUser_Unit_Login(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -393,7 +409,7 @@ func TestUser_Unit_Login(t *testing.T) {
 					db, _, _ := sqlmock.New()
 					userRepo := NewRepository(db)
 					cfg := getConfig()
-					mockUserService := NewService(cfg, userRepo)
+					mockUserService := NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), userRepo, premiumv1.NewRepository(db))
 
 					return mockUserService
 				},
@@ -436,6 +452,758 @@ func TestUser_Unit_Login(t *testing.T) {
 	}
 }
 
+func TestUser_Unit_UpdateLocation(t *testing.T) {
+	user, err := getTestUserEntity()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	url := "/v1/user/profile/location"
+
+	tests := []struct {
+		name       string
+		svc        func() Service
+		payload    string
+		code       string
+		httpStatus int
+	}{
+		{
+			name: "Success update location - returns 200",
+			svc: func() Service {
+				db, mocking, err := sqlmock.New()
+				if err != nil {
+					t.Fatalf("error creating mock: %v", err)
+				}
+				mocking.ExpectExec(`UPDATE dealls_bumble.users`).WithArgs(user.UID, -6.2, 106.8).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mocking.ExpectQuery(`SELECT uid, name, email, username, gender, show_gender, sex, birthdate, verified, latitude, longitude`).
+					WithArgs(user.UID).
+					WillReturnRows(getProfileRows().
+						AddRow(user.UID, user.Name, user.Email, user.Username, user.Gender, user.ShowGender, user.Sex, user.Birthdate, false, -6.2, 106.8, nil, nil, nil, false, user.CreatedAt))
+
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"latitude": -6.2, "longitude": 106.8}`,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name: "Validation latitude error - returns 400",
+			svc: func() Service {
+				db, _, _ := sqlmock.New()
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"latitude": 91, "longitude": 106.8}`,
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Validation missing longitude error - returns 400",
+			svc: func() Service {
+				db, _, _ := sqlmock.New()
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"latitude": 0}`,
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "User not found - returns 404",
+			svc: func() Service {
+				db, mocking, err := sqlmock.New()
+				if err != nil {
+					t.Fatalf("error creating mock: %v", err)
+				}
+				mocking.ExpectExec(`UPDATE dealls_bumble.users`).WithArgs(user.UID, 0.0, 0.0).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"latitude": 0, "longitude": 0}`,
+			code:       ErrNotFound.Code,
+			httpStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Handler{
+				service: tt.svc(),
+			}
+
+			req, err := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextAuthKey{}, user.UID))
+
+			requestRecorder := httptest.NewRecorder()
+			c.UpdateLocation(requestRecorder, req)
+			var resp UserResponse
+			err = json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+
+			if resp.Code == servicebase.CodeSuccess {
+				assert.Equal(t, &Location{Latitude: -6.2, Longitude: 106.8}, resp.Data.Location)
+			}
+		})
+	}
+}
+
+func TestUser_Unit_StartTravel(t *testing.T) {
+	user, err := getTestUserEntity()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	url := "/v1/user/profile/travel"
+	payload := `{"latitude": 48.8566, "longitude": 2.3522, "duration_hours": 24}`
+	now := time.Date(2024, 5, 26, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		disabled   bool
+		hasPerk    bool
+		code       string
+		httpStatus int
+	}{
+		{
+			name:       "With travel_mode perk - returns 200",
+			hasPerk:    true,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name:       "Without travel_mode perk - returns 403",
+			hasPerk:    false,
+			code:       premiumv1.ErrPerkRequired.Code,
+			httpStatus: http.StatusForbidden,
+		},
+		{
+			name:       "With travel_mode feature flag off - returns 403",
+			disabled:   true,
+			code:       ErrTravelModeDisabled.Code,
+			httpStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mocking, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating mock: %v", err)
+			}
+			store := settings.NewStore(settings.Defaults())
+			if tt.disabled {
+				next := settings.Defaults()
+				next.Features = map[string]bool{FeatureTravelMode: false}
+				assert.NoError(t, store.Update(next))
+			} else {
+				mocking.ExpectQuery(`SELECT EXISTS`).WithArgs(user.UID, string(premiumv1.PerkTravelMode)).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.hasPerk))
+			}
+			if tt.hasPerk {
+				// the travel location must never reach the location history
+				mocking.ExpectExec(`UPDATE dealls_bumble.users\s+SET travel_latitude`).
+					WithArgs(user.UID, 48.8566, 2.3522, now.Add(24*time.Hour)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mocking.ExpectQuery(`SELECT uid, name, email, username, gender, show_gender, sex, birthdate, verified, latitude, longitude`).
+					WithArgs(user.UID).
+					WillReturnRows(getProfileRows().
+						AddRow(user.UID, user.Name, user.Email, user.Username, user.Gender, user.ShowGender, user.Sex, user.Birthdate, false, -6.2, 106.8,
+							48.8566, 2.3522, now.Add(24*time.Hour), false, user.CreatedAt))
+			}
+
+			c := &Handler{
+				service: NewService(getConfig(), clock.Fixed(now), store, uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), premiumv1.NewRepository(db)),
+			}
+
+			req, err := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextAuthKey{}, user.UID))
+
+			requestRecorder := httptest.NewRecorder()
+			c.StartTravel(requestRecorder, req)
+			var resp UserResponse
+			err = json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.NoError(t, mocking.ExpectationsWereMet())
+
+			if resp.Code == servicebase.CodeSuccess {
+				assert.Equal(t, &Location{Latitude: -6.2, Longitude: 106.8}, resp.Data.Location)
+				assert.Equal(t, Location{Latitude: 48.8566, Longitude: 2.3522}, resp.Data.Travel.Location)
+			}
+		})
+	}
+}
+
+func TestUser_Unit_GetUser(t *testing.T) {
+	user, err := getTestUserEntity()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	url := "/v1/user/admin/users/target"
+	now := time.Date(2024, 5, 26, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		admin      bool
+		code       string
+		httpStatus int
+	}{
+		{
+			name:       "Admin - returns 200",
+			admin:      true,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name:       "Not an admin - returns 403",
+			admin:      false,
+			code:       ErrAdminRequired.Code,
+			httpStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mocking, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating mock: %v", err)
+			}
+			mocking.ExpectQuery(`SELECT uid, name, email, username, gender, show_gender, sex, birthdate, verified, latitude, longitude`).
+				WithArgs(user.UID).
+				WillReturnRows(getProfileRows().
+					AddRow(user.UID, user.Name, user.Email, user.Username, user.Gender, user.ShowGender, user.Sex, user.Birthdate, false, nil, nil, nil, nil, nil, tt.admin, user.CreatedAt))
+			if tt.admin {
+				mocking.ExpectQuery(`SELECT uid, name, email, username, gender, show_gender, sex, birthdate, verified, latitude, longitude`).
+					WithArgs("target").
+					WillReturnRows(getProfileRows().
+						AddRow("target", user.Name, user.Email, "target", user.Gender, user.ShowGender, user.Sex, user.Birthdate, false, nil, nil,
+							48.8566, 2.3522, now, false, user.CreatedAt))
+			}
+
+			c := &Handler{
+				service: NewService(getConfig(), clock.Fixed(now), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), premiumv1.NewRepository(db)),
+			}
+
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextAuthKey{}, user.UID))
+			req = mux.SetURLVars(req, map[string]string{"uid": "target"})
+
+			requestRecorder := httptest.NewRecorder()
+			c.GetUser(requestRecorder, req)
+			var resp UserResponse
+			err = json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.NoError(t, mocking.ExpectationsWereMet())
+			if resp.Code == servicebase.CodeSuccess {
+				assert.Equal(t, "target", resp.Data.UID)
+				// the travel expired at now
+				assert.Nil(t, resp.Data.Travel)
+			}
+		})
+	}
+}
+
+func TestUser_Unit_UpdatePreferences(t *testing.T) {
+	url := "/v1/user/profile/preferences"
+	userUID := "uid123"
+
+	tests := []struct {
+		name       string
+		svc        func() Service
+		payload    string
+		code       string
+		httpStatus int
+	}{
+		{
+			name: "Success update preferences - returns 200",
+			svc: func() Service {
+				db, mocking, err := sqlmock.New()
+				if err != nil {
+					t.Fatalf("error creating mock: %v", err)
+				}
+				mocking.ExpectExec(`INSERT INTO dealls_bumble.user_preferences`).
+					WithArgs(userUID, "women,men", 20, 30, nil, "age").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"interested_in": ["women", "men"], "min_age": 20, "max_age": 30, "dealbreakers": ["age"]}`,
+			code:       servicebase.CodeSuccess,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name: "Validation max age below min age error - returns 400",
+			svc: func() Service {
+				db, _, _ := sqlmock.New()
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"min_age": 30, "max_age": 20}`,
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Validation min age below 18 error - returns 400",
+			svc: func() Service {
+				db, _, _ := sqlmock.New()
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"min_age": 16}`,
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Validation dealbreaker error - returns 400",
+			svc: func() Service {
+				db, _, _ := sqlmock.New()
+				return NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+			},
+			payload:    `{"dealbreakers": ["height"]}`,
+			code:       servicebase.ErrValidationFailed.Code,
+			httpStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Handler{
+				service: tt.svc(),
+			}
+
+			req, err := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ContextAuthKey{}, userUID))
+
+			requestRecorder := httptest.NewRecorder()
+			c.UpdatePreferences(requestRecorder, req)
+			var resp UserPreferencesResponse
+			err = json.NewDecoder(requestRecorder.Body).Decode(&resp)
+			if err != nil {
+				t.Fatalf("Error decoding JSON: %v", err)
+				return
+			}
+			assert.Equal(t, tt.httpStatus, requestRecorder.Code)
+			assert.Equal(t, tt.code, resp.Code)
+		})
+	}
+}
+
+func TestUser_Unit_AdminCommands(t *testing.T) {
+	user, err := getTestUserEntity()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := uid.GeneratorFunc(func(n int) string { return "admin_uid_000001" })
+
+	t.Run("Create admin flags the user", func(t *testing.T) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		payload := getUserCreatePayload().NewLayoutDateOnly()
+		mocking.ExpectBegin()
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.users`).
+			WithArgs("admin_uid_000001", payload.Name, payload.Email, payload.Username, sqlmock.AnyArg(),
+				sqlmock.AnyArg(), true, sqlmock.AnyArg(), sqlmock.AnyArg(), true).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		// admins are not announced
+		mocking.ExpectCommit()
+
+		service := NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), ids, txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+		admin, err := service.CreateAdmin(context.Background(), payload)
+		assert.NoError(t, err)
+		assert.Equal(t, "admin_uid_000001", admin.UID)
+		assert.True(t, admin.Admin)
+		assert.NoError(t, mocking.ExpectationsWereMet())
+	})
+
+	t.Run("Create publishes the registration with the user", func(t *testing.T) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		payload := getUserCreatePayload().NewLayoutDateOnly()
+		now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		registered, err := json.Marshal(events.UserRegistered{UserUID: "admin_uid_000001", Username: payload.Username, Email: payload.Email})
+		if err != nil {
+			t.Fatalf("error encoding event: %v", err)
+		}
+		mocking.ExpectBegin()
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.users`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).
+			WithArgs("UserRegistered", "user:admin_uid_000001", string(registered), now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectCommit()
+
+		service := NewService(getConfig(), clock.Fixed(now), settings.NewStore(settings.Defaults()), ids, txn.NewTxManager(db), events.NewOutbox(db, clock.Fixed(now)), NewRepository(db), premiumv1.NewRepository(db))
+		_, err = service.Create(context.Background(), payload)
+		assert.NoError(t, err)
+		assert.NoError(t, mocking.ExpectationsWereMet())
+	})
+
+	t.Run("A failed publish rolls the user back", func(t *testing.T) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		mocking.ExpectBegin()
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.users`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.outbox`).WillReturnError(errors.New("outbox unavailable"))
+		mocking.ExpectRollback()
+
+		service := NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), ids, txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+		_, err = service.Create(context.Background(), getUserCreatePayload().NewLayoutDateOnly())
+		assert.Error(t, err)
+		assert.NoError(t, mocking.ExpectationsWereMet())
+	})
+
+	t.Run("Reset password stores a new hash", func(t *testing.T) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		mocking.ExpectQuery(`SELECT uid, name, email, username, hashed_password`).WithArgs(user.Username).
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "name", "email", "username", "hashed_password", "gender", "show_gender", "sex", "birthdate", "is_admin", "created_at"}).
+				AddRow(user.UID, user.Name, user.Email, user.Username, user.HashedPassword, user.Gender, user.ShowGender, user.Sex, user.Birthdate, user.Admin, user.CreatedAt))
+		mocking.ExpectExec(`UPDATE dealls_bumble.users\s+SET hashed_password`).WithArgs(user.UID, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		service := NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), ids, txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+		err = service.ResetPassword(context.Background(), UserResetPasswordPayload{Username: user.Username, Password: "NewPass123!"})
+		assert.NoError(t, err)
+		assert.NoError(t, mocking.ExpectationsWereMet())
+	})
+
+	t.Run("Reset password of an unknown user", func(t *testing.T) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		mocking.ExpectQuery(`SELECT uid, name, email, username, hashed_password`).WithArgs("nobody").
+			WillReturnError(sql.ErrNoRows)
+
+		service := NewService(getConfig(), clock.System(), settings.NewStore(settings.Defaults()), ids, txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db))
+		err = service.ResetPassword(context.Background(), UserResetPasswordPayload{Username: "nobody", Password: "NewPass123!"})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Reset password follows the password policy", func(t *testing.T) {
+		err := UserResetPasswordPayload{Username: user.Username, Password: "short"}.Validate()
+		assert.Error(t, err)
+	})
+}
+
+func TestUser_Unit_GenderMapping(t *testing.T) {
+	mapping := DefaultGenderMapping
+
+	tests := []struct {
+		name       string
+		payload    func() UserCreatePayload
+		valid      bool
+		gender     Gender
+		legacySex  *Sex
+		firstMover bool
+	}{
+		{
+			name: "Legacy sex maps to gender",
+			payload: func() UserCreatePayload {
+				return getUserCreatePayload()
+			},
+			valid:      true,
+			gender:     GenderWoman,
+			legacySex:  &[]Sex{Female}[0],
+			firstMover: true,
+		},
+		{
+			name: "Gender without legacy sex",
+			payload: func() UserCreatePayload {
+				p := getUserCreatePayload()
+				p.Sex = ""
+				p.Gender = GenderNonBinary
+				return p
+			},
+			valid:  true,
+			gender: GenderNonBinary,
+		},
+		{
+			name: "Unknown gender",
+			payload: func() UserCreatePayload {
+				p := getUserCreatePayload()
+				p.Gender = "unknown"
+				return p
+			},
+			valid: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := tt.payload()
+			if !tt.valid {
+				assert.ErrorContains(t, payload.Validate(), "gender")
+				return
+			}
+
+			gender := payload.GenderIdentity()
+			assert.Equal(t, tt.gender, gender)
+			assert.Equal(t, tt.legacySex, mapping.LegacySexOf(gender))
+
+			// women send the first message when matched with men
+			user := User{UID: "user", Gender: gender}
+			other := User{UID: "other", Gender: GenderMan}
+			if tt.firstMover {
+				assert.Equal(t, user.UID, mapping.FirstMover(user, other))
+			} else {
+				assert.Empty(t, mapping.FirstMover(user, other))
+			}
+		})
+	}
+
+	t.Run("Loading replaces the mapping while it is read", func(t *testing.T) {
+		t.Cleanup(func() { genderMapping.Store(nil) })
+
+		loaded := GenderMapping{Groups: map[Gender]GenderGroup{GenderWoman: GroupWomen}}
+		data, err := json.Marshal(loaded)
+		if err != nil {
+			t.Fatalf("error encoding mapping: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "genders.json")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("error writing mapping: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					Preferences{InterestedIn: []GenderGroup{GroupWomen}}.Accepts(PreferenceInterestedIn, User{}, User{Gender: GenderWoman}, time.Now())
+				}
+			}()
+		}
+		assert.NoError(t, LoadGenderMapping(path))
+		wg.Wait()
+
+		assert.Equal(t, loaded, GetGenderMapping())
+	})
+}
+
+func TestUser_Unit_Preferences(t *testing.T) {
+	now := time.Date(2024, 5, 26, 10, 0, 0, 0, time.UTC)
+	adult := now.AddDate(-30, 0, -1)
+	jakarta := &Location{Latitude: -6.2, Longitude: 106.8}
+	bandung := &Location{Latitude: -6.9, Longitude: 107.6}
+	intPtr := func(n int) *int { return &n }
+
+	tests := []struct {
+		name             string
+		self             User
+		preferences      Preferences
+		other            User
+		otherPreferences Preferences
+		shows            bool
+	}{
+		{
+			name:  "No preferences",
+			self:  User{Gender: GenderMan, Birthdate: adult},
+			other: User{Gender: GenderWoman, Birthdate: adult},
+			shows: true,
+		},
+		{
+			name:        "Preferences of self apply without being dealbreakers",
+			self:        User{Gender: GenderMan, Birthdate: adult},
+			preferences: Preferences{InterestedIn: []GenderGroup{GroupMen}},
+			other:       User{Gender: GenderWoman, Birthdate: adult},
+		},
+		{
+			name:             "Dealbreakers of other apply",
+			self:             User{Gender: GenderMan, Birthdate: adult},
+			other:            User{Gender: GenderWoman, Birthdate: adult},
+			otherPreferences: Preferences{MaxAge: intPtr(25), Dealbreakers: []PreferenceKey{PreferenceAge}},
+		},
+		{
+			name:             "Ages are taken at now",
+			self:             User{Gender: GenderMan, Birthdate: now.AddDate(-26, 0, 1)},
+			other:            User{Gender: GenderWoman, Birthdate: adult},
+			otherPreferences: Preferences{MaxAge: intPtr(25), Dealbreakers: []PreferenceKey{PreferenceAge}},
+			shows:            true,
+		},
+		{
+			name:             "Other preferences of other do not",
+			self:             User{Gender: GenderMan, Birthdate: adult},
+			other:            User{Gender: GenderWoman, Birthdate: adult},
+			otherPreferences: Preferences{MaxAge: intPtr(25)},
+			shows:            true,
+		},
+		{
+			name:        "Too far",
+			self:        User{Gender: GenderMan, Birthdate: adult, Location: jakarta},
+			preferences: Preferences{MaxDistanceKm: intPtr(50)},
+			other:       User{Gender: GenderWoman, Birthdate: adult, Location: bandung},
+		},
+		{
+			name:        "A distance preference waits for the location of self",
+			self:        User{Gender: GenderMan, Birthdate: adult},
+			preferences: Preferences{MaxDistanceKm: intPtr(50)},
+			other:       User{Gender: GenderWoman, Birthdate: adult, Location: bandung},
+			shows:       true,
+		},
+		{
+			name:        "Users without a location fail a distance preference",
+			self:        User{Gender: GenderMan, Birthdate: adult, Location: jakarta},
+			preferences: Preferences{MaxDistanceKm: intPtr(50)},
+			other:       User{Gender: GenderWoman, Birthdate: adult},
+		},
+		{
+			name:        "Distances use the travel location",
+			self:        User{Gender: GenderMan, Birthdate: adult, Location: bandung, Travel: &Travel{Location: *jakarta, ExpiresAt: now.Add(time.Hour)}},
+			preferences: Preferences{MaxDistanceKm: intPtr(50)},
+			other:       User{Gender: GenderWoman, Birthdate: adult, Location: jakarta},
+			shows:       true,
+		},
+		{
+			name:        "Expired travels are ignored",
+			self:        User{Gender: GenderMan, Birthdate: adult, Location: bandung, Travel: &Travel{Location: *jakarta, ExpiresAt: now}},
+			preferences: Preferences{MaxDistanceKm: intPtr(50)},
+			other:       User{Gender: GenderWoman, Birthdate: adult, Location: jakarta},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.shows, tt.preferences.Shows(tt.self, tt.other, tt.otherPreferences, now))
+		})
+	}
+}
+
+func TestUser_Unit_Localization(t *testing.T) {
+	db, _, _ := sqlmock.New()
+	cfg := getConfig()
+	handler := NewHandler(cfg, NewService(cfg, clock.System(), settings.NewStore(settings.Defaults()), uid.Default(), txn.NewTxManager(db), events.NewOutbox(db, clock.System()), NewRepository(db), premiumv1.NewRepository(db)))
+	router := middleware.Localize(http.HandlerFunc(handler.CreateUser))
+
+	tests := []struct {
+		name       string
+		lang       string
+		message    string
+		birthday   string
+		fieldError *servicebase.FieldError
+	}{
+		{
+			name:    "Indonesian",
+			lang:    "id-ID,id;q=0.9",
+			message: "Gagal membaca JSON",
+		},
+		{
+			name:    "English",
+			lang:    "en-US",
+			message: "Failed to decode JSON",
+		},
+		{
+			name:    "Unknown language falls back to English",
+			lang:    "fr",
+			message: "Failed to decode JSON",
+		},
+		{
+			name:     "Indonesian validation message",
+			lang:     "id",
+			message:  "Validasi gagal",
+			birthday: time.Now().Format("2006-01-02"),
+			fieldError: &servicebase.FieldError{
+				Field:   "birthdate",
+				Code:    servicebase.MessageMustAbove18,
+				Message: "Umur harus di atas 18 tahun",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// run concurrently, a language must never leak into another request
+			t.Parallel()
+			for i := 0; i < 20; i++ {
+				body := []byte("{")
+				if tt.birthday != "" {
+					payload := getUserCreatePayload()
+					payload.Birthdate = tt.birthday
+					payload.Email = "invalid"
+					body, _ = json.Marshal(payload)
+				}
+				req := httptest.NewRequest(http.MethodPost, "/v1/user/register", bytes.NewReader(body))
+				req.Header.Set("Accept-Language", tt.lang)
+				requestRecorder := httptest.NewRecorder()
+				router.ServeHTTP(requestRecorder, req)
+
+				var resp servicebase.ResponseBody
+				err := json.NewDecoder(requestRecorder.Body).Decode(&resp)
+				if err != nil {
+					t.Fatalf("Error decoding response body: %v", err)
+				}
+				assert.Equal(t, http.StatusBadRequest, requestRecorder.Code)
+				assert.Equal(t, tt.message, resp.Message)
+				if tt.fieldError != nil {
+					assert.Contains(t, resp.Errors, *tt.fieldError)
+				}
+			}
+		})
+	}
+}
+
+func TestUser_Unit_ReplicatedRepository(t *testing.T) {
+	ctx := context.Background()
+	newDB := func(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		return db, mocking
+	}
+	primary, primaryMock := newDB(t)
+	reader, readerMock := newDB(t)
+	repo := NewReplicatedRepository(replica.NewSet(primary, []replica.Replica{{Name: "reader", DB: reader}}))
+	columns := []string{"uid", "name", "email", "username", "hashed_password", "gender", "show_gender", "sex", "birthdate", "is_admin", "created_at"}
+	row := []driver.Value{"uid", "Name", "name@mail.com", "name", "hash", "male", true, "male", time.Now(), false, time.Now()}
+
+	readerMock.ExpectQuery("FROM dealls_bumble.users").WithArgs("name").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+	primaryMock.ExpectExec("UPDATE dealls_bumble.users").WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectQuery("FROM dealls_bumble.users").WithArgs("name").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+
+	session := replica.WithSession(ctx)
+	_, err := repo.GetByUsername(session, "name")
+	assert.NoError(t, err)
+	assert.NoError(t, repo.UpdatePassword(session, "uid", "new hash"))
+	_, err = repo.GetByUsername(session, "name")
+	assert.NoError(t, err)
+}
+
+func getProfileRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"uid", "name", "email", "username", "gender", "show_gender", "sex", "birthdate", "verified",
+		"latitude", "longitude", "travel_latitude", "travel_longitude", "travel_expires_at", "is_admin", "created_at"})
+}
+
 func getConfig() config.AppConfig {
 	return config.AppConfig{
 		App: config.App{
@@ -485,7 +1253,9 @@ func getTestUserEntity() (user User, err error) {
 		Email:          payload.Email,
 		Username:       payload.Username,
 		HashedPassword: &hashedPassword,
-		Sex:            payload.Sex,
+		Gender:         payload.GenderIdentity(),
+		ShowGender:     true,
+		Sex:            &payload.Sex,
 		Birthdate:      birthdate,
 		CreatedAt:      currentDate,
 	}, nil
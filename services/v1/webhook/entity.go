@@ -0,0 +1,64 @@
+package webhookv1
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Subscription is a partner endpoint receiving the events of EventTypes, or
+// of every type when EventTypes is empty
+type Subscription struct {
+	ID         int64
+	URL        string
+	Secret     string
+	EventTypes []string
+	Enabled    bool
+	// ConsecutiveFailures counts the failed attempts since the last success,
+	// the subscription is disabled once it reaches the threshold
+	ConsecutiveFailures int
+	CreatedAt           time.Time
+	DisabledAt          *time.Time
+}
+
+// Accepts reports whether the subscription receives events of eventType
+func (s Subscription) Accepts(eventType string) bool {
+	return len(s.EventTypes) == 0 || slices.Contains(s.EventTypes, eventType)
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryFailed deliveries ran out of attempts or their subscription
+	// was disabled, they can be replayed
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Delivery is an event sent to a subscription, the entries of its delivery
+// log
+type Delivery struct {
+	ID             int64
+	SubscriptionID int64
+	EventID        int64
+	EventType      string
+	// Payload is the body posted to the subscription
+	Payload        json.RawMessage
+	Status         DeliveryStatus
+	Attempts       int
+	LastStatusCode *int
+	LastError      *string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+// Body is the JSON posted to subscriptions
+type Body struct {
+	// ID is the ID of the event, the same on every retry and replay so
+	// receivers can drop duplicates
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
@@ -0,0 +1,13 @@
+package webhookv1
+
+import (
+	"net/http"
+
+	servicebase "github.com/farolinar/dealls-bumble/services/base"
+)
+
+var (
+	ErrSubscriptionNotFound = servicebase.NewAppError(http.StatusNotFound, "WHK-404-001", MessageSubscriptionNotFound)
+	ErrDeliveryNotFound     = servicebase.NewAppError(http.StatusNotFound, "WHK-404-002", MessageDeliveryNotFound)
+	ErrSubscriptionDisabled = servicebase.NewAppError(http.StatusConflict, "WHK-409-001", MessageSubscriptionDisabled)
+)
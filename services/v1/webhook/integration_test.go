@@ -0,0 +1,108 @@
+package webhookv1
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	dbtest "github.com/farolinar/dealls-bumble/internal/common/db/test"
+	_ "github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// integration testing for the event type filters, the delivery log and
+// auto-disabling
+func TestWebhook_Integration_Repository(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := dbtest.CreatePostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("error creating postgres container: %v", err)
+	}
+
+	db, err := sql.Open("pgx", pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("unable to connect to database: %v\n", err)
+	}
+
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	})
+
+	repo := NewRepository(db)
+	createdAt := time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC)
+
+	crm := Subscription{URL: "https://crm.example.com/hooks", Secret: "whsec_crm", EventTypes: []string{"UserRegistered", "PackagePurchased"}, CreatedAt: createdAt}
+	analytics := Subscription{URL: "https://analytics.example.com/hooks", Secret: "whsec_analytics", CreatedAt: createdAt}
+	for _, sub := range []*Subscription{&crm, &analytics} {
+		err = repo.CreateSubscription(ctx, sub)
+		assert.NoError(t, err)
+		assert.True(t, sub.Enabled)
+	}
+
+	subs, err := repo.SubscriptionsFor(ctx, "PackagePurchased")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{crm.ID, analytics.ID}, subscriptionIDs(subs))
+	subs, err = repo.SubscriptionsFor(ctx, "Matched")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{analytics.ID}, subscriptionIDs(subs))
+
+	// an event is logged once per subscription
+	delivery := Delivery{SubscriptionID: crm.ID, EventID: 3, EventType: "PackagePurchased", Payload: []byte(`{"id":3}`), Status: DeliveryPending, CreatedAt: createdAt}
+	created, err := repo.CreateDelivery(ctx, &delivery)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	again := delivery
+	created, err = repo.CreateDelivery(ctx, &again)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	code, message := 500, "subscription answered 500"
+	delivery.Status, delivery.LastStatusCode, delivery.LastError = DeliveryPending, &code, &message
+	err = repo.RecordAttempt(ctx, delivery)
+	assert.NoError(t, err)
+	deliveries, err := repo.ListDeliveries(ctx, crm.ID, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, &code, deliveries[0].LastStatusCode)
+		assert.Equal(t, &message, deliveries[0].LastError)
+	}
+
+	// disabled on the third failure in a row, a success clears the count
+	disabled, err := repo.RecordFailure(ctx, crm.ID, 3, createdAt)
+	assert.NoError(t, err)
+	assert.False(t, disabled)
+	err = repo.RecordSuccess(ctx, crm.ID)
+	assert.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		disabled, err = repo.RecordFailure(ctx, crm.ID, 3, createdAt)
+		assert.NoError(t, err)
+		assert.Equal(t, i == 3, disabled)
+	}
+	subs, err = repo.SubscriptionsFor(ctx, "PackagePurchased")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{analytics.ID}, subscriptionIDs(subs))
+
+	err = repo.SetEnabled(ctx, crm.ID, true, createdAt)
+	assert.NoError(t, err)
+	sub, err := repo.GetSubscription(ctx, crm.ID)
+	assert.NoError(t, err)
+	assert.True(t, sub.Enabled)
+	assert.Equal(t, 0, sub.ConsecutiveFailures)
+	assert.Nil(t, sub.DisabledAt)
+}
+
+func subscriptionIDs(subs []Subscription) []int64 {
+	ids := make([]int64, len(subs))
+	for i, sub := range subs {
+		ids[i] = sub.ID
+	}
+	return ids
+}
@@ -0,0 +1,21 @@
+package webhookv1
+
+import (
+	"embed"
+
+	"github.com/farolinar/dealls-bumble/internal/common/i18n"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+func init() {
+	i18n.MustLoadFS(locales, "locales")
+}
+
+// Message IDs, the text of each language lives in locales/<lang>.json
+var (
+	MessageSubscriptionNotFound = "webhook.subscription_not_found"
+	MessageDeliveryNotFound     = "webhook.delivery_not_found"
+	MessageSubscriptionDisabled = "webhook.subscription_disabled"
+)
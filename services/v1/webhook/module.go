@@ -0,0 +1,23 @@
+package webhookv1
+
+import (
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/container"
+	"github.com/gorilla/mux"
+)
+
+// Module sends the domain events to the webhook subscriptions, which are
+// managed with the webhook command
+type Module struct{}
+
+func (Module) Name() string {
+	return "webhook"
+}
+
+func (Module) Register(app *container.App, r *mux.Router) {
+	service := NewService(app.Clock, app.IDs, app.Tx, app.Jobs, NewReplicatedRepository(app.Replicas))
+	for _, eventType := range events.Types() {
+		app.Events.Subscribe(eventType, "webhooks", service.Fanout)
+	}
+	app.Jobs.Register(DeliverJob{}.Kind(), service.Deliver)
+}
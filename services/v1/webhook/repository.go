@@ -0,0 +1,307 @@
+package webhookv1
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/tracing"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+)
+
+type Repository interface {
+	CreateSubscription(ctx context.Context, sub *Subscription) (err error)
+	GetSubscription(ctx context.Context, id int64) (sub Subscription, err error)
+	ListSubscriptions(ctx context.Context) (subs []Subscription, err error)
+	// SubscriptionsFor lists the enabled subscriptions accepting eventType
+	SubscriptionsFor(ctx context.Context, eventType string) (subs []Subscription, err error)
+	// SetEnabled enables or disables a subscription, enabling it clears its
+	// failures
+	SetEnabled(ctx context.Context, id int64, enabled bool, at time.Time) (err error)
+	// RecordSuccess clears the failures of a subscription
+	RecordSuccess(ctx context.Context, id int64) (err error)
+	// RecordFailure counts a failed attempt of a subscription and disables it
+	// once threshold attempts failed in a row
+	RecordFailure(ctx context.Context, id int64, threshold int, at time.Time) (disabled bool, err error)
+	// CreateDelivery logs a delivery, unless the event was already delivered
+	// to the subscription
+	CreateDelivery(ctx context.Context, delivery *Delivery) (created bool, err error)
+	GetDelivery(ctx context.Context, id int64) (delivery Delivery, err error)
+	// ListDeliveries lists the latest deliveries of a subscription first
+	ListDeliveries(ctx context.Context, subscriptionID int64, limit int) (deliveries []Delivery, err error)
+	// RecordAttempt counts an attempt of a delivery and saves its status and
+	// outcome
+	RecordAttempt(ctx context.Context, delivery Delivery) (err error)
+	SetStatus(ctx context.Context, id int64, status DeliveryStatus) (err error)
+}
+
+type dbRepository struct {
+	db    *sql.DB
+	reads *replica.Set
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return NewReplicatedRepository(replica.Single(db))
+}
+
+// NewReplicatedRepository writes to the primary of reads and reads from its
+// replicas
+func NewReplicatedRepository(reads *replica.Set) Repository {
+	return &dbRepository{db: reads.Primary(), reads: reads}
+}
+
+// writer returns the transaction of ctx when the call runs within one. The
+// write is recorded so the rest of the request reads from the primary.
+func (d *dbRepository) writer(ctx context.Context) txn.Querier {
+	replica.MarkWrite(ctx)
+	return txn.From(ctx, d.db)
+}
+
+// reader returns the transaction of ctx when the call runs within one, a
+// replica otherwise
+func (d *dbRepository) reader(ctx context.Context) txn.Querier {
+	if txn.InTx(ctx) {
+		return txn.From(ctx, d.db)
+	}
+	return d.reads.Reader(ctx)
+}
+
+const subscriptionColumns = `id, url, secret, event_types, enabled, consecutive_failures, created_at, disabled_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row scanner) (sub Subscription, err error) {
+	var eventTypes []byte
+	err = row.Scan(&sub.ID, &sub.URL, &sub.Secret, &eventTypes, &sub.Enabled, &sub.ConsecutiveFailures, &sub.CreatedAt, &sub.DisabledAt)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(eventTypes, &sub.EventTypes)
+	return
+}
+
+func (d *dbRepository) listSubscriptions(ctx context.Context, q string, args ...any) (subs []Subscription, err error) {
+	rows, err := d.reader(ctx).QueryContext(ctx, q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sub Subscription
+		sub, err = scanSubscription(rows)
+		if err != nil {
+			return
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+func (d *dbRepository) CreateSubscription(ctx context.Context, sub *Subscription) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.CreateSubscription")
+	defer tracing.EndQuery(span, &err)
+
+	eventTypes := sub.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	encoded, err := json.Marshal(eventTypes)
+	if err != nil {
+		return
+	}
+
+	q := `
+        INSERT INTO dealls_bumble.webhook_subscriptions (url, secret, event_types, created_at)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, enabled;
+    `
+	row := d.writer(ctx).QueryRowContext(ctx, q, sub.URL, sub.Secret, string(encoded), sub.CreatedAt)
+	err = row.Scan(&sub.ID, &sub.Enabled)
+	return
+}
+
+func (d *dbRepository) GetSubscription(ctx context.Context, id int64) (sub Subscription, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.GetSubscription")
+	defer tracing.EndQuery(span, &err)
+
+	q := `SELECT ` + subscriptionColumns + ` FROM dealls_bumble.webhook_subscriptions WHERE id = $1;`
+	return scanSubscription(d.reader(ctx).QueryRowContext(ctx, q, id))
+}
+
+func (d *dbRepository) ListSubscriptions(ctx context.Context) (subs []Subscription, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.ListSubscriptions")
+	defer tracing.EndQuery(span, &err)
+
+	q := `SELECT ` + subscriptionColumns + ` FROM dealls_bumble.webhook_subscriptions ORDER BY id;`
+	return d.listSubscriptions(ctx, q)
+}
+
+func (d *dbRepository) SubscriptionsFor(ctx context.Context, eventType string) (subs []Subscription, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.SubscriptionsFor")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        SELECT ` + subscriptionColumns + `
+        FROM dealls_bumble.webhook_subscriptions
+        WHERE enabled AND (event_types = '[]'::jsonb OR event_types @> jsonb_build_array($1::text))
+        ORDER BY id;
+    `
+	return d.listSubscriptions(ctx, q, eventType)
+}
+
+func (d *dbRepository) SetEnabled(ctx context.Context, id int64, enabled bool, at time.Time) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.SetEnabled")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        UPDATE dealls_bumble.webhook_subscriptions
+        SET enabled = $2,
+            consecutive_failures = CASE WHEN $2 THEN 0 ELSE consecutive_failures END,
+            disabled_at = CASE WHEN $2 THEN NULL ELSE $3 END
+        WHERE id = $1;
+    `
+	res, err := d.writer(ctx).ExecContext(ctx, q, id, enabled, at)
+	if err != nil {
+		return
+	}
+	return requireAffected(res)
+}
+
+func (d *dbRepository) RecordSuccess(ctx context.Context, id int64) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.RecordSuccess")
+	defer tracing.EndQuery(span, &err)
+
+	q := `UPDATE dealls_bumble.webhook_subscriptions SET consecutive_failures = 0 WHERE id = $1;`
+	_, err = d.writer(ctx).ExecContext(ctx, q, id)
+	return
+}
+
+func (d *dbRepository) RecordFailure(ctx context.Context, id int64, threshold int, at time.Time) (disabled bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.RecordFailure")
+	defer tracing.EndQuery(span, &err)
+
+	// the expressions of SET read the values before the update
+	q := `
+        UPDATE dealls_bumble.webhook_subscriptions
+        SET consecutive_failures = consecutive_failures + 1,
+            enabled = enabled AND consecutive_failures + 1 < $2,
+            disabled_at = CASE WHEN enabled AND consecutive_failures + 1 >= $2 THEN $3 ELSE disabled_at END
+        WHERE id = $1
+        RETURNING NOT enabled;
+    `
+	row := d.writer(ctx).QueryRowContext(ctx, q, id, threshold, at)
+	err = row.Scan(&disabled)
+	return
+}
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, payload, status, attempts, last_status_code, last_error, created_at, delivered_at`
+
+func scanDelivery(row scanner) (delivery Delivery, err error) {
+	var payload []byte
+	err = row.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType, &payload, &delivery.Status,
+		&delivery.Attempts, &delivery.LastStatusCode, &delivery.LastError, &delivery.CreatedAt, &delivery.DeliveredAt)
+	delivery.Payload = payload
+	return
+}
+
+func (d *dbRepository) CreateDelivery(ctx context.Context, delivery *Delivery) (created bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.CreateDelivery")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        INSERT INTO dealls_bumble.webhook_deliveries (subscription_id, event_id, event_type, payload, status, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (subscription_id, event_id) DO NOTHING
+        RETURNING id;
+    `
+	row := d.writer(ctx).QueryRowContext(ctx, q, delivery.SubscriptionID, delivery.EventID, delivery.EventType,
+		string(delivery.Payload), string(delivery.Status), delivery.CreatedAt)
+	err = row.Scan(&delivery.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *dbRepository) GetDelivery(ctx context.Context, id int64) (delivery Delivery, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.GetDelivery")
+	defer tracing.EndQuery(span, &err)
+
+	q := `SELECT ` + deliveryColumns + ` FROM dealls_bumble.webhook_deliveries WHERE id = $1;`
+	return scanDelivery(d.reader(ctx).QueryRowContext(ctx, q, id))
+}
+
+func (d *dbRepository) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) (deliveries []Delivery, err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.ListDeliveries")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        SELECT ` + deliveryColumns + `
+        FROM dealls_bumble.webhook_deliveries
+        WHERE subscription_id = $1
+        ORDER BY id DESC
+        LIMIT $2;
+    `
+	rows, err := d.reader(ctx).QueryContext(ctx, q, subscriptionID, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery Delivery
+		delivery, err = scanDelivery(rows)
+		if err != nil {
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (d *dbRepository) RecordAttempt(ctx context.Context, delivery Delivery) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.RecordAttempt")
+	defer tracing.EndQuery(span, &err)
+
+	q := `
+        UPDATE dealls_bumble.webhook_deliveries
+        SET attempts = attempts + 1, status = $2, last_status_code = $3, last_error = $4, delivered_at = $5
+        WHERE id = $1;
+    `
+	_, err = d.writer(ctx).ExecContext(ctx, q, delivery.ID, string(delivery.Status), delivery.LastStatusCode,
+		delivery.LastError, delivery.DeliveredAt)
+	return
+}
+
+func (d *dbRepository) SetStatus(ctx context.Context, id int64, status DeliveryStatus) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "webhook.SetStatus")
+	defer tracing.EndQuery(span, &err)
+
+	q := `UPDATE dealls_bumble.webhook_deliveries SET status = $2 WHERE id = $1;`
+	res, err := d.writer(ctx).ExecContext(ctx, q, id, string(status))
+	if err != nil {
+		return
+	}
+	return requireAffected(res)
+}
+
+// requireAffected reports sql.ErrNoRows when a write matched no row
+func requireAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
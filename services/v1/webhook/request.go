@@ -0,0 +1,29 @@
+package webhookv1
+
+import (
+	"regexp"
+
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+var httpURL = regexp.MustCompile(`^https?://`)
+
+type SubscriptionPayload struct {
+	URL string `json:"url"`
+	// EventTypes filters the events sent, every type when empty
+	EventTypes []string `json:"event_types"`
+}
+
+func (p SubscriptionPayload) Validate() error {
+	types := make([]interface{}, 0)
+	for _, t := range events.Types() {
+		types = append(types, t)
+	}
+
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.URL, validation.Required, is.RequestURL, validation.Match(httpURL)),
+		validation.Field(&p.EventTypes, validation.Each(validation.In(types...))),
+	)
+}
@@ -0,0 +1,361 @@
+package webhookv1
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/jobs"
+	"github.com/farolinar/dealls-bumble/internal/common/metrics"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers of the requests posted to subscriptions
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign returns the signature header of a request: sha256= followed by the
+// hex HMAC-SHA256, keyed with the secret of the subscription, of the
+// timestamp header, a dot and the body. Receivers compute it the same way
+// and reject old timestamps to stop replayed requests.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverJob posts a delivery to its subscription
+type DeliverJob struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+func (DeliverJob) Kind() string {
+	return "webhook_delivery"
+}
+
+type Service interface {
+	Subscribe(ctx context.Context, payload SubscriptionPayload) (sub Subscription, err error)
+	ListSubscriptions(ctx context.Context) (subs []Subscription, err error)
+	SetEnabled(ctx context.Context, id int64, enabled bool) (err error)
+	ListDeliveries(ctx context.Context, subscriptionID int64, limit int) (deliveries []Delivery, err error)
+	// Replay sends a delivery again, whatever its status
+	Replay(ctx context.Context, deliveryID int64) (err error)
+	// Fanout logs a delivery of e for every subscription accepting it and
+	// enqueues them, it subscribes to every event type
+	Fanout(ctx context.Context, e events.Envelope) (err error)
+	// Deliver runs the DeliverJob jobs
+	Deliver(ctx context.Context, job jobs.Job) (err error)
+}
+
+type webhookService struct {
+	clock      clock.Clock
+	ids        uid.Generator
+	tx         *txn.TxManager
+	queue      *jobs.Queue
+	repository Repository
+
+	client      *http.Client
+	maxAttempts int
+	threshold   int
+}
+
+type Option func(*webhookService)
+
+// WithHTTPClient sets the client posting deliveries, one with a 10 second
+// timeout by default
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *webhookService) { s.client = client }
+}
+
+// WithMaxAttempts sets how many times a delivery is posted before it is
+// marked failed, 8 by default. Retries wait as long as the jobs of the
+// queue.
+func WithMaxAttempts(n int) Option {
+	return func(s *webhookService) { s.maxAttempts = n }
+}
+
+// WithFailureThreshold sets how many attempts in a row may fail before the
+// subscription is disabled, 20 by default
+func WithFailureThreshold(n int) Option {
+	return func(s *webhookService) { s.threshold = n }
+}
+
+func NewService(clk clock.Clock, ids uid.Generator, tx *txn.TxManager, queue *jobs.Queue, repository Repository, opts ...Option) Service {
+	s := &webhookService{
+		clock:       clk,
+		ids:         ids,
+		tx:          tx,
+		queue:       queue,
+		repository:  repository,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 8,
+		threshold:   20,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *webhookService) Subscribe(ctx context.Context, payload SubscriptionPayload) (sub Subscription, err error) {
+	sub = Subscription{
+		URL:        payload.URL,
+		Secret:     "whsec_" + s.ids.Generate(32),
+		EventTypes: payload.EventTypes,
+		CreatedAt:  s.clock.Now(),
+	}
+	err = s.repository.CreateSubscription(ctx, &sub)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error creating subscription: %s", err.Error())
+	}
+	return
+}
+
+func (s *webhookService) ListSubscriptions(ctx context.Context) (subs []Subscription, err error) {
+	subs, err = s.repository.ListSubscriptions(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error listing subscriptions: %s", err.Error())
+	}
+	return
+}
+
+func (s *webhookService) SetEnabled(ctx context.Context, id int64, enabled bool) (err error) {
+	err = s.repository.SetEnabled(ctx, id, enabled, s.clock.Now())
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error updating subscription: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrSubscriptionNotFound
+		}
+	}
+	return
+}
+
+func (s *webhookService) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) (deliveries []Delivery, err error) {
+	_, err = s.getSubscription(ctx, subscriptionID)
+	if err != nil {
+		return
+	}
+
+	deliveries, err = s.repository.ListDeliveries(ctx, subscriptionID, limit)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error listing deliveries: %s", err.Error())
+	}
+	return
+}
+
+func (s *webhookService) getSubscription(ctx context.Context, id int64) (sub Subscription, err error) {
+	sub, err = s.repository.GetSubscription(ctx, id)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting subscription: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrSubscriptionNotFound
+		}
+	}
+	return
+}
+
+func (s *webhookService) Replay(ctx context.Context, deliveryID int64) (err error) {
+	delivery, err := s.repository.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("error getting delivery: %s", err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrDeliveryNotFound
+		}
+		return
+	}
+
+	sub, err := s.getSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return
+	}
+	if !sub.Enabled {
+		return ErrSubscriptionDisabled
+	}
+
+	return s.tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+		err = s.repository.SetStatus(ctx, delivery.ID, DeliveryPending)
+		if err != nil {
+			return
+		}
+		return s.enqueue(ctx, delivery.ID)
+	})
+}
+
+// enqueue enqueues the job of a delivery, unless it already waits for one
+func (s *webhookService) enqueue(ctx context.Context, deliveryID int64) (err error) {
+	_, err = s.queue.Enqueue(ctx, DeliverJob{DeliveryID: deliveryID},
+		jobs.UniqueKey(fmt.Sprintf("webhook_delivery:%d", deliveryID)), jobs.MaxAttempts(s.maxAttempts))
+	return
+}
+
+func (s *webhookService) Fanout(ctx context.Context, e events.Envelope) (err error) {
+	subs, err := s.repository.SubscriptionsFor(ctx, e.Type)
+	if err != nil || len(subs) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(Body{ID: e.ID, Type: e.Type, OccurredAt: e.OccurredAt, Data: e.Payload})
+	if err != nil {
+		return
+	}
+
+	for _, sub := range subs {
+		// the event may come again after a failure, its deliveries are
+		// logged once
+		err = s.tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+			delivery := Delivery{
+				SubscriptionID: sub.ID,
+				EventID:        e.ID,
+				EventType:      e.Type,
+				Payload:        body,
+				Status:         DeliveryPending,
+				CreatedAt:      s.clock.Now(),
+			}
+			created, err := s.repository.CreateDelivery(ctx, &delivery)
+			if err != nil || !created {
+				return
+			}
+			return s.enqueue(ctx, delivery.ID)
+		})
+		if err != nil {
+			return fmt.Errorf("error logging delivery to subscription %d: %w", sub.ID, err)
+		}
+	}
+
+	return
+}
+
+func (s *webhookService) Deliver(ctx context.Context, job jobs.Job) (err error) {
+	var args DeliverJob
+	err = json.Unmarshal(job.Payload, &args)
+	if err != nil {
+		return
+	}
+
+	// a lagging replica would miss a delivery just enqueued or replayed and
+	// drop it, so the job reads the primary
+	ctx = replica.ReadYourWrites(ctx)
+	delivery, err := s.repository.GetDelivery(ctx, args.DeliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// deleted along with its subscription
+		return nil
+	}
+	if err != nil || delivery.Status != DeliveryPending {
+		return
+	}
+
+	sub, err := s.repository.GetSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return
+	}
+	if !sub.Enabled {
+		// left for a replay once the subscription is enabled again
+		return s.repository.SetStatus(ctx, delivery.ID, DeliveryFailed)
+	}
+
+	statusCode, sendErr := s.send(ctx, sub, delivery)
+	now := s.clock.Now()
+	delivery.LastStatusCode = statusCode
+	if sendErr == nil {
+		delivery.Status = DeliverySucceeded
+		delivery.LastError = nil
+		delivery.DeliveredAt = &now
+		err = s.tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+			err = s.repository.RecordAttempt(ctx, delivery)
+			if err != nil {
+				return
+			}
+			return s.repository.RecordSuccess(ctx, sub.ID)
+		})
+		if err == nil {
+			metrics.WebhookDeliveries.WithLabelValues(delivery.EventType, metrics.OutcomeSucceeded).Inc()
+		}
+		return
+	}
+
+	message := sendErr.Error()
+	delivery.LastError = &message
+	var disabled bool
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) (err error) {
+		disabled, err = s.repository.RecordFailure(ctx, sub.ID, s.threshold, now)
+		if err != nil {
+			return
+		}
+		delivery.Status = DeliveryPending
+		if disabled || job.Attempt >= job.MaxAttempts {
+			delivery.Status = DeliveryFailed
+		}
+		return s.repository.RecordAttempt(ctx, delivery)
+	})
+	if err != nil {
+		return
+	}
+
+	outcome := metrics.OutcomeRetried
+	if delivery.Status == DeliveryFailed {
+		outcome = metrics.OutcomeFailed
+	}
+	metrics.WebhookDeliveries.WithLabelValues(delivery.EventType, outcome).Inc()
+	log.Warn().Int64("delivery_id", delivery.ID).Int64("subscription_id", sub.ID).Int("attempt", job.Attempt).
+		Msgf("Webhook delivery failed | %s", message)
+
+	if disabled {
+		log.Error().Int64("subscription_id", sub.ID).
+			Msgf("Webhook subscription disabled after %d failed attempts in a row", s.threshold)
+		return nil
+	}
+	// failing the job retries it with the backoff of the queue
+	return sendErr
+}
+
+// send posts a delivery and returns the status code of the answer, if any. A
+// status outside 2xx fails.
+func (s *webhookService) send(ctx context.Context, sub Subscription, delivery Delivery) (statusCode *int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return
+	}
+
+	timestamp := s.clock.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	// drained so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	statusCode = &resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("subscription answered %d", resp.StatusCode)
+	}
+	return
+}
@@ -0,0 +1,352 @@
+package webhookv1
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/farolinar/dealls-bumble/internal/common/clock"
+	"github.com/farolinar/dealls-bumble/internal/common/events"
+	"github.com/farolinar/dealls-bumble/internal/common/jobs"
+	"github.com/farolinar/dealls-bumble/internal/common/replica"
+	"github.com/farolinar/dealls-bumble/internal/common/txn"
+	"github.com/farolinar/dealls-bumble/internal/common/uid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	now           = time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC)
+	secret        = "whsec_test"
+	payload       = `{"id":3,"type":"Matched","occurred_at":"2024-05-03T09:59:00Z","data":{"user_uid":"abc","match_uid":"def"}}`
+	subColumns    = []string{"id", "url", "secret", "event_types", "enabled", "consecutive_failures", "created_at", "disabled_at"}
+	deliveryRowAt = now.Add(-time.Minute)
+)
+
+func newService(t *testing.T, opts ...Option) (sqlmock.Sqlmock, Service) {
+	db, mocking, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock: %v", err)
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, mocking.ExpectationsWereMet())
+	})
+
+	clk := clock.Fixed(now)
+	tx := txn.NewTxManager(db)
+	ids := uid.GeneratorFunc(func(n int) string { return "secret" })
+	return mocking, NewService(clk, ids, tx, jobs.NewQueue(db, tx, clk), NewRepository(db), opts...)
+}
+
+func subscriptionRow(url string, enabled bool) *sqlmock.Rows {
+	return sqlmock.NewRows(subColumns).AddRow(1, url, secret, `["Matched"]`, enabled, 0, now, nil)
+}
+
+func deliveryRow(status DeliveryStatus) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "subscription_id", "event_id", "event_type", "payload", "status", "attempts",
+		"last_status_code", "last_error", "created_at", "delivered_at"}).
+		AddRow(7, 1, 3, "Matched", []byte(payload), string(status), 0, nil, nil, deliveryRowAt, nil)
+}
+
+func deliverJob(attempt, maxAttempts int) jobs.Job {
+	return jobs.Job{ID: 11, Kind: DeliverJob{}.Kind(), Payload: json.RawMessage(`{"delivery_id":7}`), Attempt: attempt, MaxAttempts: maxAttempts}
+}
+
+// receiver is a partner endpoint answering status, it checks the signature
+// of every request it gets
+func receiver(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Matched", r.Header.Get(HeaderEvent))
+		assert.Equal(t, "7", r.Header.Get(HeaderDelivery))
+		assert.Equal(t, strconv.FormatInt(now.Unix(), 10), r.Header.Get(HeaderTimestamp))
+		assert.JSONEq(t, payload, string(body))
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(r.Header.Get(HeaderTimestamp) + "." + string(body)))
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		assert.True(t, hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))), "signature mismatch")
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestWebhook_Unit_Subscribe(t *testing.T) {
+	t.Run("Payload validation", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			payload SubscriptionPayload
+			valid   bool
+		}{
+			{"Every event type", SubscriptionPayload{URL: "https://crm.example.com/hooks"}, true},
+			{"Known event types", SubscriptionPayload{URL: "http://localhost:9000/hooks", EventTypes: []string{"UserRegistered", "PackagePurchased"}}, true},
+			{"Missing URL", SubscriptionPayload{}, false},
+			{"Relative URL", SubscriptionPayload{URL: "/hooks"}, false},
+			{"Other scheme", SubscriptionPayload{URL: "ftp://crm.example.com/hooks"}, false},
+			{"Unknown event type", SubscriptionPayload{URL: "https://crm.example.com/hooks", EventTypes: []string{"Unknown"}}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.payload.Validate()
+				assert.Equal(t, tt.valid, err == nil, "error: %v", err)
+			})
+		}
+	})
+
+	t.Run("Subscription gets a secret", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectQuery(`INSERT INTO dealls_bumble.webhook_subscriptions`).
+			WithArgs("https://crm.example.com/hooks", "whsec_secret", `["Matched"]`, now).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "enabled"}).AddRow(1, true))
+
+		sub, err := service.Subscribe(context.Background(), SubscriptionPayload{URL: "https://crm.example.com/hooks", EventTypes: []string{"Matched"}})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), sub.ID)
+		assert.Equal(t, "whsec_secret", sub.Secret)
+		assert.True(t, sub.Enabled)
+	})
+
+	t.Run("Unknown subscription", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_subscriptions`).WithArgs(9, true, now).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err := service.SetEnabled(context.Background(), 9, true)
+		assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+	})
+}
+
+func TestWebhook_Unit_Fanout(t *testing.T) {
+	mocking, service := newService(t)
+	mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_subscriptions\s+WHERE enabled`).WithArgs("Matched").
+		WillReturnRows(sqlmock.NewRows(subColumns).
+			AddRow(1, "https://crm.example.com/hooks", secret, `["Matched"]`, true, 0, now, nil).
+			AddRow(2, "https://analytics.example.com/hooks", secret, `[]`, true, 0, now, nil))
+
+	// the first subscription gets the event
+	mocking.ExpectBegin()
+	mocking.ExpectQuery(`INSERT INTO dealls_bumble.webhook_deliveries`).
+		WithArgs(1, 3, "Matched", sqlmock.AnyArg(), "pending", now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mocking.ExpectExec(`INSERT INTO dealls_bumble.jobs`).
+		WithArgs("webhook_delivery", `{"delivery_id":7}`, "webhook_delivery:7", 8, now, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mocking.ExpectCommit()
+	// the second already got it before the event was dispatched again
+	mocking.ExpectBegin()
+	mocking.ExpectQuery(`INSERT INTO dealls_bumble.webhook_deliveries`).
+		WithArgs(2, 3, "Matched", sqlmock.AnyArg(), "pending", now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mocking.ExpectCommit()
+
+	err := service.Fanout(context.Background(), events.Envelope{
+		ID:         3,
+		Type:       "Matched",
+		Aggregate:  "user:abc",
+		Payload:    json.RawMessage(`{"user_uid":"abc","match_uid":"def"}`),
+		OccurredAt: time.Date(2024, 5, 3, 9, 59, 0, 0, time.UTC),
+		Attempt:    1,
+	})
+	assert.NoError(t, err)
+}
+
+func TestWebhook_Unit_Deliver(t *testing.T) {
+	ctx := context.Background()
+	expectLoaded := func(mocking sqlmock.Sqlmock, url string, enabled bool) {
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_deliveries WHERE id = \$1`).WithArgs(7).
+			WillReturnRows(deliveryRow(DeliveryPending))
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_subscriptions WHERE id = \$1`).WithArgs(1).
+			WillReturnRows(subscriptionRow(url, enabled))
+	}
+	expectFailure := func(mocking sqlmock.Sqlmock, disabled bool, status DeliveryStatus, code any, lastError string) {
+		mocking.ExpectBegin()
+		mocking.ExpectQuery(`UPDATE dealls_bumble.webhook_subscriptions\s+SET consecutive_failures = consecutive_failures \+ 1`).
+			WithArgs(1, 20, now).
+			WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(disabled))
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_deliveries\s+SET attempts = attempts \+ 1`).
+			WithArgs(7, string(status), code, lastError, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+	}
+
+	t.Run("Signed delivery succeeds", func(t *testing.T) {
+		server, calls := receiver(t, http.StatusNoContent)
+		mocking, service := newService(t)
+		expectLoaded(mocking, server.URL, true)
+		mocking.ExpectBegin()
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_deliveries\s+SET attempts = attempts \+ 1`).
+			WithArgs(7, "succeeded", http.StatusNoContent, nil, now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_subscriptions SET consecutive_failures = 0`).WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+
+		err := service.Deliver(ctx, deliverJob(1, 8))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Failed delivery is retried", func(t *testing.T) {
+		server, calls := receiver(t, http.StatusInternalServerError)
+		mocking, service := newService(t)
+		expectLoaded(mocking, server.URL, true)
+		expectFailure(mocking, false, DeliveryPending, http.StatusInternalServerError, "subscription answered 500")
+
+		err := service.Deliver(ctx, deliverJob(1, 8))
+		assert.EqualError(t, err, "subscription answered 500")
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Unreachable subscription is retried", func(t *testing.T) {
+		server, _ := receiver(t, http.StatusOK)
+		server.Close()
+		mocking, service := newService(t)
+		expectLoaded(mocking, server.URL, true)
+		mocking.ExpectBegin()
+		mocking.ExpectQuery(`UPDATE dealls_bumble.webhook_subscriptions`).WithArgs(1, 20, now).
+			WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(false))
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_deliveries`).
+			WithArgs(7, "pending", nil, sqlmock.AnyArg(), nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+
+		err := service.Deliver(ctx, deliverJob(2, 8))
+		assert.Error(t, err)
+	})
+
+	t.Run("Last attempt fails the delivery", func(t *testing.T) {
+		server, _ := receiver(t, http.StatusBadGateway)
+		mocking, service := newService(t)
+		expectLoaded(mocking, server.URL, true)
+		expectFailure(mocking, false, DeliveryFailed, http.StatusBadGateway, "subscription answered 502")
+
+		err := service.Deliver(ctx, deliverJob(8, 8))
+		assert.EqualError(t, err, "subscription answered 502")
+	})
+
+	t.Run("Repeated failures disable the subscription", func(t *testing.T) {
+		server, _ := receiver(t, http.StatusGone)
+		mocking, service := newService(t)
+		expectLoaded(mocking, server.URL, true)
+		expectFailure(mocking, true, DeliveryFailed, http.StatusGone, "subscription answered 410")
+
+		// no retry once disabled
+		err := service.Deliver(ctx, deliverJob(3, 8))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Disabled subscription gets nothing", func(t *testing.T) {
+		server, calls := receiver(t, http.StatusOK)
+		mocking, service := newService(t)
+		expectLoaded(mocking, server.URL, false)
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_deliveries SET status = \$2`).WithArgs(7, "failed").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := service.Deliver(ctx, deliverJob(1, 8))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("Lagging replica is not read", func(t *testing.T) {
+		server, calls := receiver(t, http.StatusOK)
+		db, mocking, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		t.Cleanup(func() {
+			assert.NoError(t, mocking.ExpectationsWereMet())
+		})
+		// the replica has not received the delivery nor the subscription yet
+		lagging, lag, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating mock: %v", err)
+		}
+		lag.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_deliveries`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		lag.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_subscriptions`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reads := replica.NewSet(db, []replica.Replica{{Name: "replica_1", DB: lagging}})
+		clk := clock.Fixed(now)
+		tx := txn.NewTxManager(db)
+		service := NewService(clk, uid.Default(), tx, jobs.NewQueue(db, tx, clk), NewReplicatedRepository(reads))
+
+		expectLoaded(mocking, server.URL, true)
+		mocking.ExpectBegin()
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_deliveries`).WithArgs(7, "succeeded", http.StatusOK, nil, now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_subscriptions`).WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+
+		err = service.Deliver(ctx, deliverJob(1, 8))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Sent delivery is not sent again", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_deliveries`).WithArgs(7).
+			WillReturnRows(deliveryRow(DeliverySucceeded))
+
+		err := service.Deliver(ctx, deliverJob(1, 8))
+		assert.NoError(t, err)
+	})
+}
+
+func TestWebhook_Unit_Replay(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Replay queues the delivery again", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_deliveries`).WithArgs(7).
+			WillReturnRows(deliveryRow(DeliveryFailed))
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_subscriptions`).WithArgs(1).
+			WillReturnRows(subscriptionRow("https://crm.example.com/hooks", true))
+		mocking.ExpectBegin()
+		mocking.ExpectExec(`UPDATE dealls_bumble.webhook_deliveries SET status = \$2`).WithArgs(7, "pending").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectExec(`INSERT INTO dealls_bumble.jobs`).
+			WithArgs("webhook_delivery", `{"delivery_id":7}`, "webhook_delivery:7", 8, now, now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mocking.ExpectCommit()
+
+		err := service.Replay(ctx, 7)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Disabled subscription", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_deliveries`).WithArgs(7).
+			WillReturnRows(deliveryRow(DeliveryFailed))
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_subscriptions`).WithArgs(1).
+			WillReturnRows(subscriptionRow("https://crm.example.com/hooks", false))
+
+		err := service.Replay(ctx, 7)
+		assert.ErrorIs(t, err, ErrSubscriptionDisabled)
+	})
+
+	t.Run("Unknown delivery", func(t *testing.T) {
+		mocking, service := newService(t)
+		mocking.ExpectQuery(`SELECT .+ FROM dealls_bumble.webhook_deliveries`).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		err := service.Replay(ctx, 9)
+		assert.ErrorIs(t, err, ErrDeliveryNotFound)
+	})
+}